@@ -0,0 +1,127 @@
+package simpleredis
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// ErrWrongPass is returned when the username or password is wrong, or the user is disabled
+	ErrWrongPass = errors.New("wrong username or password")
+
+	// ErrNoPerm is returned when the user does not have permission to run a command or access a key
+	ErrNoPerm = errors.New("no permission")
+
+	// ErrNoAuth is returned when the server requires authentication, but no credentials were given
+	ErrNoAuth = errors.New("authentication required")
+)
+
+// AuthError is returned when authenticating with a Redis server fails,
+// or when the authenticated user is not allowed to set up the connection.
+// Use errors.Is with ErrWrongPass, ErrNoPerm or ErrNoAuth to find the cause.
+type AuthError struct {
+	// The username that was used, empty for the default user
+	Username string
+	// The error from the server
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	username := e.Username
+	if username == "" {
+		username = "default"
+	}
+	return "could not authenticate as " + username + ": " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes it possible to use errors.Is(err, ErrWrongPass) and similar
+func (e *AuthError) Is(target error) bool {
+	kind := authErrorKind(e.Err)
+	return kind != nil && kind == target
+}
+
+// Find the kind of authentication error, given an error reply from the server.
+// Returns nil if the error is not related to authentication.
+func authErrorKind(err error) error {
+	var replyErr redis.Error
+	if !errors.As(err, &replyErr) {
+		return nil
+	}
+	msg := string(replyErr)
+	switch {
+	case strings.HasPrefix(msg, "WRONGPASS"),
+		strings.HasPrefix(msg, "ERR invalid password"),
+		strings.HasPrefix(msg, "ERR invalid username-password pair"):
+		return ErrWrongPass
+	case strings.HasPrefix(msg, "NOPERM"):
+		return ErrNoPerm
+	case strings.HasPrefix(msg, "NOAUTH"),
+		strings.HasPrefix(msg, "ERR Client sent AUTH, but no password is set"),
+		strings.HasPrefix(msg, "ERR AUTH <password> called without any password configured"):
+		return ErrNoAuth
+	}
+	return nil
+}
+
+// Authenticate on the given connection. If username is empty, only the
+// password is sent, like for Redis versions before 6. If useHello is true,
+// the credentials are sent with HELLO, with a fallback to AUTH for servers
+// that do not support HELLO. Errors from the server are wrapped in an AuthError.
+func authenticate(conn redis.Conn, username, password string, useHello bool) error {
+	if password == "" && username == "" {
+		return nil
+	}
+	if useHello {
+		user := username
+		if user == "" {
+			user = "default"
+		}
+		_, err := conn.Do("HELLO", "2", "AUTH", user, password)
+		if err == nil {
+			return nil
+		}
+		var replyErr redis.Error
+		if !errors.As(err, &replyErr) {
+			return err
+		}
+		if !strings.HasPrefix(strings.ToLower(string(replyErr)), "err unknown command") {
+			return &AuthError{username, err}
+		}
+		// HELLO is not supported by this server, use AUTH instead
+	}
+	var err error
+	if username != "" {
+		_, err = conn.Do("AUTH", username, password)
+	} else {
+		_, err = conn.Do("AUTH", password)
+	}
+	if err != nil {
+		var replyErr redis.Error
+		if errors.As(err, &replyErr) {
+			return &AuthError{username, err}
+		}
+		return err
+	}
+	return nil
+}
+
+// Create a new connection pool given a host:port string (or the path to a
+// unix domain socket), and a username and password for a Redis 6 ACL user.
+// If the username is empty, only the password is used, for authenticating
+// as the default user.
+// Connections that fail to authenticate return an AuthError.
+// Use NewConnectionPoolOptions for sending the credentials with HELLO.
+// Close must be called when the pool is no longer needed.
+func NewConnectionPoolUser(hostColonPort, username, password string) (*ConnectionPool, error) {
+	cu := defaultSettings()
+	cu.network, cu.address = networkAndAddress(hostColonPort)
+	cu.username = username
+	cu.password = password
+	return newConnectionPoolSettings(cu)
+}
@@ -0,0 +1,117 @@
+package simpleredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+func TestAuthErrorKind(t *testing.T) {
+	for msg, kind := range map[string]error{
+		"WRONGPASS invalid username-password pair or user is disabled.": ErrWrongPass,
+		"ERR invalid password": ErrWrongPass,
+		"NOPERM this user has no permissions to run the 'select' command": ErrNoPerm,
+		"NOAUTH Authentication required.":                                 ErrNoAuth,
+		"ERR unknown command 'HELLO'":                                     nil,
+	} {
+		if got := authErrorKind(redis.Error(msg)); got != kind {
+			t.Errorf("Error, wrong kind of error for %q: %v", msg, got)
+		}
+	}
+	err := &AuthError{"someone", redis.Error("WRONGPASS invalid username-password pair")}
+	if !errors.Is(err, ErrWrongPass) || errors.Is(err, ErrNoPerm) {
+		t.Errorf("Error, errors.Is does not work for AuthError: %s", err)
+	}
+}
+
+func TestConnectionPoolUser(t *testing.T) {
+	const (
+		username = "simpleredis_test_user"
+		password = "s3cret"
+	)
+	adminPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer adminPool.Close()
+	conn := adminPool.Get(0)
+	defer conn.Close()
+
+	// Create an user that may only ping and use keys starting with "abc123_"
+	if _, err := conn.Do("ACL", "SETUSER", username, "reset", "on", ">"+password, "~abc123_*", "+ping", "+get", "+set", "+del", "+hello", "+auth"); err != nil {
+		t.Skipf("ACL users are not supported by this Redis server: %s", err)
+	}
+	defer conn.Do("ACL", "DELUSER", username)
+
+	userPool, err := NewConnectionPoolUser(testHost, username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer userPool.Close()
+	if err := userPool.Ping(); err != nil {
+		t.Errorf("Error, could not ping as an ACL user: %s", err)
+	}
+
+	// The same user, with HELLO
+	helloPool, err := NewConnectionPoolURL("redis://" + username + ":" + password + "@" + testHost + "?hello=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helloPool.Close()
+	if err := helloPool.Ping(); err != nil {
+		t.Errorf("Error, could not ping as an ACL user with HELLO: %s", err)
+	}
+	helloOptionsPool, err := NewConnectionPoolOptions(testHost, &PoolOptions{Username: username, Password: password, UseHello: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helloOptionsPool.Close()
+	if err := helloOptionsPool.Ping(); err != nil {
+		t.Errorf("Error, could not ping as an ACL user with HELLO from the options: %s", err)
+	}
+
+	// A wrong password
+	wrongPool, err := NewConnectionPoolUser(testHost, username, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrongPool.Close()
+	err = wrongPool.Ping()
+	if !errors.Is(err, ErrWrongPass) {
+		t.Errorf("Error, expected a wrong password error, got: %v", err)
+	}
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || authErr.Username != username {
+		t.Errorf("Error, expected an AuthError for %s, got: %v", username, err)
+	}
+
+	// The user is not allowed to select another database
+	dbPool, err := NewConnectionPoolURL("redis://" + username + ":" + password + "@" + testHost + "/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+	if err := dbPool.Ping(); !errors.Is(err, ErrNoPerm) || errors.Is(err, ErrWrongPass) {
+		t.Errorf("Error, expected a permission error, got: %v", err)
+	}
+}
+
+func TestPoolOptionsHello(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	var hello []string
+	s.Handle("HELLO", func(args []string) interface{} {
+		hello = args
+		return []interface{}{}
+	})
+	pool, err := NewConnectionPoolOptions(s.Addr, &PoolOptions{Username: "bob", Password: "s3cret", UseHello: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkLeaks(pool).Close()
+	if err := pool.Ping(); err != nil {
+		t.Errorf("Error, could not ping! %s", err)
+	}
+	if len(hello) != 5 || hello[2] != "AUTH" || hello[3] != "bob" || hello[4] != "s3cret" {
+		t.Errorf("Error, the credentials should be sent with HELLO: %v", hello)
+	}
+}
@@ -0,0 +1,451 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrNotExecuted is returned by a BatchResult before the batch is executed
+var ErrNotExecuted = errors.New("the batch has not been executed")
+
+// Batch is a list of operations that are sent to the server together, and
+// executed with one round trip, which is much faster than one round trip
+// per operation when there are many operations. The operations are not
+// executed atomically.
+type Batch struct {
+	pool    *ConnectionPool
+	dbindex int
+	ctx     context.Context
+	ops     []batchOp
+}
+
+// An operation in a batch
+type batchOp struct {
+	command string
+	key     string
+	args    []interface{}
+	// nil for operations that are only needed for keeping the data consistent
+	result *BatchResult
+	// Called with the reply, if there was no error, after the result is set.
+	// Returns the operations that need the reply, for another round trip.
+	after func(reply interface{}) []batchOp
+}
+
+// BatchResult is the result of an operation in a batch.
+// The result is available after the batch has been executed.
+type BatchResult struct {
+	command string
+	key     string
+	reply   interface{}
+	err     error
+}
+
+// BatchList is used for adding list operations to a batch
+type BatchList struct {
+	b  *Batch
+	id string
+}
+
+// BatchSet is used for adding set operations to a batch
+type BatchSet struct {
+	b  *Batch
+	id string
+}
+
+// BatchHashMap is used for adding hash map operations to a batch
+type BatchHashMap struct {
+	b  *Batch
+	id string
+}
+
+// BatchKeyValue is used for adding key/value operations to a batch
+type BatchKeyValue struct {
+	b  *Batch
+	id string
+}
+
+/* --- Batch functions --- */
+
+// Create a new batch of operations, for the database index of the pool
+func (pool *ConnectionPool) NewBatch() *Batch {
+	return &Batch{pool: pool, dbindex: pool.state().dbindex}
+}
+
+// Select a different database
+func (b *Batch) SelectDatabase(dbindex int) {
+	b.dbindex = dbindex
+}
+
+// WithContext returns a copy of the batch where Exec uses the given context,
+// so that it is aborted when the context is done
+func (b *Batch) WithContext(ctx context.Context) *Batch {
+	c := *b
+	c.ctx = ctx
+	c.ops = append([]batchOp(nil), b.ops...)
+	return &c
+}
+
+// Len returns the number of operations that are waiting to be executed
+func (b *Batch) Len() int {
+	n := 0
+	for _, op := range b.ops {
+		if op.result != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Add an operation to the batch
+func (b *Batch) add(command, key string, args ...interface{}) *BatchResult {
+	result := &BatchResult{command: command, key: key, err: ErrNotExecuted}
+	b.ops = append(b.ops, batchOp{command: command, key: key, args: append([]interface{}{key}, args...), result: result})
+	return result
+}
+
+// Execute all the operations in the batch with one round trip, and set the
+// results. Returns the first error of the operations, if any. The batch is
+// empty afterwards, and can be used for more operations.
+// For servers without HPEXPIRE, removing hash map elements needs another
+// round trip, for removing the expiration times of their fields.
+func (b *Batch) Exec() error {
+	ops := b.ops
+	b.ops = nil
+	if len(ops) == 0 {
+		return nil
+	}
+	conn := b.pool.get(b.ctx, b.dbindex)
+	defer conn.Close()
+	var firstErr error
+	for len(ops) > 0 {
+		var err error
+		if ops, err = execOps(conn, ops); firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Execute the given operations with one round trip, and set the results.
+// Returns the operations for the next round trip, and the first error.
+func execOps(conn redis.Conn, ops []batchOp) ([]batchOp, error) {
+	for _, op := range ops {
+		if err := conn.Send(op.command, op.args...); err != nil {
+			return nil, failOps(ops, err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, failOps(ops, err)
+	}
+	var next []batchOp
+	var firstErr error
+	for i, op := range ops {
+		reply, err := conn.Receive()
+		if err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				// The connection failed, no more replies can be received
+				return nil, failOps(ops[i:], err)
+			}
+		}
+		if op.result != nil {
+			op.result.reply, op.result.err = reply, wrapError(op.command, op.key, err)
+			if firstErr == nil {
+				firstErr = op.result.err
+			}
+		}
+		if op.after != nil && err == nil {
+			next = append(next, op.after(reply)...)
+		}
+	}
+	return next, firstErr
+}
+
+// Set the given error for all the given operations, and return it
+func failOps(ops []batchOp, err error) error {
+	var wrapped error
+	for _, op := range ops {
+		if op.result != nil {
+			op.result.err = wrapError(op.command, op.key, err)
+			if wrapped == nil {
+				wrapped = op.result.err
+			}
+		}
+	}
+	if wrapped == nil {
+		wrapped = wrapError("", "", err)
+	}
+	return wrapped
+}
+
+// Err returns the error of the operation, or nil if it was successful
+func (r *BatchResult) Err() error {
+	return r.err
+}
+
+// String returns the reply of the operation as a string
+func (r *BatchResult) String() (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+	// For instance the reply of INCR
+	if n, ok := r.reply.(int64); ok {
+		return strconv.FormatInt(n, 10), nil
+	}
+	s, err := redis.String(r.reply, nil)
+	return s, wrapError(r.command, r.key, err)
+}
+
+// Strings returns the reply of the operation as a slice of strings
+func (r *BatchResult) Strings() ([]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	strs, err := redis.Strings(r.reply, nil)
+	return strs, wrapError(r.command, r.key, err)
+}
+
+// Int64 returns the reply of the operation as an integer
+func (r *BatchResult) Int64() (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := redis.Int64(r.reply, nil)
+	return n, wrapError(r.command, r.key, err)
+}
+
+// Bool returns the reply of the operation as a boolean
+func (r *BatchResult) Bool() (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+	b, err := redis.Bool(r.reply, nil)
+	return b, wrapError(r.command, r.key, err)
+}
+
+/* --- BatchList functions --- */
+
+// List returns a value for adding operations on the list with the given id to the batch
+func (b *Batch) List(id string) *BatchList {
+	return &BatchList{b, b.pool.structureID(id)}
+}
+
+// Add an element to the start of the list, like List.AddStart
+func (bl *BatchList) AddStart(value string) *BatchResult {
+	return bl.b.add("RPUSH", bl.id, value)
+}
+
+// Add an element to the end of the list, like List.AddEnd
+func (bl *BatchList) AddEnd(value string) *BatchResult {
+	return bl.b.add("LPUSH", bl.id, value)
+}
+
+// Default Add, aliased to BatchList.AddStart, like List.Add
+func (bl *BatchList) Add(value string) *BatchResult {
+	return bl.AddStart(value)
+}
+
+// Get an element of the list, given an index. Use String for the result.
+func (bl *BatchList) Get(index int64) *BatchResult {
+	return bl.b.add("LINDEX", bl.id, index)
+}
+
+// Get all elements of the list. Use Strings for the result.
+func (bl *BatchList) All() *BatchResult {
+	return bl.b.add("LRANGE", bl.id, "0", "-1")
+}
+
+// Get the size of the list. Use Int64 for the result.
+func (bl *BatchList) Size() *BatchResult {
+	return bl.b.add("LLEN", bl.id)
+}
+
+// Trim the list to the elements from start to stop, inclusive
+func (bl *BatchList) Trim(start, stop int64) *BatchResult {
+	return bl.b.add("LTRIM", bl.id, start, stop)
+}
+
+// Remove the list
+func (bl *BatchList) Remove() *BatchResult {
+	return bl.b.add("DEL", bl.id)
+}
+
+/* --- BatchSet functions --- */
+
+// Set returns a value for adding operations on the set with the given id to the batch
+func (b *Batch) Set(id string) *BatchSet {
+	return &BatchSet{b, b.pool.structureID(id)}
+}
+
+// Add an element to the set
+func (bs *BatchSet) Add(value string) *BatchResult {
+	return bs.b.add("SADD", bs.id, value)
+}
+
+// Check if a given value is in the set. Use Bool for the result.
+func (bs *BatchSet) Has(value string) *BatchResult {
+	return bs.b.add("SISMEMBER", bs.id, value)
+}
+
+// Get all elements of the set. Use Strings for the result.
+func (bs *BatchSet) All() *BatchResult {
+	return bs.b.add("SMEMBERS", bs.id)
+}
+
+// Get the size of the set. Use Int64 for the result.
+func (bs *BatchSet) Size() *BatchResult {
+	return bs.b.add("SCARD", bs.id)
+}
+
+// Remove an element from the set
+func (bs *BatchSet) Del(value string) *BatchResult {
+	return bs.b.add("SREM", bs.id, value)
+}
+
+// Remove the set
+func (bs *BatchSet) Remove() *BatchResult {
+	return bs.b.add("DEL", bs.id)
+}
+
+/* --- BatchHashMap functions --- */
+
+// HashMap returns a value for adding operations on the hash map with the given id to the batch
+func (b *Batch) HashMap(id string) *BatchHashMap {
+	return &BatchHashMap{b, b.pool.structureID(id)}
+}
+
+// Check if the expiration times of the fields may be kept in an expiry index
+func (bh *BatchHashMap) usesExpiryIndex() bool {
+	// If the support is not known yet, the expiry index may have been left by another process
+	return bh.b.pool.fieldExpirySupport() != fieldExpirySupported
+}
+
+// Remove the expiration times of the given keys, for servers without HPEXPIRE
+func (bh *BatchHashMap) clearExpiry(elementid string, keys ...string) {
+	if !bh.usesExpiryIndex() {
+		return
+	}
+	index := expiryIndexKey(bh.id)
+	bh.b.ops = append(bh.b.ops, batchOp{command: "ZREM", key: index, args: expiryArgs(index, elementid, keys)})
+}
+
+// Add an operation on the given key of an element id. For servers without
+// HPEXPIRE, the expiration time of the key is fetched first, and the reply
+// is replaced with expiredReply if the key has expired.
+func (bh *BatchHashMap) addField(command, elementid, key string, expiredReply interface{}) *BatchResult {
+	hashKey := bh.id + ":" + elementid
+	if !bh.usesExpiryIndex() {
+		return bh.b.add(command, hashKey, key)
+	}
+	index := expiryIndexKey(bh.id)
+	expired := false
+	bh.b.ops = append(bh.b.ops, batchOp{command: "ZSCORE", key: index, args: []interface{}{index, expiryMember(elementid, key)},
+		after: func(reply interface{}) []batchOp {
+			expireAt, err := redis.Float64(reply, nil)
+			expired = err == nil && int64(expireAt) <= time.Now().UnixMilli()
+			return nil
+		}})
+	result := bh.b.add(command, hashKey, key)
+	bh.b.ops[len(bh.b.ops)-1].after = func(interface{}) []batchOp {
+		if expired {
+			result.reply = expiredReply
+		}
+		return nil
+	}
+	return result
+}
+
+// Set a value given the element id (for instance a user id) and the key (for instance "password")
+func (bh *BatchHashMap) Set(elementid, key, value string) *BatchResult {
+	result := bh.b.add("HSET", bh.id+":"+elementid, key, value)
+	bh.clearExpiry(elementid, key)
+	return result
+}
+
+// Set multiple keys and values for the given element id
+func (bh *BatchHashMap) SetMap(elementid string, m map[string]string) *BatchResult {
+	key := bh.id + ":" + elementid
+	if len(m) == 0 {
+		// Nothing to set
+		return &BatchResult{command: "HSET", key: key}
+	}
+	result := bh.b.add("HSET", key, hashArgs(key, m)[1:]...)
+	bh.clearExpiry(elementid, mapKeys(m)...)
+	return result
+}
+
+// Get a value given the element id and the key. Use String for the result.
+func (bh *BatchHashMap) Get(elementid, key string) *BatchResult {
+	return bh.addField("HGET", elementid, key, nil)
+}
+
+// Check if a given element id has the given key. Use Bool for the result.
+func (bh *BatchHashMap) Has(elementid, key string) *BatchResult {
+	return bh.addField("HEXISTS", elementid, key, int64(0))
+}
+
+// Check if a given element id exists. Use Bool for the result.
+func (bh *BatchHashMap) Exists(elementid string) *BatchResult {
+	return bh.b.add("EXISTS", bh.id+":"+elementid)
+}
+
+// Remove a key for an element id
+func (bh *BatchHashMap) DelKey(elementid, key string) *BatchResult {
+	result := bh.b.add("HDEL", bh.id+":"+elementid, key)
+	bh.clearExpiry(elementid, key)
+	return result
+}
+
+// Remove an element (for instance a user)
+func (bh *BatchHashMap) Del(elementid string) *BatchResult {
+	hashKey := bh.id + ":" + elementid
+	if bh.usesExpiryIndex() {
+		// The keys that may have expiration times are removed from the
+		// expiry index when they are known, in another round trip
+		bh.b.ops = append(bh.b.ops, batchOp{command: "HKEYS", key: hashKey, args: []interface{}{hashKey},
+			after: func(reply interface{}) []batchOp {
+				keys, err := redis.Strings(reply, nil)
+				if err != nil || len(keys) == 0 {
+					return nil
+				}
+				index := expiryIndexKey(bh.id)
+				return []batchOp{{command: "ZREM", key: index, args: expiryArgs(index, elementid, keys)}}
+			}})
+	}
+	return bh.b.add("DEL", hashKey)
+}
+
+/* --- BatchKeyValue functions --- */
+
+// KeyValue returns a value for adding operations on the key/value with the given id to the batch
+func (b *Batch) KeyValue(id string) *BatchKeyValue {
+	return &BatchKeyValue{b, b.pool.structureID(id)}
+}
+
+// Set a key and value
+func (bkv *BatchKeyValue) Set(key, value string) *BatchResult {
+	return bkv.b.add("SET", bkv.id+":"+key, value)
+}
+
+// Set a key and value, with expiry
+func (bkv *BatchKeyValue) SetExpire(key, value string, expire time.Duration) *BatchResult {
+	return bkv.b.add("SET", bkv.id+":"+key, value, "PX", expire.Milliseconds())
+}
+
+// Get a value given a key. Use String for the result.
+func (bkv *BatchKeyValue) Get(key string) *BatchResult {
+	return bkv.b.add("GET", bkv.id+":"+key)
+}
+
+// Increase the value of a key. Use Int64 or String for the result.
+func (bkv *BatchKeyValue) Inc(key string) *BatchResult {
+	return bkv.b.add("INCR", bkv.id+":"+key)
+}
+
+// Remove a key
+func (bkv *BatchKeyValue) Del(key string) *BatchResult {
+	return bkv.b.add("DEL", bkv.id+":"+key)
+}
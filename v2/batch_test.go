@@ -0,0 +1,178 @@
+package simpleredis
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	batchPool := NewConnectionPoolHost(testHost)
+	defer batchPool.Close()
+	checkLeaks(batchPool)
+
+	const (
+		listname    = "abc123_test_batch_list"
+		setname     = "abc123_test_batch_set"
+		hashmapname = "abc123_test_batch_hashmap"
+		kvname      = "abc123_test_batch_kv"
+	)
+	defer NewList(batchPool, listname).Remove()
+	defer NewSet(batchPool, setname).Remove()
+	defer NewHashMap(batchPool, hashmapname).Remove()
+	defer NewKeyValue(batchPool, kvname).Remove()
+
+	b := batchPool.NewBatch()
+	list := b.List(listname)
+	hashmap := b.HashMap(hashmapname)
+	for i := 0; i < 1000; i++ {
+		s := strconv.Itoa(i)
+		list.Add(s)
+		hashmap.Set("user"+s, "name", s)
+	}
+	set := b.Set(setname)
+	set.Add("a")
+	set.Add("b")
+	has := set.Has("b")
+	size := list.Size()
+	name := hashmap.Get("user42", "name")
+	missing := hashmap.Get("user42", "nosuchkey")
+	kv := b.KeyValue(kvname)
+	kv.Set("counter", "41")
+	inc := kv.Inc("counter")
+	// INCR on a list gives an error for this operation only
+	wrongType := b.add("INCR", listname)
+
+	if n := b.Len(); n != 2009 {
+		t.Errorf("Error, wrong number of operations: %d", n)
+	}
+	if _, err := size.Int64(); err != ErrNotExecuted {
+		t.Errorf("Error, the batch should not be executed yet! %v", err)
+	}
+
+	err := b.Exec()
+	if err == nil {
+		t.Errorf("Error, expected an error for INCR on a list!")
+	}
+	if b.Len() != 0 {
+		t.Errorf("Error, the batch should be empty after Exec!")
+	}
+	if wrongType.Err() == nil {
+		t.Errorf("Error, expected an error for INCR on a list!")
+	}
+	if n, err := size.Int64(); err != nil || n != 1000 {
+		t.Errorf("Error, wrong list size: %d %v", n, err)
+	}
+	if found, err := has.Bool(); err != nil || !found {
+		t.Errorf("Error, the set should have the element! %v", err)
+	}
+	if s, err := name.String(); err != nil || s != "42" {
+		t.Errorf("Error, wrong value: %s %v", s, err)
+	}
+	if _, err := missing.String(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound! %v", err)
+	}
+	if s, err := inc.String(); err != nil || s != "42" {
+		t.Errorf("Error, wrong value: %s %v", s, err)
+	}
+
+	// The operations were executed
+	if value, err := NewHashMap(batchPool, hashmapname).Get("user999", "name"); err != nil || value != "999" {
+		t.Errorf("Error, wrong value: %s %v", value, err)
+	}
+
+	// The batch can be used again
+	all := b.List(listname).All()
+	if err := b.Exec(); err != nil {
+		t.Errorf("Error, could not execute the batch! %s", err)
+	}
+	if elements, err := all.Strings(); err != nil || len(elements) != 1000 || elements[999] != "999" {
+		t.Errorf("Error, wrong elements: %d %v", len(elements), err)
+	}
+}
+
+func TestBatchListOrder(t *testing.T) {
+	orderPool := NewConnectionPoolHost(testHost)
+	defer orderPool.Close()
+	checkLeaks(orderPool)
+
+	list := NewList(orderPool, "abc123_test_order_list")
+	defer list.Remove()
+	batchList := NewList(orderPool, "abc123_test_order_batch_list")
+	defer batchList.Remove()
+
+	list.Add("a")
+	list.AddStart("b")
+	list.AddEnd("c")
+	b := orderPool.NewBatch()
+	bl := b.List("abc123_test_order_batch_list")
+	bl.Add("a")
+	bl.AddStart("b")
+	bl.AddEnd("c")
+	if err := b.Exec(); err != nil {
+		t.Fatalf("Error, could not execute batch! %s", err)
+	}
+
+	want, err := list.All()
+	if err != nil {
+		t.Fatalf("Error, could not get elements! %s", err)
+	}
+	got, err := batchList.All()
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("Error, wrong elements: %v %v", got, err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Error, the batch gives another order: %v %v", got, want)
+			break
+		}
+	}
+}
+
+func TestBatchHashMapExpiryIndex(t *testing.T) {
+	s := serverWithoutFieldExpiry()
+	defer s.Close()
+	pool := checkLeaks(NewConnectionPoolHost(s.Addr))
+	defer pool.Close()
+	hm := NewHashMap(pool, "abc123_test_batch_expiry")
+
+	// A field that has expired, but is not removed yet
+	conn := pool.Get(hm.dbindex)
+	conn.Do("HSET", hm.id+":bob", "token", "123abc")
+	conn.Do("ZADD", expiryIndexKey(hm.id), time.Now().Add(-time.Second).UnixMilli(), expiryMember("bob", "token"))
+	conn.Close()
+	if err := hm.SetExpire("alice", "token", "456def", time.Minute); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+
+	b := pool.NewBatch()
+	bobToken, bobHas := b.HashMap(hm.id).Get("bob", "token"), b.HashMap(hm.id).Has("bob", "token")
+	aliceToken, aliceHas := b.HashMap(hm.id).Get("alice", "token"), b.HashMap(hm.id).Has("alice", "token")
+	if err := b.Exec(); err != nil {
+		t.Fatalf("Error, could not execute the batch! %s", err)
+	}
+	if _, err := bobToken.String(); err == nil {
+		t.Error("Error, the expired field should not be returned!")
+	}
+	if has, err := bobHas.Bool(); err != nil || has {
+		t.Errorf("Error, the expired field should not exist! %v", err)
+	}
+	if value, err := aliceToken.String(); err != nil || value != "456def" {
+		t.Errorf("Error, the field should still be there! %v", err)
+	}
+	if has, err := aliceHas.Bool(); err != nil || !has {
+		t.Errorf("Error, the field should still exist! %v", err)
+	}
+
+	// Removing an element also removes the expiration times of its fields
+	b.HashMap(hm.id).Del("alice")
+	if err := b.Exec(); err != nil {
+		t.Fatalf("Error, could not execute the batch! %s", err)
+	}
+	conn = pool.Get(hm.dbindex)
+	defer conn.Close()
+	if score, err := conn.Do("ZSCORE", expiryIndexKey(hm.id), expiryMember("alice", "token")); err != nil || score != nil {
+		t.Errorf("Error, the expiration time should be removed: %v %v", score, err)
+	}
+}
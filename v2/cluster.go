@@ -0,0 +1,729 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// The number of hash slots in a Redis Cluster
+	clusterSlots = 16384
+
+	// How many MOVED or ASK redirects are followed for one command
+	maxRedirects = 5
+)
+
+// The slot map and the settings that are shared by all connections of a cluster pool
+type cluster struct {
+	mut sync.RWMutex
+	// The address of the master for each hash slot, or empty if not known
+	slots  [clusterSlots]string
+	loaded bool
+	// The addresses of the nodes that are known, starting with the given ones
+	addrs []string
+	// Settings for connecting to the nodes
+	settings *connectionSettings
+}
+
+// A connection to a Redis Cluster, which consists of one connection per
+// node. Commands are sent to the node that owns the hash slot of the key,
+// and MOVED and ASK redirects are followed. Commands without keys, like
+// EXEC, are sent to the node that was used last.
+type clusterConn struct {
+	cluster *cluster
+	// Connections to the nodes, by address
+	conns map[string]redis.Conn
+	// The node that was used last
+	current string
+	// Replies that Receive should return, for commands that were sent with Send
+	pending []pendingReply
+	// MULTI was given, and is sent to the node of the first command after it
+	multi bool
+	// MULTI has been sent, and redirects can not be followed until EXEC
+	inMulti bool
+	// Subscribed to channels or patterns, which is only supported on one node
+	subscribed bool
+}
+
+// A reply that is expected from a node
+type pendingReply struct {
+	addr    string
+	command string
+	args    []interface{}
+	// The number of replies to discard first, like for a deferred MULTI
+	skip int
+	// The reply has already been received, or was never sent to a node
+	received bool
+	reply    interface{}
+	err      error
+}
+
+// How commands are sent and replies are received, with or without a context or timeout,
+// for connections that consist of several connections
+type connIO struct {
+	// The context for connecting to the servers
+	ctx     context.Context
+	do      func(conn redis.Conn, command string, args ...interface{}) (interface{}, error)
+	receive func(conn redis.Conn) (interface{}, error)
+}
+
+var plainIO = connIO{
+	ctx: context.Background(),
+	do: func(conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+		return conn.Do(command, args...)
+	},
+	receive: func(conn redis.Conn) (interface{}, error) {
+		return conn.Receive()
+	},
+}
+
+func contextIO(ctx context.Context) connIO {
+	return connIO{
+		ctx: ctx,
+		do: func(conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+			return redis.DoContext(conn, ctx, command, args...)
+		},
+		receive: func(conn redis.Conn) (interface{}, error) {
+			return redis.ReceiveContext(conn, ctx)
+		},
+	}
+}
+
+func timeoutIO(timeout time.Duration) connIO {
+	return connIO{
+		ctx: context.Background(),
+		do: func(conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+			return redis.DoWithTimeout(conn, timeout, command, args...)
+		},
+		receive: func(conn redis.Conn) (interface{}, error) {
+			return redis.ReceiveWithTimeout(conn, timeout)
+		},
+	}
+}
+
+/* --- Hash slots --- */
+
+// Find the hash slot of a key. If the key contains a hashtag, like
+// "{user1000}.following", only the hashtag is hashed.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// CRC16 with the XMODEM polynomial, as used by Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Convert an argument of a command to a string
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(arg)
+}
+
+// Find the part of a glob-style pattern that all matching keys start with,
+// if it contains a hashtag, so that all matching keys are in the same slot
+func patternKey(pattern string) (string, bool) {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			i = len(pattern)
+			continue
+		case '\\':
+			if i++; i == len(pattern) {
+				continue
+			}
+		}
+		sb.WriteByte(pattern[i])
+	}
+	prefix := sb.String()
+	start := strings.IndexByte(prefix, '{')
+	if start == -1 || strings.IndexByte(prefix[start+1:], '}') <= 0 {
+		return "", false
+	}
+	return prefix, true
+}
+
+// Find the key that decides which node a command is sent to, if any
+func commandKey(command string, args []interface{}) (string, bool) {
+	switch command {
+	case "", "PING", "ECHO", "SELECT", "AUTH", "HELLO", "QUIT", "RESET", "CLIENT",
+		"INFO", "CONFIG", "FLUSHDB", "FLUSHALL", "DBSIZE", "TIME", "COMMAND",
+		"ROLE", "ACL", "MULTI", "EXEC", "DISCARD", "UNWATCH", "PUBLISH",
+		"UNSUBSCRIBE", "PUNSUBSCRIBE", "PUBSUB", "SCRIPT", "KEYS", "RANDOMKEY",
+		"CLUSTER", "ASKING", "READONLY", "WAIT":
+		return "", false
+	case "EVAL", "EVALSHA":
+		if len(args) > 2 {
+			if n, err := strconv.Atoi(argString(args[1])); err == nil && n > 0 {
+				return argString(args[2]), true
+			}
+		}
+		return "", false
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.EqualFold(argString(arg), "STREAMS") && i+1 < len(args) {
+				return argString(args[i+1]), true
+			}
+		}
+		return "", false
+	case "XGROUP", "XINFO", "OBJECT", "MEMORY":
+		if len(args) > 1 {
+			return argString(args[1]), true
+		}
+		return "", false
+	case "SCAN":
+		for i, arg := range args {
+			if strings.EqualFold(argString(arg), "MATCH") && i+1 < len(args) {
+				return patternKey(argString(args[i+1]))
+			}
+		}
+		return "", false
+	case "PSUBSCRIBE":
+		if len(args) > 0 {
+			return patternKey(argString(args[0]))
+		}
+		return "", false
+	}
+	if len(args) == 0 {
+		return "", false
+	}
+	return argString(args[0]), true
+}
+
+// Parse a MOVED or ASK error, like "MOVED 3999 127.0.0.1:6381"
+func parseRedirect(err error) (slot int, addr string, ask bool, ok bool) {
+	replyErr, isReply := err.(redis.Error)
+	if !isReply {
+		return 0, "", false, false
+	}
+	fields := strings.Fields(string(replyErr))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return 0, "", false, false
+	}
+	slot, convErr := strconv.Atoi(fields[1])
+	if convErr != nil || slot < 0 || slot >= clusterSlots {
+		return 0, "", false, false
+	}
+	return slot, fields[2], fields[0] == "ASK", true
+}
+
+/* --- Slot map --- */
+
+// Read the slot map with CLUSTER SLOTS, using the given connection
+func (cl *cluster) refresh(conn redis.Conn) error {
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return err
+	}
+	var slots [clusterSlots]string
+	var addrs []string
+	for _, r := range ranges {
+		values, err := redis.Values(r, nil)
+		if err != nil || len(values) < 3 {
+			continue
+		}
+		start, err1 := redis.Int(values[0], nil)
+		end, err2 := redis.Int(values[1], nil)
+		node, err3 := redis.Values(values[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(node) < 2 || start < 0 || end >= clusterSlots {
+			continue
+		}
+		host, _ := redis.String(node[0], nil)
+		port, _ := redis.Int(node[1], nil)
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = addr
+		}
+		addrs = append(addrs, addr)
+	}
+	cl.mut.Lock()
+	defer cl.mut.Unlock()
+	cl.slots = slots
+	cl.loaded = true
+	for _, addr := range addrs {
+		found := false
+		for _, known := range cl.addrs {
+			if known == addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			cl.addrs = append(cl.addrs, addr)
+		}
+	}
+	return nil
+}
+
+// The address of the master of a hash slot, or empty if it is not known
+func (cl *cluster) node(slot int) string {
+	cl.mut.RLock()
+	defer cl.mut.RUnlock()
+	return cl.slots[slot]
+}
+
+// Check if the slot map has been read
+func (cl *cluster) isLoaded() bool {
+	cl.mut.RLock()
+	defer cl.mut.RUnlock()
+	return cl.loaded
+}
+
+// Update the master of a hash slot, after a MOVED redirect
+func (cl *cluster) moved(slot int, addr string) {
+	cl.mut.Lock()
+	cl.slots[slot] = addr
+	cl.mut.Unlock()
+}
+
+// Forget the slot map, after a node could not be reached, so that it is
+// read again before the next command with a key
+func (cl *cluster) invalidate() {
+	cl.mut.Lock()
+	cl.loaded = false
+	cl.mut.Unlock()
+}
+
+// The addresses of the known nodes
+func (cl *cluster) nodes() []string {
+	cl.mut.RLock()
+	defer cl.mut.RUnlock()
+	return append([]string(nil), cl.addrs...)
+}
+
+/* --- Cluster connection functions --- */
+
+// Get the connection to the node with the given address, and connect if needed
+func (c *clusterConn) conn(ctx context.Context, addr string) (redis.Conn, error) {
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	cu := *c.cluster.settings
+	cu.network, cu.address = networkAndAddress(addr)
+	conn, err := cu.dialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// Find a node that can be connected to, starting with the one that was used last
+func (c *clusterConn) anyNode(ctx context.Context) (string, error) {
+	if c.current != "" {
+		return c.current, nil
+	}
+	for addr := range c.conns {
+		return addr, nil
+	}
+	var lastErr error
+	for _, addr := range c.cluster.nodes() {
+		if _, err := c.conn(ctx, addr); err != nil {
+			lastErr = err
+			continue
+		}
+		return addr, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no cluster nodes")
+	}
+	return "", lastErr
+}
+
+// Find the node that a command should be sent to, and connect to it.
+// Returns the reply if the command should not be sent at all, and the number
+// of extra replies that are sent before the command.
+func (c *clusterConn) route(ctx context.Context, command string, args []interface{}) (addr string, skip int, local bool, reply interface{}, err error) {
+	switch command {
+	case "MULTI":
+		// Send MULTI to the node of the first command with a key, which may
+		// not be the node that was used last
+		c.multi = true
+		return "", 0, true, "OK", nil
+	case "EXEC", "DISCARD":
+		c.inMulti = false
+		if c.multi {
+			// Nothing was queued
+			c.multi = false
+			if command == "EXEC" {
+				return "", 0, true, []interface{}{}, nil
+			}
+			return "", 0, true, "OK", nil
+		}
+	}
+	key, hasKey := commandKey(command, args)
+	switch {
+	case c.subscribed && c.current != "":
+		// Subscriptions are only supported on one node
+		addr = c.current
+	case hasKey:
+		if !c.cluster.isLoaded() {
+			if addr, err = c.anyNode(ctx); err != nil {
+				return "", 0, false, nil, err
+			}
+			// The redirects are followed if the slot map can not be read
+			c.cluster.refresh(c.conns[addr])
+		}
+		if addr = c.cluster.node(keySlot(key)); addr == "" {
+			addr, err = c.anyNode(ctx)
+		}
+	default:
+		addr, err = c.anyNode(ctx)
+	}
+	if err != nil {
+		return "", 0, false, nil, err
+	}
+	conn, err := c.conn(ctx, addr)
+	if err != nil {
+		return "", 0, false, nil, err
+	}
+	if c.multi {
+		c.multi = false
+		c.inMulti = true
+		if err := conn.Send("MULTI"); err != nil {
+			return "", 0, false, nil, err
+		}
+		skip = 1
+	}
+	switch command {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		c.subscribed = true
+	}
+	c.current = addr
+	return addr, skip, false, nil, nil
+}
+
+// Follow MOVED and ASK redirects for a command. Redirects are not followed
+// within MULTI, but the slot map is still updated.
+func (c *clusterConn) redirect(io connIO, reply interface{}, err error, command string, args []interface{}) (interface{}, error) {
+	for i := 0; i < maxRedirects; i++ {
+		slot, addr, ask, ok := parseRedirect(err)
+		if !ok {
+			return reply, err
+		}
+		if !ask {
+			c.cluster.moved(slot, addr)
+		}
+		if c.inMulti {
+			return reply, err
+		}
+		conn, connErr := c.conn(io.ctx, addr)
+		if connErr != nil {
+			return nil, connErr
+		}
+		if ask {
+			if sendErr := conn.Send("ASKING"); sendErr != nil {
+				return nil, sendErr
+			}
+		} else {
+			// The slots are being moved, so read the whole slot map again
+			c.cluster.refresh(conn)
+		}
+		c.current = addr
+		reply, err = io.do(conn, command, args...)
+	}
+	return reply, err
+}
+
+// Send a command and return the reply, or receive all pending replies
+func (c *clusterConn) do(io connIO, command string, args []interface{}) (interface{}, error) {
+	if command == "" || len(c.pending) > 0 {
+		return c.doPending(io, command, args)
+	}
+	for retried := false; ; retried = true {
+		addr, _, local, reply, err := c.route(io.ctx, command, args)
+		if err == nil && local {
+			return reply, nil
+		}
+		if err == nil {
+			reply, err = io.do(c.conns[addr], command, args...)
+			reply, err = c.redirect(io, reply, err, command, args)
+		}
+		if !c.failed(io.ctx, err) || retried {
+			return reply, err
+		}
+	}
+}
+
+// Handle an error from connecting to or talking to a node. The connections
+// that have failed are closed, and the slot map is read again, since a node
+// may have failed over to another one. Returns true if the command can be
+// sent again.
+func (c *clusterConn) failed(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(redis.Error); ok {
+		return false
+	}
+	for addr, conn := range c.conns {
+		if conn.Err() != nil {
+			conn.Close()
+			delete(c.conns, addr)
+		}
+	}
+	if _, ok := c.conns[c.current]; !ok {
+		c.current = ""
+	}
+	c.cluster.invalidate()
+	return !c.inMulti && !c.subscribed && ctx.Err() == nil
+}
+
+// Send a command, if given, and receive all pending replies. Like for a
+// single connection, the last reply and the first error is returned, or a
+// slice of all replies if no command is given.
+func (c *clusterConn) doPending(io connIO, command string, args []interface{}) (interface{}, error) {
+	if command != "" {
+		if err := c.Send(command, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, 0, len(c.pending))
+	var firstErr error
+	for len(c.pending) > 0 {
+		reply, err := c.receivePending(io)
+		if err != nil {
+			replyErr, ok := err.(redis.Error)
+			if !ok {
+				return nil, err
+			}
+			reply = replyErr
+			if firstErr == nil {
+				firstErr = replyErr
+			}
+		}
+		replies = append(replies, reply)
+	}
+	if command == "" {
+		return replies, nil
+	}
+	if len(replies) == 0 {
+		return nil, firstErr
+	}
+	return replies[len(replies)-1], firstErr
+}
+
+// Read a pending reply from its node
+func (c *clusterConn) read(io connIO, p *pendingReply) error {
+	if p.received {
+		return nil
+	}
+	conn := c.conns[p.addr]
+	for ; p.skip > 0; p.skip-- {
+		if _, err := io.receive(conn); err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				return err
+			}
+		}
+	}
+	p.reply, p.err = io.receive(conn)
+	p.received = true
+	if _, ok := p.err.(redis.Error); p.err != nil && !ok {
+		// The node may have failed over to another one
+		c.cluster.invalidate()
+		return p.err
+	}
+	return nil
+}
+
+// Receive the next pending reply, and follow a redirect if needed
+func (c *clusterConn) receivePending(io connIO) (interface{}, error) {
+	p := &c.pending[0]
+	if err := c.read(io, p); err != nil {
+		c.pending = c.pending[1:]
+		return nil, err
+	}
+	c.pending = c.pending[1:]
+	if _, _, _, ok := parseRedirect(p.err); ok {
+		// Receive all other pending replies first, so that the connections
+		// can be used for following the redirect
+		for i := range c.pending {
+			if err := c.read(io, &c.pending[i]); err != nil {
+				return nil, err
+			}
+		}
+		return c.redirect(io, p.reply, p.err, p.command, p.args)
+	}
+	return p.reply, p.err
+}
+
+// Receive a reply, which is either pending, or a message when subscribed
+func (c *clusterConn) receive(io connIO) (interface{}, error) {
+	if len(c.pending) > 0 {
+		return c.receivePending(io)
+	}
+	if c.current == "" {
+		return nil, errors.New("no replies are pending")
+	}
+	reply, err := io.receive(c.conns[c.current])
+	// Leave subscribe mode when there are no more subscriptions
+	if values, ok := reply.([]interface{}); ok && len(values) == 3 {
+		kind, _ := redis.String(values[0], nil)
+		if count, ok := values[2].(int64); ok && count == 0 && (kind == "unsubscribe" || kind == "punsubscribe") {
+			c.subscribed = false
+		}
+	}
+	return reply, err
+}
+
+func (c *clusterConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return c.do(plainIO, strings.ToUpper(command), args)
+}
+
+func (c *clusterConn) DoContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	return c.do(contextIO(ctx), strings.ToUpper(command), args)
+}
+
+func (c *clusterConn) DoWithTimeout(timeout time.Duration, command string, args ...interface{}) (interface{}, error) {
+	return c.do(timeoutIO(timeout), strings.ToUpper(command), args)
+}
+
+func (c *clusterConn) Send(command string, args ...interface{}) error {
+	command = strings.ToUpper(command)
+	addr, skip, local, reply, err := c.route(context.Background(), command, args)
+	if err != nil {
+		c.failed(context.Background(), err)
+		return err
+	}
+	if local {
+		c.pending = append(c.pending, pendingReply{command: command, received: true, reply: reply})
+		return nil
+	}
+	if err := c.conns[addr].Send(command, args...); err != nil {
+		return err
+	}
+	switch command {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		// The replies are received as messages
+		return nil
+	}
+	if c.subscribed {
+		return nil
+	}
+	c.pending = append(c.pending, pendingReply{addr: addr, command: command, args: args, skip: skip})
+	return nil
+}
+
+func (c *clusterConn) Flush() error {
+	for _, conn := range c.conns {
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *clusterConn) Receive() (interface{}, error) {
+	return c.receive(plainIO)
+}
+
+func (c *clusterConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return c.receive(contextIO(ctx))
+}
+
+func (c *clusterConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.receive(timeoutIO(timeout))
+}
+
+// Err returns an error if the connection to any of the nodes has failed
+func (c *clusterConn) Err() error {
+	for _, conn := range c.conns {
+		if err := conn.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *clusterConn) Close() error {
+	var firstErr error
+	for addr, conn := range c.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.conns, addr)
+	}
+	return firstErr
+}
+
+/* --- Cluster pool functions --- */
+
+// The id of a data structure. For Redis Cluster, the id is used as a
+// hashtag, so that all keys of the data structure are in the same hash slot.
+func (pool *ConnectionPool) structureID(id string) string {
+	if pool.state().cluster == nil {
+		return id
+	}
+	return "{" + id + "}"
+}
+
+// Create a new connection pool for a Redis Cluster, given the host:port
+// addresses of one or more of the nodes. The slot map is read with
+// CLUSTER SLOTS, and each command is sent to the node that owns the hash
+// slot of its key. MOVED and ASK redirects are followed, and the slot map
+// is read again when slots have moved.
+//
+// The ids of data structures that are created with this pool are used as
+// hashtags, like "{id}", so that all keys of one data structure are on the
+// same node. Transactions, pipelines and scripts must only use keys of one
+// data structure, or keys with the same hashtag. Pub/sub and keyspace
+// notifications only work for one node per subscriber.
+//
+// The options may be nil, for using the defaults. Only database 0 is
+// available in a cluster, and replicas are not supported.
+// Close must be called when the pool is no longer needed.
+func NewConnectionPoolCluster(addrs []string, options *PoolOptions) (*ConnectionPool, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("at least one cluster node address is needed")
+	}
+	if options != nil && options.DatabaseIndex != 0 {
+		return nil, errors.New("only database 0 is available in a cluster")
+	}
+	cu, err := options.checkedSettings(false)
+	if err != nil {
+		return nil, err
+	}
+	cl := &cluster{
+		addrs:    append([]string(nil), addrs...),
+		settings: cu,
+	}
+	pool := newPool(cu, func(ctx context.Context) (redis.Conn, error) {
+		// The nodes are connected to when needed
+		return &clusterConn{cluster: cl, conns: make(map[string]redis.Conn)}, nil
+	})
+	pool.mutableState().cluster = cl
+	return pool, nil
+}
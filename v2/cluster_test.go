@@ -0,0 +1,221 @@
+package simpleredis
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+func TestKeySlot(t *testing.T) {
+	for key, slot := range map[string]int{
+		"123456789":            12739,
+		"foo":                  12182,
+		"{user1000}.following": keySlot("user1000"),
+		"{}.a":                 keySlot("{}.a"),
+		"a{b}c{d}":             keySlot("b"),
+	} {
+		if got := keySlot(key); got != slot {
+			t.Errorf("Error, wrong slot for %q: %d", key, got)
+		}
+	}
+	if key, ok := patternKey(`{abc\*}:*`); !ok || key != "{abc*}:" {
+		t.Errorf("Error, wrong key for pattern: %q %v", key, ok)
+	}
+	if _, ok := patternKey(`abc*{def}`); ok {
+		t.Error("Error, the hashtag is not in the fixed part of the pattern")
+	}
+}
+
+func TestClusterPool(t *testing.T) {
+	cl := redistest.NewCluster(3)
+	defer cl.Close()
+
+	// Only one node is given, the others are found with CLUSTER SLOTS
+	clusterPool, err := NewConnectionPoolCluster(cl.Addrs()[:1], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clusterPool.Close()
+	checkLeaks(clusterPool)
+
+	users := NewHashMap(clusterPool, "abc123_test_cluster_users")
+	kv := NewKeyValue(clusterPool, "abc123_test_cluster_kv")
+	list := NewList(clusterPool, "abc123_test_cluster_list")
+	defer users.Remove()
+	defer kv.Remove()
+	defer list.Remove()
+
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		if err := users.Set(name, "password", "x"); err != nil {
+			t.Fatalf("Error, could not set value! %s", err)
+		}
+	}
+	// All elements of the hash map are on the same node
+	owner := cl.Owner(users.RedisKey("alice"))
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		if !owner.Exists(0, users.RedisKey(name)) {
+			t.Errorf("Error, %s should be on the same node as alice", name)
+		}
+	}
+	all, err := users.All()
+	sort.Strings(all)
+	if err != nil || len(all) != 4 || all[0] != "alice" {
+		t.Errorf("Error, wrong elements: %v %v", all, err)
+	}
+
+	if err := list.Add("a"); err != nil {
+		t.Errorf("Error, could not add to list! %s", err)
+	}
+	if err := kv.Set("a", "1"); err != nil {
+		t.Errorf("Error, could not set value! %s", err)
+	}
+
+	// A batch with keys on several nodes
+	b := clusterPool.NewBatch()
+	size := b.List("abc123_test_cluster_list").Size()
+	value := b.KeyValue("abc123_test_cluster_kv").Get("a")
+	password := b.HashMap("abc123_test_cluster_users").Get("bob", "password")
+	if err := b.Exec(); err != nil {
+		t.Fatalf("Error, could not execute batch! %s", err)
+	}
+	if n, err := size.Int64(); err != nil || n != 1 {
+		t.Errorf("Error, wrong list size: %d %v", n, err)
+	}
+	if s, err := value.String(); err != nil || s != "1" {
+		t.Errorf("Error, wrong value: %s %v", s, err)
+	}
+	if s, err := password.String(); err != nil || s != "x" {
+		t.Errorf("Error, wrong password: %s %v", s, err)
+	}
+
+	// A transaction for one hash map
+	err = clusterPool.RetryTransaction(3, func(tx *Transaction) error {
+		if err := tx.WatchHashMap("abc123_test_cluster_users", "bob"); err != nil {
+			return err
+		}
+		tx.HashMap("abc123_test_cluster_users").Set("bob", "password", "y")
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Error, the transaction failed! %s", err)
+	}
+	if s, err := users.Get("bob", "password"); err != nil || s != "y" {
+		t.Errorf("Error, wrong password: %s %v", s, err)
+	}
+
+	// Move the slot of the key/value to another node, which gives MOVED
+	slot := keySlot(kv.RedisKey("a"))
+	from := cl.Owner(kv.RedisKey("a"))
+	to := 0
+	for i, s := range cl.Servers {
+		if s != from {
+			to = i
+			break
+		}
+	}
+	cl.MoveSlot(slot, to)
+	if s, err := kv.Get("a"); err != nil || s != "1" {
+		t.Errorf("Error, wrong value after MOVED: %s %v", s, err)
+	}
+
+	// Migrate the slot of the list to another node, which gives ASK
+	slot = keySlot(list.RedisKey())
+	from = cl.Owner(list.RedisKey())
+	for i, s := range cl.Servers {
+		if s != from {
+			to = i
+			break
+		}
+	}
+	cl.StartMigration(slot, to)
+	if all, err := list.All(); err != nil || len(all) != 1 || all[0] != "a" {
+		t.Errorf("Error, wrong elements after ASK: %v %v", all, err)
+	}
+	cl.MoveSlot(slot, to)
+	if err := list.Add("b"); err != nil {
+		t.Errorf("Error, could not add to list after the migration! %s", err)
+	}
+	if !cl.Servers[to].Exists(0, list.RedisKey()) {
+		t.Error("Error, the list should be on the new node")
+	}
+}
+
+func TestClusterTransactions(t *testing.T) {
+	cl := redistest.NewCluster(3)
+	defer cl.Close()
+	// Only one connection, so that it is used again for each transaction
+	clusterPool, err := NewConnectionPoolCluster(cl.Addrs(), &PoolOptions{MaxIdle: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkLeaks(clusterPool).Close()
+
+	// Find two sets on different nodes
+	owner := cl.Owner(NewSet(clusterPool, "abc123_test_cluster_tx0").RedisKey())
+	ids := []string{"abc123_test_cluster_tx0"}
+	for i := 1; len(ids) < 2; i++ {
+		id := "abc123_test_cluster_tx" + strconv.Itoa(i)
+		if cl.Owner(NewSet(clusterPool, id).RedisKey()) != owner {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		tx := clusterPool.NewTransaction()
+		tx.Set(id).Add("a")
+		if err := tx.Exec(); err != nil {
+			t.Errorf("Error, could not execute transaction! %s", err)
+		}
+		tx.Close()
+		key := NewSet(clusterPool, id).RedisKey()
+		if !cl.Owner(key).Exists(0, key) {
+			t.Errorf("Error, the set should be on the node that owns it: %s", key)
+		}
+	}
+}
+
+func TestClusterPoolOptions(t *testing.T) {
+	if _, err := NewConnectionPoolCluster(nil, nil); err == nil {
+		t.Error("Error, cluster node addresses should be required")
+	}
+	if _, err := NewConnectionPoolCluster([]string{"localhost:7000"}, &PoolOptions{DatabaseIndex: 1}); err == nil {
+		t.Error("Error, only database 0 should be allowed")
+	}
+	if _, err := NewConnectionPoolCluster([]string{"localhost:7000"}, &PoolOptions{Replicas: []string{"localhost:7001"}}); err == nil {
+		t.Error("Error, replicas should not be supported")
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	cl := redistest.NewCluster(3)
+	defer cl.Close()
+	clusterPool, err := NewConnectionPoolCluster(cl.Addrs(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clusterPool.Close()
+	checkLeaks(clusterPool)
+
+	kv := NewKeyValue(clusterPool, "abc123_test_cluster_failover")
+	if err := kv.Set("a", "1"); err != nil {
+		t.Fatalf("Error, could not set value! %s", err)
+	}
+	// The owner of the slot goes down, and another node takes over the slot
+	owner := cl.Owner(kv.RedisKey("a"))
+	to := 0
+	for i, s := range cl.Servers {
+		if s != owner {
+			to = i
+			break
+		}
+	}
+	owner.Close()
+	cl.MoveSlot(keySlot(kv.RedisKey("a")), to)
+	if s, err := kv.Get("a"); err != nil || s != "1" {
+		t.Errorf("Error, wrong value after the failover: %s %v", s, err)
+	}
+	if err := kv.Set("b", "2"); err != nil {
+		t.Errorf("Error, could not set value after the failover! %s", err)
+	}
+}
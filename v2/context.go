@@ -0,0 +1,131 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// A connection where Do and Receive use the given context,
+// so that the command is aborted when the context is done
+type contextConn struct {
+	redis.Conn
+	ctx context.Context
+}
+
+func (c *contextConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	reply, err := redis.DoContext(c.Conn, c.ctx, commandName, args...)
+	return reply, c.contextError(err)
+}
+
+func (c *contextConn) Receive() (interface{}, error) {
+	reply, err := redis.ReceiveContext(c.Conn, c.ctx)
+	return reply, c.contextError(err)
+}
+
+// Return the error of the context instead of the given error, if the context
+// is done. The read deadline of the connection is set to the deadline of the
+// context, so a timeout may be reported just before the context is done.
+func (c *contextConn) contextError(err error) error {
+	var replyErr redis.Error
+	if err == nil || errors.As(err, &replyErr) {
+		return err
+	}
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if deadline, ok := c.ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// A connection that could not be retrieved from the pool.
+// All methods return the error.
+type errorConn struct {
+	err error
+}
+
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Close() error                                   { return nil }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
+
+// Get a connection from the pool, given a context and a database index.
+// If the context is nil, the connection is retrieved without a context.
+func (pool *ConnectionPool) get(ctx context.Context, dbindex int) redis.Conn {
+	if ctx == nil {
+		return pool.Get(dbindex)
+	}
+	conn, err := pool.GetContext(ctx, dbindex)
+	if err != nil {
+		return errorConn{err}
+	}
+	return conn
+}
+
+// GetContext returns a connection from the connection pool, given a context
+// and a database index. The context is used while waiting for a connection,
+// and for all commands that are sent with Do. The connection must be closed
+// after use, to return it to the pool.
+func (pool *ConnectionPool) GetContext(ctx context.Context, dbindex int) (redis.Conn, error) {
+	redisPool := (*redis.Pool)(pool)
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var c redis.Conn = &contextConn{conn, ctx}
+	// Connections in the pool have selected the default database index
+	if defaultIndex := pool.state().dbindex; dbindex != defaultIndex {
+		if _, err := c.Do("SELECT", dbindex); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c = &selectedConn{c, defaultIndex}
+	}
+	return c, nil
+}
+
+// PingContext sends a PING command to the server, given a context
+func (pool *ConnectionPool) PingContext(ctx context.Context) error {
+	conn := pool.get(ctx, pool.state().dbindex)
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return wrapError("PING", "", err)
+}
+
+// WithContext returns a copy of the list where all operations use the
+// given context, so that they are aborted when the context is done
+func (rl *List) WithContext(ctx context.Context) *List {
+	c := *rl
+	c.ctx = ctx
+	return &c
+}
+
+// WithContext returns a copy of the set where all operations use the
+// given context, so that they are aborted when the context is done
+func (rs *Set) WithContext(ctx context.Context) *Set {
+	c := *rs
+	c.ctx = ctx
+	return &c
+}
+
+// WithContext returns a copy of the hash map where all operations use the
+// given context, so that they are aborted when the context is done
+func (rh *HashMap) WithContext(ctx context.Context) *HashMap {
+	c := *rh
+	c.ctx = ctx
+	return &c
+}
+
+// WithContext returns a copy of the key/value where all operations use the
+// given context, so that they are aborted when the context is done
+func (rkv *KeyValue) WithContext(ctx context.Context) *KeyValue {
+	c := *rkv
+	c.ctx = ctx
+	return &c
+}
@@ -0,0 +1,92 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithContext(t *testing.T) {
+	const (
+		listname = "abc123_test_context_123abc"
+		testdata = "123abc"
+	)
+	ctxPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer ctxPool.Close()
+
+	ctx := context.Background()
+	if err := ctxPool.PingContext(ctx); err != nil {
+		t.Errorf("Error, could not ping with a context: %s", err)
+	}
+	list := NewList(ctxPool, listname)
+	list.SelectDatabase(1)
+	ctxList := list.WithContext(ctx)
+	if err := ctxList.Add(testdata); err != nil {
+		t.Errorf("Error, could not add item to list! %s", err)
+	}
+	if items, err := ctxList.All(); err != nil || len(items) != 1 || items[0] != testdata {
+		t.Errorf("Error, wrong list contents! %v %v", items, err)
+	}
+	if list.ctx != nil {
+		t.Error("Error, WithContext should not modify the original list")
+	}
+
+	// A cancelled context aborts the operations
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := list.WithContext(cancelled).All(); !errors.Is(err, context.Canceled) || errors.Is(err, ErrConnection) {
+		t.Errorf("Error, expected the operation to be cancelled, got: %v", err)
+	}
+	kv := NewKeyValue(ctxPool, listname).WithContext(cancelled)
+	if err := kv.Set("a", testdata); !errors.Is(err, context.Canceled) {
+		t.Errorf("Error, expected the operation to be cancelled, got: %v", err)
+	}
+	if _, err := NewSet(ctxPool, listname).WithContext(cancelled).Has(testdata); !errors.Is(err, context.Canceled) {
+		t.Errorf("Error, expected the operation to be cancelled, got: %v", err)
+	}
+	if _, err := NewHashMap(ctxPool, listname).WithContext(cancelled).Get("a", "b"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Error, expected the operation to be cancelled, got: %v", err)
+	}
+
+	if err := ctxList.Remove(); err != nil {
+		t.Errorf("Error, could not remove list! %s", err)
+	}
+}
+
+func TestGetContextDeadline(t *testing.T) {
+	ctxPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer ctxPool.Close()
+
+	// A blocking command is aborted when the deadline is reached
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	conn, err := ctxPool.GetContext(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	_, err = conn.Do("XREAD", "BLOCK", 5000, "STREAMS", "abc123_test_context_stream_123abc", "$")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Error, expected the deadline to be exceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Error, the command was not aborted in time: %s", elapsed)
+	}
+}
+
+func TestStreamContextDeadline(t *testing.T) {
+	ctxPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer ctxPool.Close()
+
+	// Waiting for new entries is aborted when the deadline is reached, and
+	// the error is from the context, not a connection error
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	stream := NewStream(ctxPool, "abc123_test_context_stream").WithContext(ctx)
+	_, err := stream.Read("$", 0, -1)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrConnection) {
+		t.Errorf("Error, expected the deadline to be exceeded, got: %v", err)
+	}
+}
@@ -20,17 +20,25 @@ func (c *RedisCreator) SelectDatabase(dbindex int) {
 }
 
 func (c *RedisCreator) NewList(id string) (pinterface.IList, error) {
-	return &List{c.pool, id, c.dbindex}, nil
+	return &List{pool: c.pool, id: c.pool.structureID(id), dbindex: c.dbindex}, nil
 }
 
 func (c *RedisCreator) NewSet(id string) (pinterface.ISet, error) {
-	return &Set{c.pool, id, c.dbindex}, nil
+	return &Set{pool: c.pool, id: c.pool.structureID(id), dbindex: c.dbindex}, nil
 }
 
 func (c *RedisCreator) NewHashMap(id string) (pinterface.IHashMap, error) {
-	return &HashMap{c.pool, id, c.dbindex}, nil
+	return &HashMap{pool: c.pool, id: c.pool.structureID(id), dbindex: c.dbindex}, nil
 }
 
 func (c *RedisCreator) NewKeyValue(id string) (pinterface.IKeyValue, error) {
-	return &KeyValue{c.pool, id, c.dbindex}, nil
+	return &KeyValue{pool: c.pool, id: c.pool.structureID(id), dbindex: c.dbindex}, nil
+}
+
+func (c *RedisCreator) NewSortedSet(id string) (*SortedSet, error) {
+	return &SortedSet{pool: c.pool, id: c.pool.structureID(id), dbindex: c.dbindex}, nil
+}
+
+func (c *RedisCreator) NewStream(id string) (*Stream, error) {
+	return &Stream{pool: c.pool, id: c.pool.structureID(id), dbindex: c.dbindex}, nil
 }
@@ -0,0 +1,109 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrConnection can be used with errors.Is, to check if an error is a ConnectionError
+var ErrConnection = errors.New("connection error")
+
+// ConnectionError is returned when there is a problem with the connection to
+// the Redis server, like a network error, a timeout or an exhausted pool
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return "connection error: " + e.Err.Error()
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes it possible to use errors.Is(err, ErrConnection)
+func (e *ConnectionError) Is(target error) bool {
+	return target == ErrConnection
+}
+
+// CommandError is returned by the data structure methods when a Redis command
+// fails. Use errors.Is with ErrNotFound, ErrConnection or ErrNoPerm to find
+// the cause, or errors.As with ConnectionError or redis.Error.
+type CommandError struct {
+	// The Redis command, like "LINDEX"
+	Command string
+	// The key of the data structure
+	Key string
+	// The underlying error
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	prefix := e.Command
+	if e.Key != "" {
+		prefix += " " + e.Key
+	}
+	if errors.Is(e.Err, redis.ErrNil) {
+		return prefix + ": " + ErrNotFound.Error()
+	}
+	return prefix + ": " + e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes it possible to use errors.Is(err, ErrNotFound) when the key or
+// element does not exist, and errors.Is(err, ErrNoPerm) for ACL errors
+func (e *CommandError) Is(target error) bool {
+	if target == ErrNotFound {
+		return errors.Is(e.Err, redis.ErrNil)
+	}
+	kind := authErrorKind(e.Err)
+	return kind != nil && kind == target
+}
+
+// Check if an error from a connection or a reply conversion is caused by the
+// connection, and not by the reply from the server
+func isConnectionError(err error) bool {
+	var (
+		replyErr redis.Error
+		authErr  *AuthError
+		connErr  *ConnectionError
+		numErr   *strconv.NumError
+	)
+	switch {
+	case errors.As(err, &connErr):
+		return false // already wrapped
+	case errors.Is(err, redis.ErrNil), errors.As(err, &replyErr), errors.As(err, &authErr), errors.As(err, &numErr):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// The operation was aborted by the caller
+		return false
+	case strings.HasPrefix(err.Error(), "redigo: unexpected"):
+		// Conversion errors, like "redigo: unexpected type for String"
+		return false
+	}
+	return true
+}
+
+// Wrap an error from running the given command on the given key in a
+// CommandError, and connection errors in a ConnectionError as well
+func wrapError(command, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return err
+	}
+	if isConnectionError(err) {
+		err = &ConnectionError{err}
+	}
+	return &CommandError{command, key, err}
+}
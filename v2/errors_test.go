@@ -0,0 +1,94 @@
+package simpleredis
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	for _, err := range []error{io.EOF, redis.ErrPoolExhausted, errors.New("redigo: connection closed")} {
+		if !isConnectionError(err) {
+			t.Errorf("Error, %v should be a connection error", err)
+		}
+	}
+	for _, err := range []error{redis.ErrNil, redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value"), errors.New("redigo: unexpected type for String, got type int64"), &AuthError{"", redis.Error("WRONGPASS")}} {
+		if isConnectionError(err) {
+			t.Errorf("Error, %v should not be a connection error", err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	const (
+		kvname   = "abc123_test_errors_123abc"
+		listname = "abc123_test_errors_list_123abc"
+	)
+	errPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer errPool.Close()
+
+	// A missing key
+	kv := NewKeyValue(errPool, kvname)
+	kv.SelectDatabase(1)
+	_, err := kv.Get("missing")
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, redis.ErrNil) || errors.Is(err, ErrConnection) {
+		t.Errorf("Error, expected a not found error, got: %v", err)
+	}
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Command != "GET" || cmdErr.Key != kvname+":missing" {
+		t.Errorf("Error, expected a CommandError for GET, got: %v", err)
+	}
+
+	// An empty list and set
+	list := NewList(errPool, listname)
+	list.SelectDatabase(1)
+	if _, err := list.PopFirst(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected a not found error when popping from an empty list, got: %v", err)
+	}
+	set := NewSet(errPool, listname)
+	set.SelectDatabase(1)
+	if _, err := set.Random(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected a not found error for an empty set, got: %v", err)
+	}
+
+	// An error reply from the server
+	if err := list.Add("a"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = set.Has("a")
+	var replyErr redis.Error
+	if !errors.As(err, &replyErr) || errors.Is(err, ErrConnection) || errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected an error reply for the wrong type, got: %v", err)
+	}
+	if err := list.Remove(); err != nil {
+		t.Errorf("Error, could not remove list! %s", err)
+	}
+
+	// No server, the methods should return errors instead of panicking
+	downPool := NewConnectionPoolHost("localhost:1")
+	defer downPool.Close()
+	downList := NewList(downPool, listname)
+	downSet := NewSet(downPool, listname)
+	downHashMap := NewHashMap(downPool, listname)
+	for name, f := range map[string]func() error{
+		"List.Get":      func() error { _, err := downList.Get(0); return err },
+		"List.Size":     func() error { _, err := downList.Size(); return err },
+		"List.PopFirst": func() error { _, err := downList.PopFirst(); return err },
+		"List.PopLast":  func() error { _, err := downList.PopLast(); return err },
+		"Set.Size":      func() error { _, err := downSet.Size(); return err },
+		"Set.Has":       func() error { _, err := downSet.Has("a"); return err },
+		"Set.Pop":       func() error { _, err := downSet.Pop(); return err },
+		"Set.Random":    func() error { _, err := downSet.Random(); return err },
+		"HashMap.Has":   func() error { _, err := downHashMap.Has("a", "b"); return err },
+		"HashMap.All":   func() error { _, err := downHashMap.All(); return err },
+		"Ping":          downPool.Ping,
+	} {
+		err := f()
+		var connErr *ConnectionError
+		if !errors.Is(err, ErrConnection) || !errors.As(err, &connErr) || errors.Is(err, ErrNotFound) {
+			t.Errorf("Error, expected a connection error from %s, got: %v", name, err)
+		}
+	}
+}
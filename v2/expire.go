@@ -0,0 +1,394 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// How often expired hash map fields are removed, for servers without HPEXPIRE
+var reaperInterval = time.Second
+
+// If the server supports HPEXPIRE and HPTTL
+const (
+	fieldExpiryUnknown int32 = iota
+	fieldExpirySupported
+	fieldExpiryUnsupported
+	// HPTTL gave an error reply, like NOPERM, so both HPEXPIRE and the
+	// expiry indexes may be used, and the server is not asked again
+	fieldExpiryUndetermined
+)
+
+// A hash map with an expiry index, in the given database
+type expiryIndex struct {
+	dbindex int
+	id      string
+}
+
+// The state for per-field expiry of hash maps, for a connection pool
+type fieldExpiry struct {
+	// One of the fieldExpiry constants, accessed atomically
+	support int32
+	mut     sync.Mutex
+	// The expiry indexes that the reaper removes expired fields for
+	indexes map[expiryIndex]bool
+	// If the hash maps that have been checked have an expiry index, like one
+	// that was left by another process
+	exists map[expiryIndex]bool
+	// Closed for stopping the reaper, and closed by the reaper when it has stopped
+	stop, done chan struct{}
+}
+
+// Check if an error is a reply about an unknown command
+func isUnknownCommand(err error) bool {
+	var replyErr redis.Error
+	return errors.As(err, &replyErr) && strings.HasPrefix(strings.ToLower(string(replyErr)), "err unknown command")
+}
+
+// The key of the sorted set with the expiration times for the fields of a
+// hash map, for servers without HPEXPIRE. The scores are Unix times in
+// milliseconds. The id is followed by a NUL byte, which is reserved, so that
+// the key is not mistaken for an element or for another data structure.
+// For Redis Cluster, the id is a hashtag, so that the key is in the same
+// hash slot as the elements.
+func expiryIndexKey(id string) string {
+	return id + "\x00expire"
+}
+
+// A member of an expiry index, given an element id and a key.
+// The length of the element id is included, so that both may contain any character.
+func expiryMember(elementid, key string) string {
+	return strconv.Itoa(len(elementid)) + ":" + elementid + key
+}
+
+// Split a member of an expiry index into the element id and the key
+func parseExpiryMember(member string) (string, string, bool) {
+	pos := strings.Index(member, ":")
+	if pos == -1 {
+		return "", "", false
+	}
+	n, err := strconv.Atoi(member[:pos])
+	if err != nil || n < 0 || pos+1+n > len(member) {
+		return "", "", false
+	}
+	rest := member[pos+1:]
+	return rest[:n], rest[n:], true
+}
+
+func (pool *ConnectionPool) fieldExpirySupport() int32 {
+	return atomic.LoadInt32(&pool.state().expiry.support)
+}
+
+func (pool *ConnectionPool) setFieldExpirySupport(support int32) {
+	atomic.StoreInt32(&pool.mutableState().expiry.support, support)
+}
+
+// Check if the expiration times of hash map fields are kept in expiry
+// indexes, since the server does not support HPEXPIRE. The first time, the
+// server is asked with HPTTL for the given key.
+func (pool *ConnectionPool) usesExpiryIndex(conn redis.Conn, hashKey string) bool {
+	switch pool.fieldExpirySupport() {
+	case fieldExpirySupported:
+		return false
+	case fieldExpiryUnsupported, fieldExpiryUndetermined:
+		return true
+	}
+	_, err := conn.Do("HPTTL", hashKey, "FIELDS", 1, "")
+	var replyErr redis.Error
+	switch {
+	case err == nil:
+		pool.setFieldExpirySupport(fieldExpirySupported)
+		return false
+	case isUnknownCommand(err):
+		pool.setFieldExpirySupport(fieldExpiryUnsupported)
+	case errors.As(err, &replyErr):
+		pool.setFieldExpirySupport(fieldExpiryUndetermined)
+	}
+	// Also check the expiry index if the support is not known
+	return true
+}
+
+// Check if the expiration times of the fields are kept in an expiry index.
+// The first time the hash map is used, the server is asked if the expiry
+// index exists, and the answer is kept until the pool is closed. If it
+// exists, like after a restart, the reaper is started, so that the expired
+// fields are removed. SetExpire also marks the expiry index as existing.
+func (rh *HashMap) hasExpiryIndex(conn redis.Conn, elementid string) bool {
+	if !rh.pool.usesExpiryIndex(conn, rh.id+":"+elementid) {
+		return false
+	}
+	e := &rh.pool.mutableState().expiry
+	index := expiryIndex{rh.dbindex, rh.id}
+	e.mut.Lock()
+	exists, checked := e.exists[index]
+	e.mut.Unlock()
+	if checked {
+		return exists
+	}
+	exists, err := redis.Bool(conn.Do("EXISTS", expiryIndexKey(rh.id)))
+	if err != nil {
+		// Check again the next time
+		return true
+	}
+	if exists {
+		rh.pool.startReaper(rh.dbindex, rh.id)
+		return true
+	}
+	e.mut.Lock()
+	if _, ok := e.exists[index]; !ok {
+		if e.exists == nil {
+			e.exists = make(map[expiryIndex]bool)
+		}
+		e.exists[index] = false
+	}
+	e.mut.Unlock()
+	return false
+}
+
+// Check which of the given keys of an element id have expired, but have not
+// been removed by the reaper yet, for servers without HPEXPIRE
+func (rh *HashMap) expired(conn redis.Conn, elementid string, keys ...string) ([]bool, error) {
+	expired := make([]bool, len(keys))
+	if len(keys) == 0 || !rh.hasExpiryIndex(conn, elementid) {
+		return expired, nil
+	}
+	index := expiryIndexKey(rh.id)
+	for _, key := range keys {
+		if err := conn.Send("ZSCORE", index, expiryMember(elementid, key)); err != nil {
+			return nil, wrapError("ZSCORE", index, err)
+		}
+	}
+	replies, err := redis.Values(conn.Do(""))
+	if err == nil {
+		err = pipelineError(replies)
+	}
+	if err != nil {
+		return nil, wrapError("ZSCORE", index, err)
+	}
+	now := time.Now().UnixMilli()
+	for i, reply := range replies {
+		if expireAt, err := redis.Float64(reply, nil); err == nil && int64(expireAt) <= now {
+			expired[i] = true
+		}
+	}
+	return expired, nil
+}
+
+// Given an element id, set a key and a value together with an expiration time.
+// HPEXPIRE is used if the server supports it (Redis 7.4 and later). If not,
+// the expiration time is stored in a sorted set next to the hash map, and a
+// background goroutine removes the expired fields until the pool is closed.
+// Fields that have expired are not returned when reading, even if they have
+// not been removed yet. The sorted set is kept on the server, so after a
+// restart, the removal is resumed when the hash map is used again.
+func (rh *HashMap) SetExpire(elementid, key, value string, expire time.Duration) error {
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	hashKey := rh.id + ":" + elementid
+	if _, err := conn.Do("HSET", hashKey, key, value); err != nil {
+		return wrapError("HSET", hashKey, err)
+	}
+	if rh.pool.fieldExpirySupport() != fieldExpiryUnsupported {
+		_, err := conn.Do("HPEXPIRE", hashKey, expire.Milliseconds(), "FIELDS", 1, key)
+		if !isUnknownCommand(err) {
+			if err == nil {
+				rh.pool.setFieldExpirySupport(fieldExpirySupported)
+			}
+			return wrapError("HPEXPIRE", hashKey, err)
+		}
+		// Redis versions before 7.4
+		rh.pool.setFieldExpirySupport(fieldExpiryUnsupported)
+	}
+	index := expiryIndexKey(rh.id)
+	expireAt := time.Now().Add(expire).UnixMilli()
+	if _, err := conn.Do("ZADD", index, expireAt, expiryMember(elementid, key)); err != nil {
+		return wrapError("ZADD", index, err)
+	}
+	rh.pool.startReaper(rh.dbindex, rh.id)
+	return nil
+}
+
+// TimeToLive returns how long the given key of the given element id has to
+// live until it expires. Returns a duration of 0 when the time has passed,
+// or if the key has no expiration time.
+func (rh *HashMap) TimeToLive(elementid, key string) (time.Duration, error) {
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	hashKey := rh.id + ":" + elementid
+	if rh.pool.fieldExpirySupport() != fieldExpiryUnsupported {
+		ttls, err := redis.Int64s(conn.Do("HPTTL", hashKey, "FIELDS", 1, key))
+		if !isUnknownCommand(err) {
+			if err != nil {
+				return time.Duration(0), wrapError("HPTTL", hashKey, err)
+			}
+			rh.pool.setFieldExpirySupport(fieldExpirySupported)
+			// -1 means no expiration time, and -2 means no such field
+			if len(ttls) == 0 || ttls[0] <= 0 {
+				return time.Duration(0), nil
+			}
+			return time.Duration(ttls[0]) * time.Millisecond, nil
+		}
+		rh.pool.setFieldExpirySupport(fieldExpiryUnsupported)
+	}
+	index := expiryIndexKey(rh.id)
+	expireAt, err := redis.Float64(conn.Do("ZSCORE", index, expiryMember(elementid, key)))
+	if err == redis.ErrNil {
+		return time.Duration(0), nil
+	} else if err != nil {
+		return time.Duration(0), wrapError("ZSCORE", index, err)
+	}
+	ttl := time.Until(time.UnixMilli(int64(expireAt)))
+	if ttl <= 0 {
+		return time.Duration(0), nil
+	}
+	return ttl, nil
+}
+
+// Remove the expiration times for the given keys of an element id, for
+// servers without HPEXPIRE, where setting or deleting a field does not do this
+func (rh *HashMap) clearExpiry(conn redis.Conn, elementid string, keys ...string) error {
+	if len(keys) == 0 || !rh.hasExpiryIndex(conn, elementid) {
+		return nil
+	}
+	index := expiryIndexKey(rh.id)
+	_, err := conn.Do("ZREM", expiryArgs(index, elementid, keys)...)
+	return wrapError("ZREM", index, err)
+}
+
+// Arguments for ZREM, for removing the expiration times of the given keys of an element id
+func expiryArgs(index, elementid string, keys []string) []interface{} {
+	args := make([]interface{}, 0, 1+len(keys))
+	args = append(args, index)
+	for _, key := range keys {
+		args = append(args, expiryMember(elementid, key))
+	}
+	return args
+}
+
+// The keys of a map
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// RemoveExpired removes the fields that have expired, for servers without
+// HPEXPIRE, and returns the number of removed fields. This is normally done
+// by a background goroutine, but can be used for removing fields that
+// expired while no process was running.
+func (rh *HashMap) RemoveExpired() (int, error) {
+	return rh.pool.removeExpired(rh.ctx, rh.dbindex, rh.id)
+}
+
+// Remove the expired fields of the given hash map
+func (pool *ConnectionPool) removeExpired(ctx context.Context, dbindex int, id string) (int, error) {
+	conn := pool.get(ctx, dbindex)
+	defer conn.Close()
+	return pool.removeExpiredFields(conn, id)
+}
+
+// Remove the expired fields of the given hash map, in batches, with the given connection
+func (pool *ConnectionPool) removeExpiredFields(conn redis.Conn, id string) (int, error) {
+	index := expiryIndexKey(id)
+	count := pool.scanCountHint()
+	removed := 0
+	for {
+		// Watch the index, so that fields that are given a new expiration
+		// time while removing are not removed
+		if _, err := conn.Do("WATCH", index); err != nil {
+			return removed, wrapError("WATCH", index, err)
+		}
+		now := time.Now().UnixMilli()
+		members, err := redis.Strings(conn.Do("ZRANGEBYSCORE", index, "-inf", now, "LIMIT", 0, count))
+		if err != nil || len(members) == 0 {
+			conn.Do("UNWATCH")
+			return removed, wrapError("ZRANGEBYSCORE", index, err)
+		}
+		conn.Send("MULTI")
+		for _, member := range members {
+			if elementid, key, ok := parseExpiryMember(member); ok {
+				conn.Send("HDEL", id+":"+elementid, key)
+			}
+			conn.Send("ZREM", index, member)
+		}
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return removed, wrapError("EXEC", index, err)
+		}
+		if reply == nil {
+			// The index was modified, try again at the next sweep
+			return removed, nil
+		}
+		removed += len(members)
+		if len(members) < count {
+			return removed, nil
+		}
+	}
+}
+
+// Start removing expired fields of the given hash map in the background,
+// if the reaper is not already doing so
+func (pool *ConnectionPool) startReaper(dbindex int, id string) {
+	e := &pool.mutableState().expiry
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.indexes == nil {
+		e.indexes = make(map[expiryIndex]bool)
+	}
+	if e.exists == nil {
+		e.exists = make(map[expiryIndex]bool)
+	}
+	e.indexes[expiryIndex{dbindex, id}] = true
+	e.exists[expiryIndex{dbindex, id}] = true
+	if e.stop == nil {
+		e.stop = make(chan struct{})
+		e.done = make(chan struct{})
+		go pool.reap(e.stop, e.done)
+	}
+}
+
+// Remove expired fields at regular intervals, until stopped
+func (pool *ConnectionPool) reap(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(reaperInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		e := &pool.state().expiry
+		e.mut.Lock()
+		indexes := make([]expiryIndex, 0, len(e.indexes))
+		for index := range e.indexes {
+			indexes = append(indexes, index)
+		}
+		e.mut.Unlock()
+		for _, index := range indexes {
+			// Errors are ignored, the fields are removed at the next sweep instead
+			pool.removeExpired(nil, index.dbindex, index.id)
+		}
+	}
+}
+
+// Stop the reaper, if it is running, and wait for it to stop
+func (pool *ConnectionPool) stopReaper() {
+	e := &pool.state().expiry
+	e.mut.Lock()
+	stop, done := e.stop, e.done
+	e.stop, e.done = nil, nil
+	e.mut.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,306 @@
+package simpleredis
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+// Start a server without HPEXPIRE and HPTTL, like Redis before 7.4
+func serverWithoutFieldExpiry() *redistest.Server {
+	s := redistest.NewServer()
+	for _, command := range []string{"HPEXPIRE", "HPTTL"} {
+		err := errors.New("ERR unknown command '" + command + "'")
+		s.Handle(command, func(args []string) interface{} {
+			return err
+		})
+	}
+	return s
+}
+
+func TestExpiryMember(t *testing.T) {
+	for _, pair := range [][2]string{{"bob", "token"}, {"b:ob", "to:ken"}, {"", ""}, {"12:x", "3"}} {
+		elementid, key, ok := parseExpiryMember(expiryMember(pair[0], pair[1]))
+		if !ok || elementid != pair[0] || key != pair[1] {
+			t.Errorf("Error, wrong element id and key: %q %q", elementid, key)
+		}
+	}
+	if _, _, ok := parseExpiryMember("5:abc"); ok {
+		t.Errorf("Error, a too short member should not be parsed!")
+	}
+}
+
+func TestExpiryIndexKey(t *testing.T) {
+	// A data structure may be named like the hash map id and a suffix
+	if index := expiryIndexKey("users"); index == "users.expire" || index == "users:expire" {
+		t.Errorf("Error, the expiry index can collide with another data structure: %q", index)
+	}
+	// In a cluster, the expiry index is in the same hash slot as the elements
+	if keySlot(expiryIndexKey("{users}")) != keySlot("{users}:bob") {
+		t.Error("Error, the expiry index should use the hashtag of the hash map")
+	}
+}
+
+func TestHashMapTimeToLive(t *testing.T) {
+	ttlPool := NewConnectionPoolHost(testHost)
+	defer ttlPool.Close()
+	checkLeaks(ttlPool)
+
+	hm := NewHashMap(ttlPool, "hk_abc123_test_ttl_123abc")
+	defer hm.Remove()
+
+	if err := hm.SetExpire("bob", "token", "123abc", time.Minute); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+	ttl, err := hm.TimeToLive("bob", "token")
+	if err != nil {
+		t.Errorf("Error, could not get the time to live! %s", err)
+	} else if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Error, wrong time to live: %v", ttl)
+	}
+	// Setting the key again without an expiration time removes the expiration time
+	if err := hm.Set("bob", "token", "123abc"); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+	if ttl, err := hm.TimeToLive("bob", "token"); err != nil || ttl != 0 {
+		t.Errorf("Error, the key should not expire: %v %v", ttl, err)
+	}
+	if ttl, err := hm.TimeToLive("bob", "nosuchkey"); err != nil || ttl != 0 {
+		t.Errorf("Error, a missing key should not expire: %v %v", ttl, err)
+	}
+}
+
+func TestHashMapExpiryIndex(t *testing.T) {
+	defer func(interval time.Duration) {
+		reaperInterval = interval
+	}(reaperInterval)
+	reaperInterval = 50 * time.Millisecond
+
+	indexPool := NewConnectionPoolHost(testHost)
+	defer indexPool.Close()
+	checkLeaks(indexPool)
+	// Use the expiry index, as if the server did not support HPEXPIRE
+	indexPool.setFieldExpirySupport(fieldExpiryUnsupported)
+
+	hm := NewHashMap(indexPool, "hk_abc123_test_index_123abc")
+	defer hm.Remove()
+
+	if err := hm.SetExpire("bob", "token", "123abc", 100*time.Millisecond); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+	if err := hm.SetExpire("b:ob", "to:ken", "123abc", 100*time.Millisecond); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+	if err := hm.SetExpire("alice", "token", "123abc", time.Minute); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+	ttl, err := hm.TimeToLive("alice", "token")
+	if err != nil {
+		t.Errorf("Error, could not get the time to live! %s", err)
+	} else if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Error, wrong time to live: %v", ttl)
+	}
+	// The expiry index is not an element of the hash map
+	if all, err := hm.All(); err != nil || len(all) != 3 {
+		t.Errorf("Error, wrong elements: %v %v", all, err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	if _, err := hm.Get("bob", "token"); err == nil {
+		t.Errorf("Error, key should be gone!")
+	}
+	if _, err := hm.Get("b:ob", "to:ken"); err == nil {
+		t.Errorf("Error, key should be gone!")
+	}
+	if value, err := hm.Get("alice", "token"); err != nil || value != "123abc" {
+		t.Errorf("Error, key should still be there! %v", err)
+	}
+
+	// Fields that expired while no reaper was running can be removed
+	indexPool.stopReaper()
+	conn := indexPool.Get(hm.dbindex)
+	conn.Do("HSET", hm.id+":bob", "token", "123abc")
+	conn.Do("ZADD", expiryIndexKey(hm.id), time.Now().Add(-time.Second).UnixMilli(), expiryMember("bob", "token"))
+	conn.Close()
+	if removed, err := hm.RemoveExpired(); err != nil || removed != 1 {
+		t.Errorf("Error, wrong number of removed fields: %d %v", removed, err)
+	}
+	if _, err := hm.Get("bob", "token"); err == nil {
+		t.Errorf("Error, key should be gone!")
+	}
+
+	// Removing the hash map also removes the expiry index
+	if err := hm.Remove(); err != nil {
+		t.Fatalf("Error, could not remove the hash map! %s", err)
+	}
+	found, err := hasKey(nil, indexPool, expiryIndexKey(hm.id), hm.dbindex)
+	if err != nil || found {
+		t.Errorf("Error, the expiry index should be gone! %v", err)
+	}
+}
+
+func TestHashMapExpiryRestart(t *testing.T) {
+	defer func(interval time.Duration) {
+		reaperInterval = interval
+	}(reaperInterval)
+	reaperInterval = 20 * time.Millisecond
+
+	s := serverWithoutFieldExpiry()
+	defer s.Close()
+	before := checkLeaks(NewConnectionPoolHost(s.Addr))
+	hm := NewHashMap(before, "abc123_test_restart")
+	for _, elementid := range []string{"bob", "carol"} {
+		if err := hm.SetExpire(elementid, "token", "123abc", 50*time.Millisecond); err != nil {
+			t.Fatalf("Error, could not set key and value! %s", err)
+		}
+	}
+	if err := hm.SetExpire("alice", "token", "123abc", time.Minute); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+	// The process stops before the fields expire
+	before.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	after := checkLeaks(NewConnectionPoolHost(s.Addr))
+	defer after.Close()
+	hm = NewHashMap(after, "abc123_test_restart")
+	// Expired fields can not be read, even before they are removed
+	if _, err := hm.Get("bob", "token"); err == nil {
+		t.Error("Error, the key should have expired")
+	}
+	if found, err := hm.Has("bob", "token"); err != nil || found {
+		t.Errorf("Error, the key should have expired: %v %v", found, err)
+	}
+	if keys, err := hm.Keys("bob"); err != nil || len(keys) != 0 {
+		t.Errorf("Error, the key should have expired: %v %v", keys, err)
+	}
+	if m, err := hm.GetMap("bob", []string{"token"}); err != nil || len(m) != 0 {
+		t.Errorf("Error, the key should have expired: %v %v", m, err)
+	}
+	if value, err := hm.Get("alice", "token"); err != nil || value != "123abc" {
+		t.Errorf("Error, the key should still be there! %v", err)
+	}
+	// The removal of expired fields is resumed
+	time.Sleep(100 * time.Millisecond)
+	if s.Exists(0, hm.RedisKey("carol")) {
+		t.Error("Error, the expired field should have been removed")
+	}
+	if all, err := hm.All(); err != nil || len(all) != 1 || all[0] != "alice" {
+		t.Errorf("Error, wrong elements: %v %v", all, err)
+	}
+}
+
+func TestHashMapExpiryCleared(t *testing.T) {
+	s := serverWithoutFieldExpiry()
+	defer s.Close()
+	indexPool := checkLeaks(NewConnectionPoolHost(s.Addr))
+	defer indexPool.Close()
+	hm := NewHashMap(indexPool, "abc123_test_cleared")
+	for _, elementid := range []string{"bob", "carol", "dave"} {
+		if err := hm.SetExpire(elementid, "token", "123abc", 50*time.Millisecond); err != nil {
+			t.Fatalf("Error, could not set key and value! %s", err)
+		}
+	}
+	// Overwriting a field without an expiration time, or deleting the
+	// element and creating it again, removes the expiration time
+	if err := hm.SetMap("bob", map[string]string{"token": "1"}); err != nil {
+		t.Fatalf("Error, could not set keys and values! %s", err)
+	}
+	if err := hm.SetLargeMap(map[string]map[string]string{"carol": {"token": "2"}}); err != nil {
+		t.Fatalf("Error, could not set keys and values! %s", err)
+	}
+	if err := hm.Del("dave"); err != nil {
+		t.Fatalf("Error, could not delete element! %s", err)
+	}
+	if err := hm.Set("dave", "token", "3"); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if removed, err := hm.RemoveExpired(); err != nil || removed != 0 {
+		t.Errorf("Error, no fields should be removed: %d %v", removed, err)
+	}
+	for i, elementid := range []string{"bob", "carol", "dave"} {
+		if ttl, err := hm.TimeToLive(elementid, "token"); err != nil || ttl != 0 {
+			t.Errorf("Error, the key should not expire: %v %v", ttl, err)
+		}
+		if value, err := hm.Get(elementid, "token"); err != nil || value != strconv.Itoa(i+1) {
+			t.Errorf("Error, wrong value for %s: %s %v", elementid, value, err)
+		}
+	}
+}
+
+func TestHashMapExpiryIndexCached(t *testing.T) {
+	// Count the commands for the expiry index, and the HPTTL probes
+	var mut sync.Mutex
+	counts := make(map[string]int)
+	count := func(s *redistest.Server, command string, reply interface{}) {
+		s.Handle(command, func(args []string) interface{} {
+			mut.Lock()
+			defer mut.Unlock()
+			counts[command]++
+			return reply
+		})
+	}
+	s := serverWithoutFieldExpiry()
+	defer s.Close()
+	for command, reply := range map[string]interface{}{"EXISTS": int64(0), "ZSCORE": nil, "ZREM": int64(0), "WATCH": "OK"} {
+		count(s, command, reply)
+	}
+	indexPool := checkLeaks(NewConnectionPoolHost(s.Addr))
+	defer indexPool.Close()
+
+	// Without an expiry index, the hash map is used as usual
+	hm := NewHashMap(indexPool, "abc123_test_cached")
+	for i := 0; i < 10; i++ {
+		if err := hm.Set("bob", "token", "123abc"); err != nil {
+			t.Fatalf("Error, could not set key and value! %s", err)
+		}
+		if _, err := hm.Get("bob", "token"); err != nil {
+			t.Errorf("Error, could not get value! %s", err)
+		}
+		if _, err := hm.All(); err != nil {
+			t.Errorf("Error, could not get all elements! %s", err)
+		}
+	}
+	mut.Lock()
+	if counts["EXISTS"] != 1 || counts["ZSCORE"] != 0 || counts["ZREM"] != 0 || counts["WATCH"] != 0 {
+		t.Errorf("Error, the expiry index should only be looked for once: %v", counts)
+	}
+	mut.Unlock()
+
+	// After SetExpire, the expiry index is used
+	if err := hm.SetExpire("bob", "token", "123abc", time.Minute); err != nil {
+		t.Fatalf("Error, could not set key and value! %s", err)
+	}
+	if _, err := hm.Get("bob", "token"); err != nil {
+		t.Errorf("Error, could not get value! %s", err)
+	}
+	mut.Lock()
+	if counts["ZSCORE"] != 1 {
+		t.Errorf("Error, the expiry index should be used: %v", counts)
+	}
+	mut.Unlock()
+
+	// A server that does not allow HPTTL is only asked once
+	denied := redistest.NewServer()
+	defer denied.Close()
+	count(denied, "HPTTL", errors.New("NOPERM this user has no permissions to run the 'hpttl' command"))
+	deniedPool := checkLeaks(NewConnectionPoolHost(denied.Addr))
+	defer deniedPool.Close()
+	hm = NewHashMap(deniedPool, "abc123_test_cached")
+	for i := 0; i < 10; i++ {
+		if err := hm.Set("bob", "token", "123abc"); err != nil {
+			t.Errorf("Error, could not set key and value! %s", err)
+		}
+	}
+	mut.Lock()
+	if counts["HPTTL"] != 1 {
+		t.Errorf("Error, HPTTL should only be sent once: %d", counts["HPTTL"])
+	}
+	mut.Unlock()
+}
@@ -0,0 +1,222 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// HostOrder decides the order that the hosts of a failover list are tried in
+type HostOrder int
+
+const (
+	// HostsInOrder tries the hosts in the given order, so that the first
+	// healthy host is used
+	HostsInOrder HostOrder = iota
+
+	// HostsRandom tries the hosts in a random order, so that the connections
+	// are spread over all healthy hosts
+	HostsRandom
+)
+
+var (
+	// How long an unhealthy host is left alone before it is probed. The delay
+	// is doubled for each failure in a row, up to hostMaxBackoff.
+	hostBackoff    = time.Second
+	hostMaxBackoff = 30 * time.Second
+
+	// How often the unhealthy hosts are checked for being due for a probe
+	hostProbeInterval = 500 * time.Millisecond
+)
+
+// A list of hosts to connect to, shared by all connections of a pool
+type hostList struct {
+	mut   sync.Mutex
+	hosts []*failoverHost
+	order HostOrder
+	rand  *rand.Rand
+	// Closed for stopping the prober, and closed by the prober when it has stopped
+	stop, done chan struct{}
+}
+
+// A host in a failover list
+type failoverHost struct {
+	// Settings for connecting to the host
+	settings *connectionSettings
+	// The number of failures in a row, or 0 if the host is healthy
+	failures int
+	// When the host should be probed again, if it is unhealthy
+	nextProbe time.Time
+}
+
+// Find the hosts to try, in order. The healthy hosts are tried first, and
+// the unhealthy hosts only if no host is healthy.
+func (hl *hostList) candidates() []*failoverHost {
+	hl.mut.Lock()
+	defer hl.mut.Unlock()
+	var healthy, unhealthy []*failoverHost
+	for _, h := range hl.hosts {
+		if h.failures == 0 {
+			healthy = append(healthy, h)
+		} else {
+			unhealthy = append(unhealthy, h)
+		}
+	}
+	if len(healthy) == 0 {
+		return unhealthy
+	}
+	if hl.order == HostsRandom {
+		hl.rand.Shuffle(len(healthy), func(i, j int) {
+			healthy[i], healthy[j] = healthy[j], healthy[i]
+		})
+	}
+	return healthy
+}
+
+// Connect to the first host that responds, given a context
+func (hl *hostList) dialContext(ctx context.Context) (redis.Conn, error) {
+	var lastErr error
+	for _, h := range hl.candidates() {
+		conn, err := h.settings.dialContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The host is not to blame
+				return nil, err
+			}
+			hl.failed(h)
+			lastErr = err
+			continue
+		}
+		hl.succeeded(h)
+		return conn, nil
+	}
+	return nil, lastErr
+}
+
+// Mark a host as unhealthy, and probe it again after a while
+func (hl *hostList) failed(h *failoverHost) {
+	hl.mut.Lock()
+	defer hl.mut.Unlock()
+	h.failures++
+	delay := hostMaxBackoff
+	if h.failures < 32 {
+		if d := hostBackoff << uint(h.failures-1); d > 0 && d < hostMaxBackoff {
+			delay = d
+		}
+	}
+	h.nextProbe = time.Now().Add(delay)
+	if hl.stop == nil {
+		hl.stop = make(chan struct{})
+		hl.done = make(chan struct{})
+		go hl.probe(hl.stop, hl.done)
+	}
+}
+
+// Mark a host as healthy
+func (hl *hostList) succeeded(h *failoverHost) {
+	hl.mut.Lock()
+	h.failures = 0
+	hl.mut.Unlock()
+}
+
+// Find the unhealthy hosts that should be probed now
+func (hl *hostList) due() []*failoverHost {
+	hl.mut.Lock()
+	defer hl.mut.Unlock()
+	now := time.Now()
+	var hosts []*failoverHost
+	for _, h := range hl.hosts {
+		if h.failures > 0 && !now.Before(h.nextProbe) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// Probe the unhealthy hosts with PING at regular intervals, until stopped
+func (hl *hostList) probe(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(hostProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for _, h := range hl.due() {
+			conn, err := h.settings.dial()
+			if err == nil {
+				_, err = conn.Do("PING")
+				conn.Close()
+			}
+			if err != nil {
+				hl.failed(h)
+			} else {
+				hl.succeeded(h)
+			}
+		}
+	}
+}
+
+// Stop the prober, if it is running, and wait for it to stop
+func (hl *hostList) stopProbing() {
+	hl.mut.Lock()
+	stop, done := hl.stop, hl.done
+	hl.stop, hl.done = nil, nil
+	hl.mut.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Create a new connection pool given several host:port strings, in the same
+// format as for NewConnectionPoolHost. New connections are made to the first
+// host that responds, trying the hosts in the given order or in a random
+// order. Hosts that can not be connected to are marked as unhealthy and
+// skipped, and are probed in the background, with an increasing delay,
+// until they respond again. If no host is healthy, all hosts are tried.
+// Connections that are already open are not moved to another host.
+// Close must be called when the pool is no longer needed.
+func NewConnectionPoolHosts(hostColonPorts []string, order HostOrder) (*ConnectionPool, error) {
+	return NewConnectionPoolHostsOptions(hostColonPorts, order, nil)
+}
+
+// Create a new connection pool given several host:port strings, like
+// NewConnectionPoolHosts, and options for connecting to the hosts, like for
+// NewConnectionPoolOptions. A password given as "password@host:port" is
+// used instead of the password in the options. The options may be nil, for
+// using the defaults. Replicas are not supported.
+// Close must be called when the pool is no longer needed.
+func NewConnectionPoolHostsOptions(hostColonPorts []string, order HostOrder, options *PoolOptions) (*ConnectionPool, error) {
+	if len(hostColonPorts) == 0 {
+		return nil, errors.New("at least one host is needed")
+	}
+	if order != HostsInOrder && order != HostsRandom {
+		return nil, errors.New("unknown host order")
+	}
+	cu, err := options.checkedSettings(false)
+	if err != nil {
+		return nil, err
+	}
+	hl := &hostList{
+		order: order,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, hostColonPort := range hostColonPorts {
+		settings := *cu
+		if password, host, ok := passwordAndHost(hostColonPort); ok {
+			settings.password, hostColonPort = password, host
+		}
+		settings.network, settings.address = networkAndAddress(hostColonPort)
+		hl.hosts = append(hl.hosts, &failoverHost{settings: &settings})
+	}
+	pool := newPool(cu, hl.dialContext)
+	pool.mutableState().hosts = hl
+	return pool, nil
+}
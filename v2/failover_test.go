@@ -0,0 +1,160 @@
+package simpleredis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+// Check if the host at the given index of the failover list is healthy
+func hostHealthy(pool *ConnectionPool, i int) bool {
+	hl := pool.state().hosts
+	hl.mut.Lock()
+	defer hl.mut.Unlock()
+	return hl.hosts[i].failures == 0
+}
+
+func TestFailoverHosts(t *testing.T) {
+	defer func(backoff, interval time.Duration) {
+		hostBackoff, hostProbeInterval = backoff, interval
+	}(hostBackoff, hostProbeInterval)
+	hostBackoff, hostProbeInterval = 10*time.Millisecond, 10*time.Millisecond
+
+	// The first host is down
+	first := redistest.NewServer()
+	addr := first.Addr
+	first.Close()
+	second := redistest.NewServer()
+	defer second.Close()
+
+	pool, err := NewConnectionPoolHosts([]string{addr, second.Addr}, HostsInOrder)
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+	kv := NewKeyValue(pool, "abc123_test_failover")
+	if err := kv.Set("a", "1"); err != nil {
+		t.Fatalf("Error, could not set value! %s", err)
+	}
+	if !second.Exists(0, kv.RedisKey("a")) {
+		t.Error("Error, the value should be on the second host")
+	}
+	if hostHealthy(pool, 0) {
+		t.Error("Error, the first host should be unhealthy")
+	}
+
+	// The first host is found when it is up again
+	first, err = redistest.NewServerAddr(addr)
+	if err != nil {
+		t.Fatalf("Error, could not restart the server! %s", err)
+	}
+	defer first.Close()
+	for i := 0; i < 100 && !hostHealthy(pool, 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !hostHealthy(pool, 0) {
+		t.Fatal("Error, the first host should be healthy again")
+	}
+	// The idle connection is to the second host, so a new one is made while it is in use
+	conn := pool.Get(0)
+	defer conn.Close()
+	if err := kv.Set("b", "2"); err != nil {
+		t.Fatalf("Error, could not set value! %s", err)
+	}
+	if !first.Exists(0, kv.RedisKey("b")) {
+		t.Error("Error, the value should be on the first host")
+	}
+
+	if _, err := NewConnectionPoolHosts(nil, HostsInOrder); err == nil {
+		t.Error("Error, an empty list of hosts should fail")
+	}
+}
+
+func TestFailoverHostsOptions(t *testing.T) {
+	// The first host does not answer in time
+	slow := redistest.NewServer()
+	defer slow.Close()
+	slow.Handle("AUTH", func(args []string) interface{} {
+		time.Sleep(500 * time.Millisecond)
+		return "OK"
+	})
+	second := redistest.NewServer()
+	defer second.Close()
+	second.Handle("AUTH", func(args []string) interface{} {
+		return "OK"
+	})
+
+	pool, err := NewConnectionPoolHostsOptions([]string{slow.Addr, second.Addr}, HostsInOrder, &PoolOptions{
+		Password:      "secret",
+		ReadTimeout:   50 * time.Millisecond,
+		DatabaseIndex: 2,
+	})
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+	kv := NewKeyValue(pool, "abc123_test_failover_options")
+	if err := kv.Set("a", "1"); err != nil {
+		t.Fatalf("Error, could not set value! %s", err)
+	}
+	if !second.Exists(2, kv.RedisKey("a")) {
+		t.Error("Error, the value should be in database 2 on the second host")
+	}
+	if hostHealthy(pool, 0) || !hostHealthy(pool, 1) {
+		t.Error("Error, only the first host should be unhealthy")
+	}
+
+	// A host is not marked as unhealthy when the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if conn, err := pool.GetContext(ctx, 0); err == nil {
+		conn.Close()
+		t.Error("Error, the context is done")
+	}
+	if !hostHealthy(pool, 1) {
+		t.Error("Error, the second host should still be healthy")
+	}
+
+	if _, err := NewConnectionPoolHostsOptions([]string{second.Addr}, HostsInOrder, &PoolOptions{Replicas: []string{slow.Addr}}); err == nil {
+		t.Error("Error, replicas should not be supported")
+	}
+}
+
+func TestFailoverHostsRandom(t *testing.T) {
+	first := redistest.NewServer()
+	defer first.Close()
+	second := redistest.NewServer()
+	defer second.Close()
+	pool, err := NewConnectionPoolHosts([]string{first.Addr, second.Addr}, HostsRandom)
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+
+	var conns []redis.Conn
+	for i := 0; i < 20; i++ {
+		conn := pool.Get(0)
+		if _, err := conn.Do("PING"); err != nil {
+			t.Errorf("Error, could not ping! %s", err)
+		}
+		conns = append(conns, conn)
+	}
+	if first.ClientCount() == 0 || second.ClientCount() == 0 {
+		t.Errorf("Error, the connections should be spread over the hosts: %d %d", first.ClientCount(), second.ClientCount())
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	// When all hosts are down, connecting fails
+	first.Close()
+	second.Close()
+	conn := pool.Get(0)
+	if _, err := conn.Do("PING"); err == nil {
+		t.Error("Error, all hosts are down")
+	}
+	conn.Close()
+}
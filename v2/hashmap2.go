@@ -0,0 +1,157 @@
+package simpleredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/pinterface"
+)
+
+// HashMap implements the IHashMap2 interface
+var _ pinterface.IHashMap2 = &HashMap{}
+
+// Arguments for HSET, given the key of the element and the keys and values
+func hashArgs(key string, m map[string]string) []interface{} {
+	args := make([]interface{}, 0, 1+len(m)*2)
+	args = append(args, key)
+	for k, v := range m {
+		args = append(args, k, v)
+	}
+	return args
+}
+
+// Find the first error reply from a pipeline, where the replies were received with Do("")
+func pipelineError(replies interface{}) error {
+	values, _ := replies.([]interface{})
+	for _, value := range values {
+		if err, ok := value.(redis.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get all elementid's where the given key has the given value
+func (rh *HashMap) AllWhere(key, value string) ([]string, error) {
+	elementids, err := rh.All()
+	if err != nil {
+		return nil, err
+	}
+	if len(elementids) == 0 {
+		return []string{}, nil
+	}
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	// Send all the HGET commands before receiving the replies
+	for _, elementid := range elementids {
+		if err := conn.Send("HGET", rh.id+":"+elementid, key); err != nil {
+			return nil, wrapError("HGET", rh.id+":"+elementid, err)
+		}
+	}
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return nil, wrapError("HGET", rh.id, err)
+	}
+	found := []string{}
+	for i, reply := range replies {
+		if s, err := redis.String(reply, nil); err == nil && s == value {
+			found = append(found, elementids[i])
+		}
+	}
+	return found, nil
+}
+
+// Count the number of elements in this hash map
+func (rh *HashMap) Count() (int64, error) {
+	elementids, err := rh.All()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(elementids)), nil
+}
+
+// Check if this hash map is empty
+func (rh *HashMap) Empty() (bool, error) {
+	found, err := hasKey(rh.ctx, rh.pool, escapePattern(rh.id)+":*", rh.dbindex)
+	return !found, err
+}
+
+// Get multiple values for the given element id and keys.
+// Keys that are not found are not included in the returned map.
+func (rh *HashMap) GetMap(elementid string, keys []string) (map[string]string, error) {
+	m := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return m, nil
+	}
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	args := make([]interface{}, 0, 1+len(keys))
+	args = append(args, rh.id+":"+elementid)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	values, err := redis.Values(conn.Do("HMGET", args...))
+	if err != nil {
+		return nil, wrapError("HMGET", rh.id+":"+elementid, err)
+	}
+	expired, err := rh.expired(conn, elementid, keys...)
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if value == nil || expired[i] {
+			continue
+		}
+		s, err := redis.String(value, nil)
+		if err != nil {
+			return nil, wrapError("HMGET", rh.id+":"+elementid, err)
+		}
+		m[keys[i]] = s
+	}
+	return m, nil
+}
+
+// Set multiple keys and values for the given element id
+func (rh *HashMap) SetMap(elementid string, m map[string]string) error {
+	if len(m) == 0 {
+		return nil
+	}
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	if _, err := conn.Do("HSET", hashArgs(rh.id+":"+elementid, m)...); err != nil {
+		return wrapError("HSET", rh.id+":"+elementid, err)
+	}
+	return rh.clearExpiry(conn, elementid, mapKeys(m)...)
+}
+
+// Set multiple keys and values for multiple element ids.
+// The commands are pipelined, so that this is fast also for large maps.
+func (rh *HashMap) SetLargeMap(all map[string]map[string]string) error {
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	// Also remove the expiration times, for servers without HPEXPIRE
+	clearExpiry := rh.hasExpiryIndex(conn, "")
+	index := expiryIndexKey(rh.id)
+	pending := 0
+	for elementid, m := range all {
+		if len(m) == 0 {
+			continue
+		}
+		if err := conn.Send("HSET", hashArgs(rh.id+":"+elementid, m)...); err != nil {
+			return wrapError("HSET", rh.id+":"+elementid, err)
+		}
+		pending++
+		if clearExpiry {
+			if err := conn.Send("ZREM", expiryArgs(index, elementid, mapKeys(m))...); err != nil {
+				return wrapError("ZREM", index, err)
+			}
+			pending++
+		}
+	}
+	if pending == 0 {
+		return nil
+	}
+	replies, err := conn.Do("")
+	if err == nil {
+		err = pipelineError(replies)
+	}
+	return wrapError("HSET", rh.id, err)
+}
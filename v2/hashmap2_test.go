@@ -0,0 +1,73 @@
+package simpleredis
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/xyproto/pinterface"
+)
+
+func TestHashMap2(t *testing.T) {
+	const hashmapname = "abc123_test_hashmap2_123abc"
+	hashPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer hashPool.Close()
+
+	hashmap := NewHashMap(hashPool, hashmapname)
+	hashmap.SelectDatabase(1)
+	var hm pinterface.IHashMap2 = hashmap
+	defer hm.Remove()
+
+	if empty, err := hm.Empty(); err != nil || !empty {
+		t.Errorf("Error, the hash map should be empty: %v", err)
+	}
+	if err := hm.SetMap("bob", map[string]string{"email": "bob@zombo.com", "role": "admin"}); err != nil {
+		t.Errorf("Error, could not set map! %s", err)
+	}
+	if value, err := hm.Get("bob", "role"); err != nil || value != "admin" {
+		t.Errorf("Error, wrong value: %s %v", value, err)
+	}
+	if m, err := hm.GetMap("bob", []string{"email", "role", "missing"}); err != nil || !reflect.DeepEqual(m, map[string]string{"email": "bob@zombo.com", "role": "admin"}) {
+		t.Errorf("Error, wrong map: %v %v", m, err)
+	}
+
+	// Many elements at once
+	all := map[string]map[string]string{}
+	for _, name := range []string{"alice", "carol", "dave"} {
+		all[name] = map[string]string{"email": name + "@zombo.com", "role": "user"}
+	}
+	all["carol"]["role"] = "admin"
+	if err := hm.SetLargeMap(all); err != nil {
+		t.Errorf("Error, could not set large map! %s", err)
+	}
+	if count, err := hm.Count(); err != nil || count != 4 {
+		t.Errorf("Error, wrong count: %d %v", count, err)
+	}
+	if empty, err := hm.Empty(); err != nil || empty {
+		t.Errorf("Error, the hash map should not be empty: %v", err)
+	}
+	admins, err := hm.AllWhere("role", "admin")
+	sort.Strings(admins)
+	if err != nil || !reflect.DeepEqual(admins, []string{"bob", "carol"}) {
+		t.Errorf("Error, wrong admins: %v %v", admins, err)
+	}
+	if nobody, err := hm.AllWhere("role", "superuser"); err != nil || len(nobody) != 0 {
+		t.Errorf("Error, expected no elements: %v %v", nobody, err)
+	}
+
+	// A pipelined command that fails, since the key holds a string
+	kv := NewKeyValue(hashPool, hashmapname+"_kv")
+	kv.SelectDatabase(1)
+	if err := kv.Set("x", "not a hash"); err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Remove()
+	wrongType := NewHashMap(hashPool, hashmapname+"_kv")
+	wrongType.SelectDatabase(1)
+	if err := wrongType.SetLargeMap(map[string]map[string]string{"x": {"a": "b"}, "y": {"a": "b"}}); err == nil {
+		t.Error("Error, setting a hash field on a string should fail")
+	}
+	if err := wrongType.SetLargeMap(nil); err != nil {
+		t.Errorf("Error, setting an empty map should not fail: %s", err)
+	}
+}
@@ -0,0 +1,171 @@
+//go:build go1.23
+
+package simpleredis
+
+import (
+	"iter"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// KeyAndValue is a key together with its value
+type KeyAndValue struct {
+	Key   string
+	Value string
+}
+
+// Iterate over a collection with SSCAN or HSCAN, or over keys with SCAN if
+// key is empty. The same connection is used for all batches, since a cursor
+// is only valid on the server that returned it, which may differ between the
+// connections of a pool with replicas or of a cluster pool.
+func scanSeq(r *redisDatastructure, command, key, pattern string) iter.Seq2[[]string, error] {
+	return func(yield func([]string, error) bool) {
+		conn := r.pool.get(r.ctx, r.dbindex)
+		defer conn.Close()
+		cursor := "0"
+		for {
+			next, batch, err := scanOnce(conn, command, key, cursor, pattern, r.pool.scanCountHint())
+			if err != nil {
+				if key == "" {
+					key = pattern
+				}
+				yield(nil, wrapError(command, key, err))
+				return
+			}
+			if len(batch) > 0 && !yield(batch, nil) {
+				return
+			}
+			if next == "0" {
+				return
+			}
+			cursor = next
+		}
+	}
+}
+
+// Iter returns an iterator over all elements in the list, from the first to
+// the last. The elements are fetched in batches with LRANGE, where the batch
+// size is the COUNT hint for SCAN. If the list is modified while iterating,
+// elements may be skipped or returned twice. If an error occurs, it is
+// returned as the last pair of the iteration.
+func (rl *List) Iter() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		count := int64(rl.pool.scanCountHint())
+		for start := int64(0); ; start += count {
+			conn := rl.pool.get(rl.ctx, rl.dbindex)
+			values, err := redis.Strings(conn.Do("LRANGE", rl.id, start, start+count-1))
+			conn.Close()
+			if err != nil {
+				yield("", wrapError("LRANGE", rl.id, err))
+				return
+			}
+			for _, value := range values {
+				if !yield(value, nil) {
+					return
+				}
+			}
+			if int64(len(values)) < count {
+				return
+			}
+		}
+	}
+}
+
+// Iter returns an iterator over all values in the set, using SSCAN.
+// A value may be returned more than once. If an error occurs, it is
+// returned as the last pair of the iteration.
+func (rs *Set) Iter() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for batch, err := range scanSeq((*redisDatastructure)(rs), "SSCAN", rs.id, "*") {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, value := range batch {
+				if !yield(value, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Iter returns an iterator over all element ids in the hash map, using SCAN.
+// An element id may be returned more than once. If an error occurs, it is
+// returned as the last pair of the iteration.
+func (rh *HashMap) Iter() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		idlen := len(rh.id)
+		for batch, err := range scanSeq((*redisDatastructure)(rh), "SCAN", "", escapePattern(rh.id)+":*") {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, key := range batch {
+				if !yield(key[idlen+1:], nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// IterFields returns an iterator over all keys and values for the given
+// element id, using HSCAN. If an error occurs, it is returned as the last
+// pair of the iteration.
+func (rh *HashMap) IterFields(elementid string) iter.Seq2[KeyAndValue, error] {
+	return func(yield func(KeyAndValue, error) bool) {
+		for batch, err := range scanSeq((*redisDatastructure)(rh), "HSCAN", rh.id+":"+elementid, "*") {
+			if err != nil {
+				yield(KeyAndValue{}, err)
+				return
+			}
+			// HSCAN returns the keys and values interleaved
+			for i := 0; i+1 < len(batch); i += 2 {
+				if !yield(KeyAndValue{batch[i], batch[i+1]}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Iter returns an iterator over all keys and values, using SCAN. The values
+// for each batch of keys are fetched with MGET, and keys that expire while
+// iterating are skipped. A key may be returned more than once. If an error
+// occurs, it is returned as the last pair of the iteration.
+func (rkv *KeyValue) Iter() iter.Seq2[KeyAndValue, error] {
+	return func(yield func(KeyAndValue, error) bool) {
+		idlen := len(rkv.id)
+		for batch, err := range scanSeq((*redisDatastructure)(rkv), "SCAN", "", escapePattern(rkv.id)+":*") {
+			if err != nil {
+				yield(KeyAndValue{}, err)
+				return
+			}
+			args := make([]interface{}, len(batch))
+			for i, key := range batch {
+				args[i] = key
+			}
+			conn := rkv.pool.get(rkv.ctx, rkv.dbindex)
+			values, err := redis.Values(conn.Do("MGET", args...))
+			conn.Close()
+			if err != nil {
+				yield(KeyAndValue{}, wrapError("MGET", rkv.id, err))
+				return
+			}
+			for i, value := range values {
+				if value == nil {
+					continue
+				}
+				s, err := redis.String(value, nil)
+				if err != nil {
+					yield(KeyAndValue{}, wrapError("MGET", batch[i], err))
+					return
+				}
+				if !yield(KeyAndValue{batch[i][idlen+1:], s}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
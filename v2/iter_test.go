@@ -0,0 +1,210 @@
+//go:build go1.23
+
+package simpleredis
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+func TestIter(t *testing.T) {
+	// A small COUNT hint, so that several batches are needed
+	iterPool, err := NewConnectionPoolOptions(testHost, &PoolOptions{DatabaseIndex: 1, ScanCount: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterPool.Close()
+	checkLeaks(iterPool)
+
+	list := NewList(iterPool, "abc123_test_iter_list")
+	set := NewSet(iterPool, "abc123_test_iter_set")
+	hashmap := NewHashMap(iterPool, "abc123_test_iter_hashmap")
+	kv := NewKeyValue(iterPool, "abc123_test_iter_kv")
+	defer list.Remove()
+	defer set.Remove()
+	defer hashmap.Remove()
+	defer kv.Remove()
+
+	var expected []string
+	for i := 0; i < 10; i++ {
+		s := strconv.Itoa(i)
+		expected = append(expected, s)
+		if err := list.Add(s); err != nil {
+			t.Fatal(err)
+		}
+		if err := set.Add(s); err != nil {
+			t.Fatal(err)
+		}
+		if err := hashmap.Set("user"+s, "name", s); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Set("key"+s, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(expected)
+
+	var values []string
+	for value, err := range list.Iter() {
+		if err != nil {
+			t.Fatalf("Error, could not iterate over the list! %s", err)
+		}
+		values = append(values, value)
+	}
+	// The list is in order
+	if len(values) != 10 || values[0] != "0" || values[9] != "9" {
+		t.Errorf("Error, wrong list values: %v", values)
+	}
+
+	values = nil
+	for value, err := range set.Iter() {
+		if err != nil {
+			t.Fatalf("Error, could not iterate over the set! %s", err)
+		}
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	if strings.Join(values, ",") != strings.Join(expected, ",") {
+		t.Errorf("Error, wrong set values: %v", values)
+	}
+
+	values = nil
+	for elementid, err := range hashmap.Iter() {
+		if err != nil {
+			t.Fatalf("Error, could not iterate over the hash map! %s", err)
+		}
+		values = append(values, elementid)
+	}
+	if len(values) != 10 {
+		t.Errorf("Error, wrong element ids: %v", values)
+	}
+
+	for field, err := range hashmap.IterFields("user3") {
+		if err != nil {
+			t.Fatalf("Error, could not iterate over the fields! %s", err)
+		}
+		if field.Key != "name" || field.Value != "3" {
+			t.Errorf("Error, wrong field: %v", field)
+		}
+	}
+
+	found := 0
+	for entry, err := range kv.Iter() {
+		if err != nil {
+			t.Fatalf("Error, could not iterate over the key/values! %s", err)
+		}
+		if entry.Key != "key"+entry.Value {
+			t.Errorf("Error, wrong key and value: %v", entry)
+		}
+		found++
+	}
+	if found != 10 {
+		t.Errorf("Error, wrong number of key/values: %d", found)
+	}
+
+	// Stop early
+	found = 0
+	for range list.Iter() {
+		found++
+		if found == 4 {
+			break
+		}
+	}
+	if found != 4 {
+		t.Errorf("Error, did not stop early: %d", found)
+	}
+
+	// The connections are returned to the pool also when stopping early
+	if active := (*redis.Pool)(iterPool).ActiveCount() - (*redis.Pool)(iterPool).IdleCount(); active != 0 {
+		t.Errorf("Error, %d connections were not returned to the pool!", active)
+	}
+
+	// Iterating over a key of the wrong type gives an error
+	wrongType := NewSet(iterPool, list.id)
+	errors := 0
+	for _, err := range wrongType.Iter() {
+		if err != nil {
+			errors++
+		}
+	}
+	if errors != 1 {
+		t.Errorf("Error, expected one error, got %d", errors)
+	}
+}
+
+// Make the server answer SCAN with the given keys, two at a time, and with
+// cursors that are only accepted by this server, like Redis servers where
+// the keys are stored differently
+func serverCursors(s *redistest.Server, name string, keys []string) {
+	s.Handle("SCAN", func(args []string) interface{} {
+		offset := 0
+		if args[1] != "0" {
+			n, err := strconv.Atoi(strings.TrimPrefix(args[1], name+"-"))
+			if !strings.HasPrefix(args[1], name+"-") || err != nil {
+				return errors.New("ERR " + name + " got the cursor " + args[1])
+			}
+			offset = n
+		}
+		end := offset + 2
+		if end > len(keys) {
+			end = len(keys)
+		}
+		page := []interface{}{}
+		for _, key := range keys[offset:end] {
+			page = append(page, key)
+		}
+		cursor := "0"
+		if end < len(keys) {
+			cursor = name + "-" + strconv.Itoa(end)
+		}
+		return []interface{}{cursor, page}
+	})
+}
+
+func TestIterReplicaCursors(t *testing.T) {
+	primary, replicas := replicaServers(t)
+	defer primary.Close()
+	defer replicas[0].Close()
+	defer replicas[1].Close()
+	var keys []string
+	for i := 0; i < 10; i++ {
+		keys = append(keys, "abc123_test_iter_replica:"+strconv.Itoa(i))
+	}
+	serverCursors(replicas[0], "replica1", keys)
+	serverCursors(replicas[1], "replica2", keys)
+	pool, err := NewConnectionPoolOptions(primary.Addr, &PoolOptions{
+		Replicas: []string{replicas[0].Addr, replicas[1].Addr},
+	})
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+
+	// Two iterations that are interleaved, each with cursors from one replica
+	hashmap := NewHashMap(pool, "abc123_test_iter_replica")
+	outer, inner := 0, 0
+	for _, err := range hashmap.Iter() {
+		if err != nil {
+			t.Fatalf("Error, could not iterate! %s", err)
+		}
+		outer++
+		if outer != 1 {
+			continue
+		}
+		for _, err := range hashmap.Iter() {
+			if err != nil {
+				t.Fatalf("Error, could not iterate! %s", err)
+			}
+			inner++
+		}
+	}
+	if outer != 10 || inner != 10 {
+		t.Errorf("Error, all elements should be returned: %d %d", outer, inner)
+	}
+}
@@ -0,0 +1,96 @@
+package simpleredis
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+// Connection pools that are checked for leaked connections after all tests have run
+var checkedPools []*ConnectionPool
+
+// Check the given connection pool for leaked connections after all tests have run
+func checkLeaks(p *ConnectionPool) *ConnectionPool {
+	checkedPools = append(checkedPools, p)
+	return p
+}
+
+// Run all tests against an in-memory Redis server, or against the server
+// given by SIMPLEREDIS_TEST_SERVER, then check that all connections were
+// returned to the pools
+func TestMain(m *testing.M) {
+	var testServer *redistest.Server
+	if testHost = os.Getenv("SIMPLEREDIS_TEST_SERVER"); testHost == "" {
+		testServer = redistest.NewServer()
+		defineTestScripts(testServer)
+		testHost = testServer.Addr
+	}
+	code := m.Run()
+	if pool != nil {
+		checkedPools = append(checkedPools, pool)
+	}
+	for _, p := range checkedPools {
+		// Closing the pool closes the idle connections, the rest are still in use
+		p.Close()
+		if n := (*redis.Pool)(p).ActiveCount(); n != 0 {
+			fmt.Fprintf(os.Stderr, "Error, %d connection(s) were not returned to the pool\n", n)
+			code = 1
+		}
+	}
+	if testServer != nil {
+		testServer.Close()
+	}
+	os.Exit(code)
+}
+
+func TestConnectionsReturned(t *testing.T) {
+	const (
+		listname = "abc123_test_returned_123abc"
+		kvname   = "abc123_test_returned_kv_123abc"
+		testdata = "123abc"
+	)
+	// With only one connection, any leaked connection makes the next operation fail
+	singlePool, err := NewConnectionPoolOptions(testHost, &PoolOptions{MaxActive: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer singlePool.Close()
+
+	list := NewList(singlePool, listname)
+	list.SelectDatabase(1)
+	for i := 0; i < 3; i++ {
+		if err := list.Add(testdata); err != nil {
+			t.Fatalf("Error, could not add item to list! %s", err)
+		}
+	}
+	if size, err := list.Size(); err != nil || size != 3 {
+		t.Errorf("Error, wrong list size: %d %v", size, err)
+	}
+
+	// The default database should be selected again when the connection is returned
+	kv := NewKeyValue(singlePool, kvname)
+	if err := kv.Set("a", testdata); err != nil {
+		t.Errorf("Error, could not set value! %s", err)
+	}
+	other := NewKeyValue(singlePool, kvname)
+	other.SelectDatabase(1)
+	if _, err := other.Get("a"); err == nil {
+		t.Error("Error, the value should only be in database 0")
+	}
+	if value, err := kv.Get("a"); err != nil || value != testdata {
+		t.Errorf("Error, wrong value in database 0: %s %v", value, err)
+	}
+
+	if err := kv.Remove(); err != nil {
+		t.Errorf("Error, could not remove key/value! %s", err)
+	}
+	if err := list.Remove(); err != nil {
+		t.Errorf("Error, could not remove list! %s", err)
+	}
+	if n := (*redis.Pool)(singlePool).ActiveCount() - (*redis.Pool)(singlePool).IdleCount(); n != 0 {
+		t.Errorf("Error, %d connection(s) were not returned to the pool", n)
+	}
+}
@@ -0,0 +1,170 @@
+package simpleredis
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// PoolOptions contains the settings for a single connection pool.
+// Zero values are replaced by the defaults, which can be changed with
+// SetConnectTimeout, SetReadTimeout, SetWriteTimeout, SetIdleTimeout and
+// SetMaxIdleConnections. A negative timeout means no timeout.
+type PoolOptions struct {
+	// Timeouts for connecting, reading and writing
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	// Idle connections are closed after this duration
+	IdleTimeout time.Duration
+
+	// The maximum number of idle connections in the pool
+	MaxIdle int
+
+	// The maximum number of connections in the pool, or 0 for no limit
+	MaxActive int
+
+	// If Wait is true and the pool is at the MaxActive limit, wait for a
+	// connection to be returned to the pool instead of returning an error
+	Wait bool
+
+	// Connections older than this duration are closed, or 0 for no limit
+	MaxConnLifetime time.Duration
+
+	// An optional function for checking the health of an idle connection
+	// before it is used again. If the function returns an error, the
+	// connection is closed.
+	TestOnBorrow func(c redis.Conn, t time.Time) error
+
+	// The database index used by data structures created with this pool
+	DatabaseIndex int
+
+	// Credentials. If only the password is given, the default user is used.
+	Username string
+	Password string
+
+	// Send the credentials with HELLO instead of AUTH, with a fallback to
+	// AUTH for servers that do not support HELLO
+	UseHello bool
+
+	// Credentials for the sentinels, for NewConnectionPoolSentinel.
+	// No credentials are used for the sentinels if these are empty.
+	SentinelUsername string
+	SentinelPassword string
+
+	// Connect with TLS, if the TLS options are not nil
+	TLS *TLSOptions
+
+	// The COUNT hint for SCAN, when iterating over keys.
+	// See SetScanCount for the default.
+	ScanCount int
+
+	// Replicas of the server, as host:port, for NewConnectionPoolOptions.
+	// If given, read-only commands are sent to the replicas and all other
+	// commands to the given server, which is the primary.
+	// NewConnectionPoolSentinel and NewConnectionPoolCluster return an
+	// error if replicas are given.
+	Replicas []string
+
+	// How a replica is chosen for each read-only command
+	ReadPolicy ReadPolicy
+
+	// After a write, read-only commands are sent to the primary for this
+	// long, so that the write can be read back before it has reached the
+	// replicas. Zero means that reads are always sent to the replicas.
+	ReadYourWritesWindow time.Duration
+}
+
+// DefaultPoolOptions returns pool options with the current default
+// timeouts and maximum number of idle connections
+func DefaultPoolOptions() *PoolOptions {
+	cu := defaultSettings()
+	return &PoolOptions{
+		ConnectTimeout: cu.connectTimeout,
+		ReadTimeout:    cu.readTimeout,
+		WriteTimeout:   cu.writeTimeout,
+		IdleTimeout:    cu.idleTimeout,
+		MaxIdle:        cu.maxIdle,
+	}
+}
+
+// Use the given timeout, unless it is zero. Negative timeouts are no timeouts.
+func timeoutOption(given, defaultTimeout time.Duration) time.Duration {
+	switch {
+	case given < 0:
+		return 0
+	case given == 0:
+		return defaultTimeout
+	}
+	return given
+}
+
+// Create connection settings from the given pool options
+func (o *PoolOptions) settings() *connectionSettings {
+	cu := defaultSettings()
+	if o == nil {
+		return cu
+	}
+	cu.connectTimeout = timeoutOption(o.ConnectTimeout, cu.connectTimeout)
+	cu.readTimeout = timeoutOption(o.ReadTimeout, cu.readTimeout)
+	cu.writeTimeout = timeoutOption(o.WriteTimeout, cu.writeTimeout)
+	cu.idleTimeout = timeoutOption(o.IdleTimeout, cu.idleTimeout)
+	if o.MaxIdle != 0 {
+		cu.maxIdle = o.MaxIdle
+	}
+	cu.maxActive = o.MaxActive
+	cu.wait = o.Wait
+	cu.maxConnLifetime = o.MaxConnLifetime
+	cu.testOnBorrow = o.TestOnBorrow
+	cu.scanCount = o.ScanCount
+	cu.dbindex = o.DatabaseIndex
+	cu.username = o.Username
+	cu.password = o.Password
+	cu.useHello = o.UseHello
+	cu.replicas = o.Replicas
+	cu.readPolicy = o.ReadPolicy
+	cu.readYourWrites = o.ReadYourWritesWindow
+	if o.TLS != nil {
+		cu.useTLS = true
+		cu.tlsOptions = o.TLS
+	}
+	return cu
+}
+
+// Check the options, and find the connection settings, with the TLS
+// configuration. Replicas are only allowed if the pool supports them.
+func (o *PoolOptions) checkedSettings(replicas bool) (*connectionSettings, error) {
+	if o != nil {
+		if o.DatabaseIndex < 0 {
+			return nil, errors.New("the database index can not be negative")
+		}
+		if len(o.Replicas) > 0 && !replicas {
+			return nil, errors.New("replicas are not supported for this connection pool")
+		}
+		if err := o.ReadPolicy.valid(); err != nil {
+			return nil, err
+		}
+	}
+	cu := o.settings()
+	if err := cu.loadTLSConfig(); err != nil {
+		return nil, err
+	}
+	return cu, nil
+}
+
+// Create a new connection pool given a host:port string, or the path to a
+// unix domain socket, and the options for this pool. The options may be nil,
+// for using the defaults. Returns an error if the options are invalid.
+// If replicas are given in the options, read-only commands are sent to the
+// replicas, and all other commands to the given host, which is the primary.
+// Close must be called when the pool is no longer needed.
+func NewConnectionPoolOptions(hostColonPort string, options *PoolOptions) (*ConnectionPool, error) {
+	cu, err := options.checkedSettings(true)
+	if err != nil {
+		return nil, err
+	}
+	cu.network, cu.address = networkAndAddress(hostColonPort)
+	return newConnectionPoolSettings(cu)
+}
@@ -0,0 +1,113 @@
+package simpleredis
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestPoolOptions(t *testing.T) {
+	const (
+		keyname  = "abc123_test_options_123abc"
+		testdata = "123abc"
+	)
+	borrowed := 0
+	fastPool, err := NewConnectionPoolOptions(testHost, &PoolOptions{
+		ReadTimeout:     time.Second,
+		WriteTimeout:    -1,
+		MaxIdle:         1,
+		MaxActive:       10,
+		Wait:            true,
+		MaxConnLifetime: time.Minute,
+		DatabaseIndex:   1,
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			borrowed++
+			_, err := c.Do("PING")
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fastPool.Close()
+	checkLeaks(fastPool)
+	slowPool, err := NewConnectionPoolOptions(testHost, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slowPool.Close()
+
+	// Each pool has its own settings
+	if fastPool.MaxIdle != 1 || fastPool.MaxActive != 10 || !fastPool.Wait || fastPool.MaxConnLifetime != time.Minute {
+		t.Errorf("Error, the pool options were not used: %+v", (*redis.Pool)(fastPool))
+	}
+	if slowPool.MaxIdle != MaxIdleConnections() || slowPool.MaxActive != 0 || slowPool.Wait || slowPool.IdleTimeout != IdleTimeout() {
+		t.Errorf("Error, the default options were not used: %+v", (*redis.Pool)(slowPool))
+	}
+	if fastPool.DatabaseIndex() != 1 || slowPool.DatabaseIndex() != 0 {
+		t.Errorf("Error, wrong database indices: %d %d", fastPool.DatabaseIndex(), slowPool.DatabaseIndex())
+	}
+
+	// Return a connection to the pool, so that it is tested when borrowed again
+	conn := fastPool.Get(1)
+	conn.Close()
+
+	kv := NewKeyValue(fastPool, keyname)
+	if err := kv.Set("a", testdata); err != nil {
+		t.Errorf("Error, could not set value! %s", err)
+	}
+	if value, err := kv.Get("a"); err != nil || value != testdata {
+		t.Errorf("Error, wrong value: %s %v", value, err)
+	}
+	if borrowed == 0 {
+		t.Error("Error, TestOnBorrow was not used")
+	}
+	if err := kv.Remove(); err != nil {
+		t.Errorf("Error, could not remove key/value! %s", err)
+	}
+
+	if _, err := NewConnectionPoolOptions(testHost, &PoolOptions{DatabaseIndex: -1}); err == nil {
+		t.Error("Error, a negative database index should not be accepted")
+	}
+}
+
+func TestPoolOptionsSettings(t *testing.T) {
+	cu := (&PoolOptions{ConnectTimeout: -1, ReadTimeout: 3 * time.Second, Username: "someone", Password: "secret", TLS: &TLSOptions{}}).settings()
+	if cu.connectTimeout != 0 || cu.readTimeout != 3*time.Second || cu.writeTimeout != WriteTimeout() {
+		t.Errorf("Error, wrong timeouts: %v %v %v", cu.connectTimeout, cu.readTimeout, cu.writeTimeout)
+	}
+	if cu.username != "someone" || cu.password != "secret" || !cu.useTLS {
+		t.Errorf("Error, wrong settings: %+v", cu)
+	}
+	options := DefaultPoolOptions()
+	if options.ConnectTimeout != ConnectTimeout() || options.MaxIdle != MaxIdleConnections() {
+		t.Errorf("Error, wrong default options: %+v", options)
+	}
+}
+
+func TestDefaultsConcurrently(t *testing.T) {
+	before := ReadTimeout()
+	defer SetReadTimeout(before)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			SetReadTimeout(time.Duration(i+1) * time.Second)
+		}(i)
+		go func() {
+			defer wg.Done()
+			if pool, err := NewConnectionPoolOptions(testHost, nil); err != nil {
+				t.Error(err)
+			} else {
+				pool.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	if ReadTimeout() == 0 {
+		t.Error("Error, the read timeout should have been set")
+	}
+}
@@ -0,0 +1,371 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrSubscriberClosed is returned when using a Subscriber that is closed
+var ErrSubscriberClosed = errors.New("the subscriber is closed")
+
+// DefaultPingInterval is how often a Subscriber sends PING by default,
+// for detecting dead connections
+const DefaultPingInterval = 30 * time.Second
+
+// How long to wait before reconnecting, which is doubled for each failed attempt
+var (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 5 * time.Second
+)
+
+// Message is a message that was received by a Subscriber
+type Message struct {
+	// The channel that the message was published to
+	Channel string
+	// The pattern that matched the channel, for pattern subscriptions
+	Pattern string
+	Data    string
+}
+
+// Publisher publishes messages to channels, with connections from the pool
+type Publisher struct {
+	pool *ConnectionPool
+	ctx  context.Context
+}
+
+// Subscriber receives messages from channels and from channels that match
+// patterns. The subscriber has its own connection, with the settings of the
+// pool, since the connection can not be used for other commands while
+// subscribing. If the connection is lost, the subscriber reconnects and
+// subscribes to the same channels and patterns again. Messages that are
+// published while reconnecting are lost.
+type Subscriber struct {
+	pool     *ConnectionPool
+	messages chan Message
+	mut      sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+	// The current connection, or nil while reconnecting
+	psc *redis.PubSubConn
+	// Subscribe calls that are waiting for the server to confirm, by kind and name
+	waiters      map[string][]chan struct{}
+	pingInterval time.Duration
+	closed       bool
+	// Closed by Close, and closed by the receiving goroutine when it is done
+	stop, done chan struct{}
+}
+
+/* --- Publisher functions --- */
+
+// Create a new publisher
+func (pool *ConnectionPool) NewPublisher() *Publisher {
+	return &Publisher{pool: pool}
+}
+
+// WithContext returns a copy of the publisher where publishing uses the
+// given context, so that it is aborted when the context is done
+func (p *Publisher) WithContext(ctx context.Context) *Publisher {
+	c := *p
+	c.ctx = ctx
+	return &c
+}
+
+// Publish a message to the given channel.
+// Returns the number of subscribers that received the message.
+func (p *Publisher) Publish(channel, message string) (int64, error) {
+	conn := p.pool.get(p.ctx, p.pool.state().dbindex)
+	defer conn.Close()
+	received, err := redis.Int64(conn.Do("PUBLISH", channel, message))
+	return received, wrapError("PUBLISH", channel, err)
+}
+
+/* --- Subscriber functions --- */
+
+// Dial a new connection with the settings of the pool, that is not part of the pool
+func (pool *ConnectionPool) dialConn() (redis.Conn, error) {
+	if pool.DialContext != nil {
+		return pool.DialContext(context.Background())
+	}
+	return pool.Dial()
+}
+
+// Create a new subscriber, and connect to the server. Subscribe to channels
+// with Subscribe or PSubscribe, and receive the messages from Messages.
+// The subscriber must be closed after use.
+func (pool *ConnectionPool) NewSubscriber() (*Subscriber, error) {
+	conn, err := pool.dialConn()
+	if err != nil {
+		return nil, &ConnectionError{err}
+	}
+	s := &Subscriber{
+		pool:         pool,
+		messages:     make(chan Message, 100),
+		channels:     make(map[string]bool),
+		patterns:     make(map[string]bool),
+		waiters:      make(map[string][]chan struct{}),
+		pingInterval: DefaultPingInterval,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go s.run(conn)
+	return s, nil
+}
+
+// SetPingInterval sets how often PING is sent for detecting dead connections.
+// The connection is considered dead if nothing is received for twice as long.
+// Takes effect for the next connection.
+func (s *Subscriber) SetPingInterval(interval time.Duration) {
+	s.mut.Lock()
+	s.pingInterval = interval
+	s.mut.Unlock()
+}
+
+// Messages returns the channel that the received messages are delivered on.
+// The channel is closed when the subscriber is closed.
+func (s *Subscriber) Messages() <-chan Message {
+	return s.messages
+}
+
+// Subscribe to the given channels. Waits until the server has confirmed the
+// subscriptions, unless the subscriber is reconnecting, in which case the
+// channels are subscribed to when connected.
+func (s *Subscriber) Subscribe(channels ...string) error {
+	return s.subscribe("subscribe", s.channels, channels)
+}
+
+// PSubscribe subscribes to all channels that match the given patterns, like
+// "news.*". Waits until the server has confirmed the subscriptions, unless
+// the subscriber is reconnecting.
+func (s *Subscriber) PSubscribe(patterns ...string) error {
+	return s.subscribe("psubscribe", s.patterns, patterns)
+}
+
+// Unsubscribe from the given channels, or from all channels if none are given
+func (s *Subscriber) Unsubscribe(channels ...string) error {
+	return s.unsubscribe("unsubscribe", s.channels, channels)
+}
+
+// PUnsubscribe unsubscribes from the given patterns, or from all patterns if none are given
+func (s *Subscriber) PUnsubscribe(patterns ...string) error {
+	return s.unsubscribe("punsubscribe", s.patterns, patterns)
+}
+
+// Convert strings to arguments for a command
+func stringArgs(strs []string) []interface{} {
+	args := make([]interface{}, len(strs))
+	for i, s := range strs {
+		args[i] = s
+	}
+	return args
+}
+
+// The keys of a set of channels or patterns
+func setKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Subscribe to channels or patterns, given the kind of subscription
+func (s *Subscriber) subscribe(kind string, set map[string]bool, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	s.mut.Lock()
+	if s.closed {
+		s.mut.Unlock()
+		return ErrSubscriberClosed
+	}
+	for _, name := range names {
+		set[name] = true
+	}
+	if s.psc == nil {
+		// Subscribed to when connected
+		s.mut.Unlock()
+		return nil
+	}
+	waiters := make([]chan struct{}, len(names))
+	for i, name := range names {
+		waiters[i] = make(chan struct{})
+		s.waiters[kind+" "+name] = append(s.waiters[kind+" "+name], waiters[i])
+	}
+	s.psc.Conn.Send(kind, stringArgs(names)...)
+	if err := s.psc.Conn.Flush(); err != nil {
+		// The connection failed, and the receiving goroutine will reconnect
+		s.mut.Unlock()
+		return nil
+	}
+	s.mut.Unlock()
+	for _, waiter := range waiters {
+		<-waiter
+	}
+	return nil
+}
+
+// Unsubscribe from channels or patterns, given the kind of subscription
+func (s *Subscriber) unsubscribe(kind string, set map[string]bool, names []string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.closed {
+		return ErrSubscriberClosed
+	}
+	if len(names) == 0 {
+		names = setKeys(set)
+	}
+	for _, name := range names {
+		delete(set, name)
+	}
+	if s.psc == nil || len(names) == 0 {
+		return nil
+	}
+	s.psc.Conn.Send(kind, stringArgs(names)...)
+	// If this fails, the receiving goroutine reconnects without the subscriptions
+	s.psc.Conn.Flush()
+	return nil
+}
+
+// Stop waiting for confirmations of subscriptions.
+// Must be called with the mutex locked.
+func (s *Subscriber) releaseWaiters(key string) {
+	for _, waiter := range s.waiters[key] {
+		close(waiter)
+	}
+	delete(s.waiters, key)
+}
+
+// Receive messages, and reconnect when the connection is lost, until closed
+func (s *Subscriber) run(conn redis.Conn) {
+	defer close(s.done)
+	defer close(s.messages)
+	delay := minReconnectDelay
+	for {
+		if conn != nil {
+			if s.receive(conn) {
+				delay = minReconnectDelay
+			}
+			conn.Close()
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+		var err error
+		if conn, err = s.pool.dialConn(); err != nil {
+			conn = nil
+		}
+	}
+}
+
+// Subscribe to all channels and patterns with the given connection, and
+// receive messages until the connection fails or the subscriber is closed.
+// Returns true if anything was received.
+func (s *Subscriber) receive(conn redis.Conn) bool {
+	psc := &redis.PubSubConn{Conn: conn}
+	s.mut.Lock()
+	if s.closed {
+		s.mut.Unlock()
+		return false
+	}
+	if len(s.channels) > 0 {
+		psc.Conn.Send("SUBSCRIBE", stringArgs(setKeys(s.channels))...)
+	}
+	if len(s.patterns) > 0 {
+		psc.Conn.Send("PSUBSCRIBE", stringArgs(setKeys(s.patterns))...)
+	}
+	if err := psc.Conn.Flush(); err != nil {
+		s.mut.Unlock()
+		return false
+	}
+	interval := s.pingInterval
+	s.psc = psc
+	s.mut.Unlock()
+
+	defer func() {
+		s.mut.Lock()
+		s.psc = nil
+		// The subscriptions are made again when reconnected
+		for key := range s.waiters {
+			s.releaseWaiters(key)
+		}
+		s.mut.Unlock()
+	}()
+
+	// Send PING regularly, and expect to receive something within twice the interval
+	pingDone := make(chan struct{})
+	defer close(pingDone)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pingDone:
+				return
+			case <-ticker.C:
+			}
+			s.mut.Lock()
+			psc.Ping("")
+			s.mut.Unlock()
+		}
+	}()
+
+	received := false
+	for {
+		switch v := psc.ReceiveWithTimeout(2 * interval).(type) {
+		case redis.Message:
+			received = true
+			select {
+			case s.messages <- Message{Channel: v.Channel, Pattern: v.Pattern, Data: string(v.Data)}:
+			case <-s.stop:
+				return received
+			}
+		case redis.Subscription:
+			received = true
+			s.mut.Lock()
+			s.releaseWaiters(v.Kind + " " + v.Channel)
+			s.mut.Unlock()
+		case redis.Pong:
+			received = true
+		case error:
+			if conn.Err() == nil {
+				// Without any subscriptions, the reply to PING is not
+				// a pong, which is fine
+				received = true
+				continue
+			}
+			// The connection failed, or nothing was received in time
+			return received
+		}
+	}
+}
+
+// Close the subscriber and the connection, and close the Messages channel
+func (s *Subscriber) Close() error {
+	s.mut.Lock()
+	if s.closed {
+		s.mut.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	psc := s.psc
+	for key := range s.waiters {
+		s.releaseWaiters(key)
+	}
+	s.mut.Unlock()
+	if psc != nil {
+		// Stop receiving
+		psc.Conn.Close()
+	}
+	<-s.done
+	return nil
+}
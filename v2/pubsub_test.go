@@ -0,0 +1,113 @@
+package simpleredis
+
+import (
+	"testing"
+	"time"
+)
+
+// Receive a message, or fail if no message arrives in time
+func receiveMessage(t *testing.T, s *Subscriber) Message {
+	t.Helper()
+	select {
+	case m := <-s.Messages():
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("Error, no message was received!")
+	}
+	return Message{}
+}
+
+// Publish a message until it is received by a subscriber, for instance
+// when waiting for a subscriber to reconnect
+func publishUntilReceived(t *testing.T, p *Publisher, channel, message string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		received, err := p.Publish(channel, message)
+		if err != nil {
+			t.Fatalf("Error, could not publish! %s", err)
+		}
+		if received > 0 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("Error, the message was not received by any subscriber!")
+}
+
+func TestPubSub(t *testing.T) {
+	defer func(delay time.Duration) {
+		minReconnectDelay = delay
+	}(minReconnectDelay)
+	minReconnectDelay = 10 * time.Millisecond
+
+	pubsubPool := NewConnectionPoolHost(testHost)
+	defer pubsubPool.Close()
+	checkLeaks(pubsubPool)
+
+	s, err := pubsubPool.NewSubscriber()
+	if err != nil {
+		t.Fatalf("Error, could not create subscriber! %s", err)
+	}
+	defer s.Close()
+	s.SetPingInterval(50 * time.Millisecond)
+
+	if err := s.Subscribe("abc123_test_news"); err != nil {
+		t.Fatalf("Error, could not subscribe! %s", err)
+	}
+	if err := s.PSubscribe("abc123_test_sports.*"); err != nil {
+		t.Fatalf("Error, could not subscribe! %s", err)
+	}
+
+	p := pubsubPool.NewPublisher()
+	if received, err := p.Publish("abc123_test_news", "hello"); err != nil || received != 1 {
+		t.Errorf("Error, the message should be received by one subscriber: %d %v", received, err)
+	}
+	if m := receiveMessage(t, s); m.Channel != "abc123_test_news" || m.Data != "hello" || m.Pattern != "" {
+		t.Errorf("Error, wrong message: %v", m)
+	}
+	p.Publish("abc123_test_sports.tennis", "match point")
+	if m := receiveMessage(t, s); m.Channel != "abc123_test_sports.tennis" || m.Pattern != "abc123_test_sports.*" || m.Data != "match point" {
+		t.Errorf("Error, wrong message: %v", m)
+	}
+
+	// Pings keep the connection alive while no messages are published
+	time.Sleep(300 * time.Millisecond)
+	if received, err := p.Publish("abc123_test_news", "still there"); err != nil || received != 1 {
+		t.Errorf("Error, the message should be received by one subscriber: %d %v", received, err)
+	}
+	if m := receiveMessage(t, s); m.Data != "still there" {
+		t.Errorf("Error, wrong message: %v", m)
+	}
+
+	// Disconnect the subscriber, which should reconnect and subscribe again
+	conn := pubsubPool.Get(pubsubPool.DatabaseIndex())
+	_, err = conn.Do("CLIENT", "KILL", "TYPE", "pubsub")
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Error, could not disconnect the subscriber! %s", err)
+	}
+	publishUntilReceived(t, p, "abc123_test_sports.golf", "again")
+	if m := receiveMessage(t, s); m.Data != "again" {
+		t.Errorf("Error, wrong message: %v", m)
+	}
+
+	if err := s.Unsubscribe(); err != nil {
+		t.Errorf("Error, could not unsubscribe! %s", err)
+	}
+	if err := s.PUnsubscribe("abc123_test_sports.*"); err != nil {
+		t.Errorf("Error, could not unsubscribe! %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if received, err := p.Publish("abc123_test_news", "gone"); err != nil || received != 0 {
+		t.Errorf("Error, the message should not be received: %d %v", received, err)
+	}
+
+	s.Close()
+	if _, ok := <-s.Messages(); ok {
+		t.Errorf("Error, the messages channel should be closed!")
+	}
+	if err := s.Subscribe("abc123_test_news"); err != ErrSubscriberClosed {
+		t.Errorf("Error, expected ErrSubscriberClosed! %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SlotCount is the number of hash slots in a Redis Cluster
+const SlotCount = 16384
+
+var clusterCommands = map[string]command{
+	"CLUSTER":  {f: cmdCluster, arity: -2},
+	"ASKING":   {f: cmdAsking, arity: 1},
+	"READONLY": {f: cmdReadOnly, arity: 1},
+}
+
+var errClusterDisabled = errors.New("ERR This instance has cluster support disabled")
+
+// Cluster is a group of in-memory servers that act like the masters of a
+// Redis Cluster. Each server owns a range of hash slots, and replies with
+// MOVED or ASK redirects for keys in slots that it does not own.
+type Cluster struct {
+	Servers []*Server
+
+	mu     sync.Mutex
+	owners [SlotCount]int
+	// The servers that slots are being migrated to
+	migrating map[int]int
+}
+
+// NewCluster starts the given number of servers on random ports on
+// 127.0.0.1, and divides the hash slots evenly between them
+func NewCluster(n int) *Cluster {
+	if n < 1 {
+		panic("redistest: a cluster needs at least one server")
+	}
+	cl := &Cluster{migrating: make(map[int]int)}
+	for i := 0; i < n; i++ {
+		s := NewServer()
+		s.mu.Lock()
+		s.cluster = cl
+		s.mu.Unlock()
+		cl.Servers = append(cl.Servers, s)
+	}
+	for slot := range cl.owners {
+		cl.owners[slot] = slot * n / SlotCount
+	}
+	return cl
+}
+
+// Addrs returns the host:port addresses of all servers in the cluster
+func (cl *Cluster) Addrs() []string {
+	addrs := make([]string, len(cl.Servers))
+	for i, s := range cl.Servers {
+		addrs[i] = s.Addr
+	}
+	return addrs
+}
+
+// Close stops all servers in the cluster
+func (cl *Cluster) Close() {
+	for _, s := range cl.Servers {
+		s.Close()
+	}
+}
+
+// Owner returns the server that owns the hash slot of the given key
+func (cl *Cluster) Owner(key string) *Server {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.Servers[cl.owners[KeySlot(key)]]
+}
+
+// MoveSlot moves a hash slot, and the keys in it, to the server with the
+// given index. The previous owner replies with MOVED for the slot.
+func (cl *Cluster) MoveSlot(slot, to int) {
+	cl.moveKeys(slot, to)
+	cl.mu.Lock()
+	cl.owners[slot] = to
+	delete(cl.migrating, slot)
+	cl.mu.Unlock()
+}
+
+// StartMigration moves the keys in a hash slot to the server with the given
+// index, but keeps the ownership of the slot, like while a slot is being
+// migrated. The owner replies with ASK for keys in the slot that it does not
+// have, and the target only accepts commands for the slot after ASKING.
+// Call MoveSlot to finish the migration.
+func (cl *Cluster) StartMigration(slot, to int) {
+	cl.mu.Lock()
+	cl.migrating[slot] = to
+	cl.mu.Unlock()
+	cl.moveKeys(slot, to)
+}
+
+// Move all keys in a hash slot to the server with the given index
+func (cl *Cluster) moveKeys(slot, to int) {
+	cl.mu.Lock()
+	target := cl.Servers[to]
+	cl.mu.Unlock()
+	for _, s := range cl.Servers {
+		if s == target {
+			continue
+		}
+		moved := make(map[string]*item)
+		s.mu.Lock()
+		for key, it := range s.dbs[0] {
+			if KeySlot(key) == slot {
+				moved[key] = it
+				delete(s.dbs[0], key)
+				s.touch(0, key)
+			}
+		}
+		s.mu.Unlock()
+		target.mu.Lock()
+		for key, it := range moved {
+			target.dbs[0][key] = it
+			target.touch(0, key)
+		}
+		target.mu.Unlock()
+	}
+}
+
+// Check if the given server may run a command for the given client, or
+// return a MOVED or ASK redirect. Called with the server locked.
+func (cl *Cluster) redirect(s *Server, c *client, name string, args []string) error {
+	asking := c.asking
+	c.asking = false
+	key, ok := commandKey(name, args)
+	if !ok {
+		return nil
+	}
+	slot := KeySlot(key)
+	cl.mu.Lock()
+	owner := cl.Servers[cl.owners[slot]]
+	to, migrating := cl.migrating[slot]
+	target := cl.Servers[to]
+	cl.mu.Unlock()
+	if owner == s {
+		if migrating && s.lookup(c.db, key) == nil {
+			return fmt.Errorf("ASK %d %s", slot, target.Addr)
+		}
+		return nil
+	}
+	if migrating && target == s && asking {
+		return nil
+	}
+	return fmt.Errorf("MOVED %d %s", slot, owner.Addr)
+}
+
+// Find the first key of a command, if the command has keys
+func commandKey(name string, args []string) (string, bool) {
+	switch name {
+	case "PING", "ECHO", "SELECT", "AUTH", "HELLO", "QUIT", "RESET", "CLIENT",
+		"INFO", "CONFIG", "FLUSHDB", "FLUSHALL", "DBSIZE", "TIME", "COMMAND",
+		"ROLE", "ACL", "MULTI", "EXEC", "DISCARD", "UNWATCH", "PUBLISH",
+		"SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PUBSUB",
+		"SCRIPT", "SCAN", "KEYS", "RANDOMKEY", "CLUSTER", "ASKING", "READONLY":
+		return "", false
+	case "EVAL", "EVALSHA":
+		if n, err := strconv.Atoi(args[2]); err == nil && n > 0 && len(args) > 3 {
+			return args[3], true
+		}
+		return "", false
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.EqualFold(arg, "STREAMS") && i+1 < len(args) {
+				return args[i+1], true
+			}
+		}
+		return "", false
+	case "XGROUP", "XINFO", "OBJECT", "MEMORY":
+		if len(args) > 2 {
+			return args[2], true
+		}
+		return "", false
+	}
+	if len(args) < 2 {
+		return "", false
+	}
+	return args[1], true
+}
+
+// KeySlot returns the hash slot of a key. If the key contains a hashtag,
+// like "{user1000}.following", only the hashtag is hashed.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % SlotCount)
+}
+
+// CRC16 with the XMODEM polynomial, as used by Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// The slot ranges for CLUSTER SLOTS
+func (cl *Cluster) slots() []interface{} {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var ranges []interface{}
+	start := 0
+	for slot := 1; slot <= SlotCount; slot++ {
+		if slot < SlotCount && cl.owners[slot] == cl.owners[start] {
+			continue
+		}
+		s := cl.Servers[cl.owners[start]]
+		host, port, _ := net.SplitHostPort(s.Addr)
+		portNumber, _ := strconv.Atoi(port)
+		node := []interface{}{host, int64(portNumber), fmt.Sprintf("%040d", cl.owners[start])}
+		ranges = append(ranges, []interface{}{int64(start), int64(slot - 1), node})
+		start = slot
+	}
+	return ranges
+}
+
+func cmdCluster(c *client, args []string) interface{} {
+	if c.s.cluster == nil {
+		return errClusterDisabled
+	}
+	switch strings.ToUpper(args[1]) {
+	case "SLOTS":
+		return c.s.cluster.slots()
+	case "KEYSLOT":
+		if len(args) != 3 {
+			return errArity("CLUSTER|KEYSLOT")
+		}
+		return int64(KeySlot(args[2]))
+	case "INFO":
+		return "cluster_enabled:1\r\ncluster_state:ok\r\ncluster_slots_assigned:16384\r\ncluster_known_nodes:" + strconv.Itoa(len(c.s.cluster.Servers)) + "\r\n"
+	}
+	return fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+}
+
+func cmdAsking(c *client, args []string) interface{} {
+	if c.s.cluster == nil {
+		return errClusterDisabled
+	}
+	c.asking = true
+	return Status("OK")
+}
+
+func cmdReadOnly(c *client, args []string) interface{} {
+	if c.s.cluster == nil {
+		return errClusterDisabled
+	}
+	return Status("OK")
+}
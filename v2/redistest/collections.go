@@ -0,0 +1,849 @@
+package redistest
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var listCommands = map[string]command{
+	"LPUSH":  {f: cmdPush, arity: -3, write: true},
+	"RPUSH":  {f: cmdPush, arity: -3, write: true},
+	"LPOP":   {f: cmdPop, arity: -2, write: true},
+	"RPOP":   {f: cmdPop, arity: -2, write: true},
+	"LLEN":   {f: cmdLLen, arity: 2},
+	"LINDEX": {f: cmdLIndex, arity: 3},
+	"LRANGE": {f: cmdLRange, arity: 4},
+	"LREM":   {f: cmdLRem, arity: 4, write: true},
+	"LSET":   {f: cmdLSet, arity: 4, write: true},
+	"LTRIM":  {f: cmdLTrim, arity: 4, write: true},
+}
+
+var setCommands = map[string]command{
+	"SADD":        {f: cmdSAdd, arity: -3, write: true},
+	"SREM":        {f: cmdSRem, arity: -3, write: true},
+	"SCARD":       {f: cmdSCard, arity: 2},
+	"SISMEMBER":   {f: cmdSIsMember, arity: 3},
+	"SMISMEMBER":  {f: cmdSMIsMember, arity: -3},
+	"SMEMBERS":    {f: cmdSMembers, arity: 2},
+	"SPOP":        {f: cmdSPop, arity: -2, write: true},
+	"SRANDMEMBER": {f: cmdSRandMember, arity: -2},
+	"SMOVE":       {f: cmdSMove, arity: 4, write: true},
+	"SSCAN":       {f: cmdSScan, arity: -3},
+}
+
+var hashCommands = map[string]command{
+	"HSET":     {f: cmdHSet, arity: -4, write: true},
+	"HMSET":    {f: cmdHSet, arity: -4, write: true},
+	"HSETNX":   {f: cmdHSetNX, arity: 4, write: true},
+	"HGET":     {f: cmdHGet, arity: 3},
+	"HMGET":    {f: cmdHMGet, arity: -3},
+	"HGETALL":  {f: cmdHGetAll, arity: 2},
+	"HDEL":     {f: cmdHDel, arity: -3, write: true},
+	"HEXISTS":  {f: cmdHExists, arity: 3},
+	"HKEYS":    {f: cmdHKeys, arity: 2},
+	"HVALS":    {f: cmdHVals, arity: 2},
+	"HLEN":     {f: cmdHLen, arity: 2},
+	"HINCRBY":  {f: cmdHIncrBy, arity: 4, write: true},
+	"HSCAN":    {f: cmdHScan, arity: -3},
+	"HEXPIRE":  {f: cmdHExpire, arity: -6, write: true},
+	"HPEXPIRE": {f: cmdHExpire, arity: -6, write: true},
+	"HTTL":     {f: cmdHTTL, arity: -5},
+	"HPTTL":    {f: cmdHTTL, arity: -5},
+	"HPERSIST": {f: cmdHPersist, arity: -5, write: true},
+}
+
+// --- Lists ---
+
+// Convert Redis style start and stop indices to a slice range
+func listRange(length int, start, stop int64) (int, int) {
+	n := int64(length)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+func cmdPush(c *client, args []string) interface{} {
+	it, err := c.s.lookupCreate(c.db, args[1], "list")
+	if err != nil {
+		return err
+	}
+	left := strings.ToUpper(args[0]) == "LPUSH"
+	for _, value := range args[2:] {
+		if left {
+			it.list = append([]string{value}, it.list...)
+		} else {
+			it.list = append(it.list, value)
+		}
+	}
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'l', strings.ToLower(args[0]), args[1])
+	return int64(len(it.list))
+}
+
+func cmdPop(c *client, args []string) interface{} {
+	if len(args) > 3 {
+		return errSyntax
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "list")
+	if err != nil {
+		return err
+	}
+	count, withCount := 1, len(args) == 3
+	if withCount {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return errors.New("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	if it == nil {
+		if withCount {
+			return nilArray
+		}
+		return nil
+	}
+	if count > len(it.list) {
+		count = len(it.list)
+	}
+	var popped []string
+	if strings.ToUpper(args[0]) == "LPOP" {
+		popped = append(popped, it.list[:count]...)
+		it.list = it.list[count:]
+	} else {
+		for i := 0; i < count; i++ {
+			popped = append(popped, it.list[len(it.list)-1-i])
+		}
+		it.list = it.list[:len(it.list)-count]
+	}
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'l', strings.ToLower(args[0]), args[1])
+	c.s.removeIfEmpty(c.db, args[1])
+	if withCount {
+		return popped
+	}
+	return popped[0]
+}
+
+func cmdLLen(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "list")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.list))
+}
+
+func cmdLIndex(c *client, args []string) interface{} {
+	index, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "list")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return nil
+	}
+	if index < 0 {
+		index += int64(len(it.list))
+	}
+	if index < 0 || index >= int64(len(it.list)) {
+		return nil
+	}
+	return it.list[index]
+}
+
+func cmdLRange(c *client, args []string) interface{} {
+	start, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	stop, err := parseInt(args[3])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "list")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return []string{}
+	}
+	from, to := listRange(len(it.list), start, stop)
+	return append([]string{}, it.list[from:to]...)
+}
+
+func cmdLRem(c *client, args []string) interface{} {
+	count, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "list")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var removed int64
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	kept := make([]string, 0, len(it.list))
+	if count >= 0 {
+		for _, value := range it.list {
+			if value == args[3] && (limit == 0 || removed < limit) {
+				removed++
+				continue
+			}
+			kept = append(kept, value)
+		}
+	} else {
+		for i := len(it.list) - 1; i >= 0; i-- {
+			if it.list[i] == args[3] && removed < limit {
+				removed++
+				continue
+			}
+			kept = append([]string{it.list[i]}, kept...)
+		}
+	}
+	it.list = kept
+	if removed > 0 {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 'l', "lrem", args[1])
+		c.s.removeIfEmpty(c.db, args[1])
+	}
+	return removed
+}
+
+func cmdLSet(c *client, args []string) interface{} {
+	index, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "list")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return errNoSuchKey
+	}
+	if index < 0 {
+		index += int64(len(it.list))
+	}
+	if index < 0 || index >= int64(len(it.list)) {
+		return errors.New("ERR index out of range")
+	}
+	it.list[index] = args[3]
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'l', "lset", args[1])
+	return Status("OK")
+}
+
+func cmdLTrim(c *client, args []string) interface{} {
+	start, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	stop, err := parseInt(args[3])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "list")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return Status("OK")
+	}
+	from, to := listRange(len(it.list), start, stop)
+	it.list = append([]string{}, it.list[from:to]...)
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'l', "ltrim", args[1])
+	c.s.removeIfEmpty(c.db, args[1])
+	return Status("OK")
+}
+
+// --- Sets ---
+
+func sortedMembers(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func cmdSAdd(c *client, args []string) interface{} {
+	it, err := c.s.lookupCreate(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	var added int64
+	for _, member := range args[2:] {
+		if !it.set[member] {
+			it.set[member] = true
+			added++
+		}
+	}
+	c.s.touch(c.db, args[1])
+	if added > 0 {
+		c.s.notify(c.db, 's', "sadd", args[1])
+	}
+	return added
+}
+
+func cmdSRem(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil || it == nil {
+		if err != nil {
+			return err
+		}
+		return int64(0)
+	}
+	var removed int64
+	for _, member := range args[2:] {
+		if it.set[member] {
+			delete(it.set, member)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 's', "srem", args[1])
+		c.s.removeIfEmpty(c.db, args[1])
+	}
+	return removed
+}
+
+func cmdSCard(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.set))
+}
+
+func cmdSIsMember(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	return it != nil && it.set[args[2]]
+}
+
+func cmdSMIsMember(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	reply := make([]interface{}, 0, len(args)-2)
+	for _, member := range args[2:] {
+		reply = append(reply, it != nil && it.set[member])
+	}
+	return reply
+}
+
+func cmdSMembers(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return []string{}
+	}
+	return sortedMembers(it.set)
+}
+
+// Pick members for SPOP and SRANDMEMBER. The members are not random, but
+// taken in sorted order, which makes tests deterministic.
+func cmdSPop(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	count, withCount := 1, len(args) == 3
+	if withCount {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return errors.New("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	if it == nil {
+		if withCount {
+			return []string{}
+		}
+		return nil
+	}
+	members := sortedMembers(it.set)
+	if count > len(members) {
+		count = len(members)
+	}
+	popped := members[:count]
+	for _, member := range popped {
+		delete(it.set, member)
+	}
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 's', "spop", args[1])
+	c.s.removeIfEmpty(c.db, args[1])
+	if withCount {
+		return popped
+	}
+	return popped[0]
+}
+
+func cmdSRandMember(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return errNotInteger
+		}
+		if it == nil {
+			return []string{}
+		}
+		members := sortedMembers(it.set)
+		if n < 0 {
+			n = -n
+		}
+		if n > len(members) {
+			n = len(members)
+		}
+		return members[:n]
+	}
+	if it == nil {
+		return nil
+	}
+	return sortedMembers(it.set)[0]
+}
+
+func cmdSMove(c *client, args []string) interface{} {
+	src, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	if _, err := c.s.lookupKind(c.db, args[2], "set"); err != nil {
+		return err
+	}
+	if src == nil || !src.set[args[3]] {
+		return int64(0)
+	}
+	delete(src.set, args[3])
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 's', "srem", args[1])
+	c.s.removeIfEmpty(c.db, args[1])
+	dst, _ := c.s.lookupCreate(c.db, args[2], "set")
+	dst.set[args[3]] = true
+	c.s.touch(c.db, args[2])
+	c.s.notify(c.db, 's', "sadd", args[2])
+	return int64(1)
+}
+
+func cmdSScan(c *client, args []string) interface{} {
+	opts, err := parseScan(args[2:])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "set")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return []interface{}{"0", []string{}}
+	}
+	cursor, found := scanPage(sortedMembers(it.set), opts, nil)
+	return []interface{}{cursor, found}
+}
+
+// --- Hashes ---
+
+func sortedFields(hash map[string]string) []string {
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func cmdHSet(c *client, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return errArity(args[0])
+	}
+	it, err := c.s.lookupCreate(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	var added int64
+	for i := 2; i < len(args); i += 2 {
+		if _, ok := it.hash[args[i]]; !ok {
+			added++
+		}
+		it.hash[args[i]] = args[i+1]
+		delete(it.fieldExpire, args[i])
+	}
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'h', "hset", args[1])
+	if strings.ToUpper(args[0]) == "HMSET" {
+		return Status("OK")
+	}
+	return added
+}
+
+func cmdHSetNX(c *client, args []string) interface{} {
+	it, err := c.s.lookupCreate(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	if _, ok := it.hash[args[2]]; ok {
+		return int64(0)
+	}
+	it.hash[args[2]] = args[3]
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'h', "hset", args[1])
+	return int64(1)
+}
+
+func cmdHGet(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return nil
+	}
+	value, ok := it.hash[args[2]]
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func cmdHMGet(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	reply := make([]interface{}, 0, len(args)-2)
+	for _, field := range args[2:] {
+		if it == nil {
+			reply = append(reply, nil)
+			continue
+		}
+		if value, ok := it.hash[field]; ok {
+			reply = append(reply, value)
+		} else {
+			reply = append(reply, nil)
+		}
+	}
+	return reply
+}
+
+func cmdHGetAll(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	reply := []string{}
+	if it == nil {
+		return reply
+	}
+	for _, field := range sortedFields(it.hash) {
+		reply = append(reply, field, it.hash[field])
+	}
+	return reply
+}
+
+func cmdHDel(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var removed int64
+	for _, field := range args[2:] {
+		if _, ok := it.hash[field]; ok {
+			delete(it.hash, field)
+			delete(it.fieldExpire, field)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 'h', "hdel", args[1])
+		c.s.removeIfEmpty(c.db, args[1])
+	}
+	return removed
+}
+
+func cmdHExists(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return false
+	}
+	_, ok := it.hash[args[2]]
+	return ok
+}
+
+func cmdHKeys(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return []string{}
+	}
+	return sortedFields(it.hash)
+}
+
+func cmdHVals(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	values := []string{}
+	if it == nil {
+		return values
+	}
+	for _, field := range sortedFields(it.hash) {
+		values = append(values, it.hash[field])
+	}
+	return values
+}
+
+func cmdHLen(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.hash))
+}
+
+func cmdHIncrBy(c *client, args []string) interface{} {
+	delta, err := parseInt(args[3])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupCreate(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	var n int64
+	if value, ok := it.hash[args[2]]; ok {
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errors.New("ERR hash value is not an integer")
+		}
+	}
+	n += delta
+	it.hash[args[2]] = strconv.FormatInt(n, 10)
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'h', "hincrby", args[1])
+	return n
+}
+
+func cmdHScan(c *client, args []string) interface{} {
+	opts, err := parseScan(args[2:])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return []interface{}{"0", []string{}}
+	}
+	cursor, fields := scanPage(sortedFields(it.hash), opts, nil)
+	found := make([]string, 0, len(fields)*2)
+	for _, field := range fields {
+		found = append(found, field, it.hash[field])
+	}
+	return []interface{}{cursor, found}
+}
+
+// Parse "FIELDS numfields field [field ...]" for the hash field expiry commands
+func parseFields(args []string) ([]string, error) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 {
+		return nil, errors.New("ERR Parameter `numFields` should be greater than 0")
+	}
+	if n != len(args)-2 {
+		return nil, errors.New("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+func cmdHExpire(c *client, args []string) interface{} {
+	n, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	d := time.Duration(n) * time.Second
+	if strings.ToUpper(args[0]) == "HPEXPIRE" {
+		d = time.Duration(n) * time.Millisecond
+	}
+	rest := args[3:]
+	condition := ""
+	switch strings.ToUpper(rest[0]) {
+	case "NX", "XX", "GT", "LT":
+		condition = strings.ToUpper(rest[0])
+		rest = rest[1:]
+	}
+	fields, err := parseFields(rest)
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	reply := make([]interface{}, 0, len(fields))
+	expireAt := c.s.now().Add(d)
+	for _, field := range fields {
+		if it == nil {
+			reply = append(reply, int64(-2))
+			continue
+		}
+		if _, ok := it.hash[field]; !ok {
+			reply = append(reply, int64(-2))
+			continue
+		}
+		current, hasExpire := it.fieldExpire[field]
+		ok := true
+		switch condition {
+		case "NX":
+			ok = !hasExpire
+		case "XX":
+			ok = hasExpire
+		case "GT":
+			ok = hasExpire && expireAt.After(current)
+		case "LT":
+			ok = !hasExpire || expireAt.Before(current)
+		}
+		if !ok {
+			reply = append(reply, int64(0))
+			continue
+		}
+		if d <= 0 {
+			delete(it.hash, field)
+			delete(it.fieldExpire, field)
+			reply = append(reply, int64(2))
+			continue
+		}
+		if it.fieldExpire == nil {
+			it.fieldExpire = make(map[string]time.Time)
+		}
+		it.fieldExpire[field] = expireAt
+		reply = append(reply, int64(1))
+	}
+	if it != nil {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 'h', "hexpire", args[1])
+		c.s.removeIfEmpty(c.db, args[1])
+	}
+	return reply
+}
+
+func cmdHTTL(c *client, args []string) interface{} {
+	fields, err := parseFields(args[2:])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	unit := time.Second
+	if strings.ToUpper(args[0]) == "HPTTL" {
+		unit = time.Millisecond
+	}
+	reply := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		if it == nil {
+			reply = append(reply, int64(-2))
+			continue
+		}
+		if _, ok := it.hash[field]; !ok {
+			reply = append(reply, int64(-2))
+			continue
+		}
+		t, ok := it.fieldExpire[field]
+		if !ok {
+			reply = append(reply, int64(-1))
+			continue
+		}
+		reply = append(reply, int64((t.Sub(c.s.now())+unit/2)/unit))
+	}
+	return reply
+}
+
+func cmdHPersist(c *client, args []string) interface{} {
+	fields, err := parseFields(args[2:])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "hash")
+	if err != nil {
+		return err
+	}
+	reply := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		if it == nil {
+			reply = append(reply, int64(-2))
+			continue
+		}
+		if _, ok := it.hash[field]; !ok {
+			reply = append(reply, int64(-2))
+			continue
+		}
+		if _, ok := it.fieldExpire[field]; !ok {
+			reply = append(reply, int64(-1))
+			continue
+		}
+		delete(it.fieldExpire, field)
+		reply = append(reply, int64(1))
+	}
+	if it != nil {
+		c.s.touch(c.db, args[1])
+	}
+	return reply
+}
@@ -0,0 +1,645 @@
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var connectionCommands = map[string]command{
+	"PING":     {f: cmdPing, arity: -1},
+	"ECHO":     {f: cmdEcho, arity: 2},
+	"QUIT":     {f: cmdQuit, arity: -1},
+	"SELECT":   {f: cmdSelect, arity: 2},
+	"AUTH":     {f: cmdAuth, arity: -2},
+	"HELLO":    {f: cmdHello, arity: -1},
+	"CLIENT":   {f: cmdClient, arity: -2},
+	"RESET":    {f: cmdReset, arity: 1},
+	"INFO":     {f: cmdInfo, arity: -1},
+	"CONFIG":   {f: cmdConfig, arity: -2},
+	"FLUSHDB":  {f: cmdFlushDB, arity: -1, write: true},
+	"FLUSHALL": {f: cmdFlushAll, arity: -1, write: true},
+	"DBSIZE":   {f: cmdDBSize, arity: 1},
+	"TIME":     {f: cmdTime, arity: 1},
+	"COMMAND":  {f: cmdCommand, arity: -1},
+	"ROLE":     {f: cmdRole, arity: 1},
+	"ACL":      {f: cmdACL, arity: -2},
+
+	"MULTI":   {f: cmdMulti, arity: 1},
+	"EXEC":    {f: cmdExec, arity: 1},
+	"DISCARD": {f: cmdDiscard, arity: 1},
+	"WATCH":   {f: cmdWatch, arity: -2},
+	"UNWATCH": {f: cmdUnwatch, arity: 1},
+
+	"PUBLISH":      {f: cmdPublish, arity: 3},
+	"SUBSCRIBE":    {f: cmdSubscribe, arity: -2},
+	"PSUBSCRIBE":   {f: cmdPSubscribe, arity: -2},
+	"UNSUBSCRIBE":  {f: cmdUnsubscribe, arity: -1},
+	"PUNSUBSCRIBE": {f: cmdPUnsubscribe, arity: -1},
+	"PUBSUB":       {f: cmdPubSub, arity: -2},
+}
+
+func cmdPing(c *client, args []string) interface{} {
+	if len(args) > 2 {
+		return errArity(args[0])
+	}
+	if len(c.channels)+len(c.patterns) > 0 {
+		message := ""
+		if len(args) == 2 {
+			message = args[1]
+		}
+		return []interface{}{"pong", message}
+	}
+	if len(args) == 2 {
+		return args[1]
+	}
+	return Status("PONG")
+}
+
+func cmdEcho(c *client, args []string) interface{} {
+	return args[1]
+}
+
+func cmdQuit(c *client, args []string) interface{} {
+	return errQuit
+}
+
+func cmdSelect(c *client, args []string) interface{} {
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errNotInteger
+	}
+	if n < 0 || n >= databases {
+		return errDBIndex
+	}
+	c.db = n
+	return Status("OK")
+}
+
+// Check a username and password, and log in the client if they are correct
+func (s *Server) auth(c *client, username, password string) error {
+	u, ok := s.users[username]
+	if !ok || u.disabled || (!u.nopass && u.password != password) {
+		return errWrongPass
+	}
+	c.user = username
+	c.authed = true
+	return nil
+}
+
+func cmdAuth(c *client, args []string) interface{} {
+	switch len(args) {
+	case 2:
+		if u := c.s.users["default"]; u != nil && u.nopass {
+			return errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+		if err := c.s.auth(c, "default", args[1]); err != nil {
+			return err
+		}
+	case 3:
+		if err := c.s.auth(c, args[1], args[2]); err != nil {
+			return err
+		}
+	default:
+		return errSyntax
+	}
+	return Status("OK")
+}
+
+func cmdHello(c *client, args []string) interface{} {
+	if len(args) > 1 {
+		protover, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New("ERR Protocol version is not an integer or out of range")
+		}
+		if protover != 2 {
+			return errors.New("NOPROTO unsupported protocol version")
+		}
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				if i+2 >= len(args) {
+					return errSyntax
+				}
+				if err := c.s.auth(c, args[i+1], args[i+2]); err != nil {
+					return err
+				}
+				i += 2
+			case "SETNAME":
+				if i+1 >= len(args) {
+					return errSyntax
+				}
+				c.name = args[i+1]
+				i++
+			default:
+				return errSyntax
+			}
+		}
+	}
+	if !c.authed {
+		if u := c.s.users["default"]; u == nil || !u.nopass {
+			return errNoAuth
+		}
+		c.authed = true
+	}
+	return []interface{}{
+		"server", "redis",
+		"version", redisVersion,
+		"proto", int64(2),
+		"id", c.id,
+		"mode", "standalone",
+		"role", "master",
+		"modules", []interface{}{},
+	}
+}
+
+func cmdClient(c *client, args []string) interface{} {
+	switch strings.ToUpper(args[1]) {
+	case "SETNAME":
+		if len(args) != 3 {
+			return errArity("client|setname")
+		}
+		c.name = args[2]
+		return Status("OK")
+	case "GETNAME":
+		if c.name == "" {
+			return nil
+		}
+		return c.name
+	case "ID":
+		return c.id
+	case "KILL":
+		return clientKill(c, args[2:])
+	case "SETINFO", "TRACKING", "NO-EVICT", "NO-TOUCH", "REPLY":
+		return Status("OK")
+	}
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try CLIENT HELP.", args[1])
+}
+
+// CLIENT KILL with the ID and TYPE filters. Returns the number of
+// disconnected clients.
+func clientKill(c *client, args []string) interface{} {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errors.New("ERR syntax error")
+	}
+	id := int64(-1)
+	kind := ""
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errors.New("ERR client-id should be greater than 0")
+			}
+			id = n
+		case "TYPE":
+			kind = strings.ToLower(args[i+1])
+			if kind != "normal" && kind != "pubsub" {
+				return fmt.Errorf("ERR Unknown client type '%s'", args[i+1])
+			}
+		default:
+			return errors.New("ERR syntax error")
+		}
+	}
+	var killed int64
+	for other := range c.s.clients {
+		if id >= 0 && other.id != id {
+			continue
+		}
+		pubsub := len(other.channels)+len(other.patterns) > 0
+		if (kind == "pubsub" && !pubsub) || (kind == "normal" && pubsub) {
+			continue
+		}
+		other.conn.Close()
+		killed++
+	}
+	return killed
+}
+
+func cmdReset(c *client, args []string) interface{} {
+	c.multi = false
+	c.queued = nil
+	c.dirty = false
+	c.watching = nil
+	c.s.unsubscribeAll(c)
+	c.db = 0
+	c.user = "default"
+	c.authed = false
+	return Status("RESET")
+}
+
+func cmdInfo(c *client, args []string) interface{} {
+	var sb strings.Builder
+	sb.WriteString("# Server\r\nredis_version:" + redisVersion + "\r\nredis_mode:standalone\r\n")
+	sb.WriteString("\r\n# Replication\r\nrole:master\r\nconnected_slaves:0\r\n")
+	sb.WriteString("\r\n# Keyspace\r\n")
+	for i := range c.s.dbs {
+		if n := len(c.s.dbs[i]); n > 0 {
+			sb.WriteString(fmt.Sprintf("db%d:keys=%d,expires=0,avg_ttl=0\r\n", i, n))
+		}
+	}
+	return sb.String()
+}
+
+func cmdConfig(c *client, args []string) interface{} {
+	switch strings.ToUpper(args[1]) {
+	case "GET":
+		if len(args) < 3 {
+			return errArity("config|get")
+		}
+		var names []string
+		for name := range c.s.config {
+			for _, pattern := range args[2:] {
+				if match(strings.ToLower(pattern), name) {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+		sort.Strings(names)
+		reply := make([]interface{}, 0, len(names)*2)
+		for _, name := range names {
+			reply = append(reply, name, c.s.config[name])
+		}
+		return reply
+	case "SET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return errArity("config|set")
+		}
+		for i := 2; i < len(args); i += 2 {
+			name := strings.ToLower(args[i])
+			if name == "notify-keyspace-events" {
+				// Normalize the flags in the same way as Redis
+				flags := args[i+1]
+				for _, r := range flags {
+					if !strings.ContainsRune("KEg$lshzxetmdnA", r) {
+						return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - Invalid event class character. Use 'Ag$lshzxeKEtmdn'.", name)
+					}
+				}
+			}
+			c.s.config[name] = args[i+1]
+		}
+		return Status("OK")
+	case "RESETSTAT", "REWRITE":
+		return Status("OK")
+	}
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[1])
+}
+
+func cmdFlushDB(c *client, args []string) interface{} {
+	c.s.flushdb(c.db)
+	return Status("OK")
+}
+
+func cmdFlushAll(c *client, args []string) interface{} {
+	for i := range c.s.dbs {
+		c.s.flushdb(i)
+	}
+	return Status("OK")
+}
+
+func cmdDBSize(c *client, args []string) interface{} {
+	return int64(len(c.s.sortedKeys(c.db, "*")))
+}
+
+func cmdTime(c *client, args []string) interface{} {
+	now := c.s.now()
+	return []interface{}{strconv.FormatInt(now.Unix(), 10), strconv.Itoa(now.Nanosecond() / 1000)}
+}
+
+func cmdCommand(c *client, args []string) interface{} {
+	if len(args) > 1 && strings.ToUpper(args[1]) == "COUNT" {
+		return int64(len(commands))
+	}
+	return []interface{}{}
+}
+
+func cmdRole(c *client, args []string) interface{} {
+	return []interface{}{"master", int64(0), []interface{}{}}
+}
+
+func cmdACL(c *client, args []string) interface{} {
+	switch strings.ToUpper(args[1]) {
+	case "WHOAMI":
+		return c.user
+	case "USERS":
+		var names []string
+		for name := range c.s.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	case "SETUSER":
+		if len(args) < 3 {
+			return errArity("acl|setuser")
+		}
+		u, ok := c.s.users[args[2]]
+		if !ok {
+			u = &user{disabled: true, commands: map[string]bool{}}
+		}
+		for _, rule := range args[3:] {
+			if err := u.apply(rule); err != nil {
+				return err
+			}
+		}
+		c.s.users[args[2]] = u
+		return Status("OK")
+	case "DELUSER":
+		var n int64
+		for _, name := range args[2:] {
+			if _, ok := c.s.users[name]; ok && name != "default" {
+				delete(c.s.users, name)
+				n++
+			}
+		}
+		return n
+	}
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try ACL HELP.", args[1])
+}
+
+// --- Transactions ---
+
+func cmdMulti(c *client, args []string) interface{} {
+	if c.multi {
+		return errors.New("ERR MULTI calls can not be nested")
+	}
+	c.multi = true
+	c.queued = nil
+	c.dirty = false
+	return Status("OK")
+}
+
+func cmdExec(c *client, args []string) interface{} {
+	if !c.multi {
+		return errors.New("ERR EXEC without MULTI")
+	}
+	queued, dirty, watching := c.queued, c.dirty, c.watching
+	c.multi, c.queued, c.dirty, c.watching = false, nil, false, nil
+	if dirty {
+		return errors.New("EXECABORT Transaction discarded because of previous errors.")
+	}
+	for key, version := range watching {
+		if c.s.versions[key] != version {
+			return nilArray
+		}
+	}
+	replies := make([]interface{}, len(queued))
+	for i, queuedArgs := range queued {
+		name := strings.ToUpper(queuedArgs[0])
+		if handler, ok := c.s.handlers[name]; ok {
+			replies[i] = handler(queuedArgs)
+			continue
+		}
+		replies[i] = commands[name].f(c, queuedArgs)
+	}
+	return replies
+}
+
+func cmdDiscard(c *client, args []string) interface{} {
+	if !c.multi {
+		return errors.New("ERR DISCARD without MULTI")
+	}
+	c.multi, c.queued, c.dirty, c.watching = false, nil, false, nil
+	return Status("OK")
+}
+
+func cmdWatch(c *client, args []string) interface{} {
+	if c.multi {
+		return errors.New("ERR WATCH inside MULTI is not allowed")
+	}
+	if c.watching == nil {
+		c.watching = make(map[string]uint64)
+	}
+	for _, key := range args[1:] {
+		// Let expired keys count as modified
+		c.s.lookup(c.db, key)
+		vk := versionKey(c.db, key)
+		if _, ok := c.watching[vk]; !ok {
+			c.watching[vk] = c.s.versions[vk]
+		}
+	}
+	return Status("OK")
+}
+
+func cmdUnwatch(c *client, args []string) interface{} {
+	c.watching = nil
+	return Status("OK")
+}
+
+// --- Pub/Sub ---
+
+// Deliver a message to all subscribers of a channel, returns the number of receivers
+func (s *Server) publish(channel, message string) int64 {
+	var receivers int64
+	for sub := range s.channels[channel] {
+		sub.write(encode([]interface{}{"message", channel, message}))
+		receivers++
+	}
+	for pattern, subs := range s.patterns {
+		if !match(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			sub.write(encode([]interface{}{"pmessage", pattern, channel, message}))
+			receivers++
+		}
+	}
+	return receivers
+}
+
+func (s *Server) unsubscribeAll(c *client) {
+	for channel := range c.channels {
+		delete(s.channels[channel], c)
+		if len(s.channels[channel]) == 0 {
+			delete(s.channels, channel)
+		}
+	}
+	for pattern := range c.patterns {
+		delete(s.patterns[pattern], c)
+		if len(s.patterns[pattern]) == 0 {
+			delete(s.patterns, pattern)
+		}
+	}
+	c.channels = make(map[string]bool)
+	c.patterns = make(map[string]bool)
+}
+
+func (c *client) subscriptions() int64 {
+	return int64(len(c.channels) + len(c.patterns))
+}
+
+func cmdPublish(c *client, args []string) interface{} {
+	return c.s.publish(args[1], args[2])
+}
+
+func cmdSubscribe(c *client, args []string) interface{} {
+	for _, channel := range args[1:] {
+		if c.s.channels[channel] == nil {
+			c.s.channels[channel] = make(map[*client]bool)
+		}
+		c.s.channels[channel][c] = true
+		c.channels[channel] = true
+		c.write(encode([]interface{}{"subscribe", channel, c.subscriptions()}))
+	}
+	return noReply
+}
+
+func cmdPSubscribe(c *client, args []string) interface{} {
+	for _, pattern := range args[1:] {
+		if c.s.patterns[pattern] == nil {
+			c.s.patterns[pattern] = make(map[*client]bool)
+		}
+		c.s.patterns[pattern][c] = true
+		c.patterns[pattern] = true
+		c.write(encode([]interface{}{"psubscribe", pattern, c.subscriptions()}))
+	}
+	return noReply
+}
+
+func cmdUnsubscribe(c *client, args []string) interface{} {
+	channels := args[1:]
+	if len(channels) == 0 {
+		for channel := range c.channels {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+	}
+	if len(channels) == 0 {
+		return []interface{}{"unsubscribe", nil, c.subscriptions()}
+	}
+	for _, channel := range channels {
+		delete(c.channels, channel)
+		delete(c.s.channels[channel], c)
+		if len(c.s.channels[channel]) == 0 {
+			delete(c.s.channels, channel)
+		}
+		c.write(encode([]interface{}{"unsubscribe", channel, c.subscriptions()}))
+	}
+	return noReply
+}
+
+func cmdPUnsubscribe(c *client, args []string) interface{} {
+	patterns := args[1:]
+	if len(patterns) == 0 {
+		for pattern := range c.patterns {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+	}
+	if len(patterns) == 0 {
+		return []interface{}{"punsubscribe", nil, c.subscriptions()}
+	}
+	for _, pattern := range patterns {
+		delete(c.patterns, pattern)
+		delete(c.s.patterns[pattern], c)
+		if len(c.s.patterns[pattern]) == 0 {
+			delete(c.s.patterns, pattern)
+		}
+		c.write(encode([]interface{}{"punsubscribe", pattern, c.subscriptions()}))
+	}
+	return noReply
+}
+
+func cmdPubSub(c *client, args []string) interface{} {
+	switch strings.ToUpper(args[1]) {
+	case "CHANNELS":
+		pattern := "*"
+		if len(args) > 2 {
+			pattern = args[2]
+		}
+		channels := []string{}
+		for channel := range c.s.channels {
+			if match(pattern, channel) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		return channels
+	case "NUMSUB":
+		reply := []interface{}{}
+		for _, channel := range args[2:] {
+			reply = append(reply, channel, int64(len(c.s.channels[channel])))
+		}
+		return reply
+	case "NUMPAT":
+		return int64(len(c.s.patterns))
+	}
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try PUBSUB HELP.", args[1])
+}
+
+// Wait until the server data changes or the timeout is reached.
+// Must be called with the server locked. Returns false on timeout.
+// A timeout of 0 waits forever.
+func (c *client) block(deadline time.Time) bool {
+	changed := c.s.changed
+	c.s.mu.Unlock()
+	defer c.s.mu.Lock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return false
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-changed:
+		return true
+	case <-timeout:
+		return false
+	case <-c.done:
+		return false
+	case <-c.s.quit:
+		return false
+	}
+}
+
+// Apply an ACL rule, like "on", ">password" or "+get", to a user.
+// Key patterns and categories other than @all are accepted, but ignored.
+func (u *user) apply(rule string) error {
+	lower := strings.ToLower(rule)
+	switch {
+	case lower == "on":
+		u.disabled = false
+	case lower == "off":
+		u.disabled = true
+	case lower == "nopass":
+		u.nopass, u.password = true, ""
+	case lower == "resetpass":
+		u.nopass, u.password = false, ""
+	case strings.HasPrefix(rule, ">"):
+		u.nopass, u.password = false, rule[1:]
+	case lower == "allcommands" || lower == "+@all":
+		u.commands, u.denied = nil, nil
+	case lower == "nocommands" || lower == "-@all":
+		u.commands, u.denied = map[string]bool{}, nil
+	case lower == "reset":
+		*u = user{disabled: true, commands: map[string]bool{}}
+	case strings.HasPrefix(lower, "+@") || strings.HasPrefix(lower, "-@"):
+	case strings.HasPrefix(rule, "+"):
+		name := strings.ToUpper(rule[1:])
+		delete(u.denied, name)
+		if u.commands != nil {
+			u.commands[name] = true
+		}
+	case strings.HasPrefix(rule, "-"):
+		name := strings.ToUpper(rule[1:])
+		if u.commands != nil {
+			delete(u.commands, name)
+		} else {
+			if u.denied == nil {
+				u.denied = make(map[string]bool)
+			}
+			u.denied[name] = true
+		}
+	case strings.HasPrefix(rule, "~"), strings.HasPrefix(rule, "%"), strings.HasPrefix(rule, "&"), lower == "allkeys", lower == "allchannels", lower == "resetkeys", lower == "resetchannels":
+	default:
+		return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Syntax error", rule)
+	}
+	return nil
+}
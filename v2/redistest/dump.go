@@ -0,0 +1,114 @@
+package redistest
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dumpCommands = map[string]command{
+	"DUMP":    {f: cmdDump, arity: 2},
+	"RESTORE": {f: cmdRestore, arity: -4, write: true},
+}
+
+// The serialized value of a key, for DUMP and RESTORE. This is not the RDB
+// format that Redis uses, so values can only be moved between these servers.
+type dumpedItem struct {
+	Kind string            `json:"kind"`
+	Str  string            `json:"str,omitempty"`
+	List []string          `json:"list,omitempty"`
+	Set  []string          `json:"set,omitempty"`
+	Hash map[string]string `json:"hash,omitempty"`
+	// Expiry times of hash fields, in Unix milliseconds
+	FieldExpire map[string]int64   `json:"fieldExpire,omitempty"`
+	ZSet        map[string]float64 `json:"zset,omitempty"`
+}
+
+func cmdDump(c *client, args []string) interface{} {
+	it := c.s.lookup(c.db, args[1])
+	if it == nil {
+		return nil
+	}
+	d := dumpedItem{Kind: it.kind, Str: it.str, List: it.list, Hash: it.hash, ZSet: it.zset}
+	switch it.kind {
+	case "set":
+		d.Set = sortedMembers(it.set)
+	case "stream":
+		return errors.New("ERR redistest: DUMP of streams is not supported")
+	}
+	if len(it.fieldExpire) > 0 {
+		d.FieldExpire = make(map[string]int64, len(it.fieldExpire))
+		for field, t := range it.fieldExpire {
+			d.FieldExpire[field] = t.UnixMilli()
+		}
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return errors.New("ERR redistest: " + err.Error())
+	}
+	return string(data)
+}
+
+func cmdRestore(c *client, args []string) interface{} {
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || ttl < 0 {
+		return errors.New("ERR Invalid TTL value, must be >= 0")
+	}
+	replace, absolute := false, false
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absolute = true
+		case "IDLETIME", "FREQ":
+			// Ignored, since keys are never evicted
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	var d dumpedItem
+	if err := json.Unmarshal([]byte(args[3]), &d); err != nil || d.Kind == "" {
+		return errors.New("ERR DUMP payload version or checksum are wrong")
+	}
+	key := args[1]
+	if c.s.lookup(c.db, key) != nil && !replace {
+		return errors.New("BUSYKEY Target key name already exists.")
+	}
+	it := &item{kind: d.Kind, str: d.Str, list: d.List, hash: d.Hash, zset: d.ZSet}
+	switch d.Kind {
+	case "set":
+		it.set = make(map[string]bool, len(d.Set))
+		for _, member := range d.Set {
+			it.set[member] = true
+		}
+	case "hash":
+		if it.hash == nil {
+			it.hash = make(map[string]string)
+		}
+		if len(d.FieldExpire) > 0 {
+			it.fieldExpire = make(map[string]time.Time, len(d.FieldExpire))
+			for field, ms := range d.FieldExpire {
+				it.fieldExpire[field] = time.UnixMilli(ms)
+			}
+		}
+	case "zset":
+		if it.zset == nil {
+			it.zset = make(map[string]float64)
+		}
+	}
+	if ttl > 0 {
+		if absolute {
+			it.expireAt = time.UnixMilli(ttl)
+		} else {
+			it.expireAt = c.s.now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+	c.s.dbs[c.db][key] = it
+	c.s.touch(c.db, key)
+	c.s.notify(c.db, 'g', "restore", key)
+	return Status("OK")
+}
@@ -0,0 +1,565 @@
+package redistest
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	posInf = math.Inf(1)
+	negInf = math.Inf(-1)
+)
+
+var keyCommands = map[string]command{
+	"DEL":       {f: cmdDel, arity: -2, write: true},
+	"UNLINK":    {f: cmdDel, arity: -2, write: true},
+	"EXISTS":    {f: cmdExists, arity: -2},
+	"TYPE":      {f: cmdType, arity: 2},
+	"KEYS":      {f: cmdKeys, arity: 2},
+	"SCAN":      {f: cmdScan, arity: -2},
+	"EXPIRE":    {f: cmdExpire, arity: -3, write: true},
+	"PEXPIRE":   {f: cmdExpire, arity: -3, write: true},
+	"PERSIST":   {f: cmdPersist, arity: 2, write: true},
+	"TTL":       {f: cmdTTL, arity: 2},
+	"PTTL":      {f: cmdTTL, arity: 2},
+	"RENAME":    {f: cmdRename, arity: 3, write: true},
+	"RANDOMKEY": {f: cmdRandomKey, arity: 1},
+}
+
+var stringCommands = map[string]command{
+	"GET":    {f: cmdGet, arity: 2},
+	"SET":    {f: cmdSet, arity: -3, write: true},
+	"SETNX":  {f: cmdSetNX, arity: 3, write: true},
+	"GETDEL": {f: cmdGetDel, arity: 2, write: true},
+	"MGET":   {f: cmdMGet, arity: -2},
+	"MSET":   {f: cmdMSet, arity: -3, write: true},
+	"INCR":   {f: cmdIncr, arity: 2, write: true},
+	"DECR":   {f: cmdIncr, arity: 2, write: true},
+	"INCRBY": {f: cmdIncr, arity: 3, write: true},
+	"DECRBY": {f: cmdIncr, arity: 3, write: true},
+	"APPEND": {f: cmdAppend, arity: 3, write: true},
+	"STRLEN": {f: cmdStrlen, arity: 2},
+}
+
+// Match a string against a glob-style pattern, the same way as Redis does
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			matched := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					if pattern[1] == s[0] {
+						matched = true
+					}
+					pattern = pattern[2:]
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[0] >= start && s[0] <= end {
+						matched = true
+					}
+					pattern = pattern[3:]
+				default:
+					if pattern[0] == s[0] {
+						matched = true
+					}
+					pattern = pattern[1:]
+				}
+			}
+			if len(pattern) > 0 {
+				pattern = pattern[1:]
+			}
+			if matched == not {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// All non-expired keys of a database that match the pattern, sorted
+func (s *Server) sortedKeys(dbindex int, pattern string) []string {
+	keys := []string{}
+	for key := range s.dbs[dbindex] {
+		if s.lookup(dbindex, key) == nil {
+			continue
+		}
+		if match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Options for the SCAN family of commands
+type scanOptions struct {
+	cursor  int
+	pattern string
+	count   int
+	kind    string
+}
+
+func parseScan(args []string) (scanOptions, error) {
+	opts := scanOptions{pattern: "*", count: 10}
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		return opts, errors.New("ERR invalid cursor")
+	}
+	opts.cursor = cursor
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			opts.pattern = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if n < 1 {
+				return opts, errSyntax
+			}
+			opts.count = n
+		case "TYPE":
+			opts.kind = strings.ToLower(args[i+1])
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// Return one page of results, given sorted elements. The cursor is an
+// offset into the sorted elements, and COUNT elements are examined
+// before the MATCH filter is applied, just like with Redis.
+// Return a page of elements for SCAN, SSCAN, HSCAN and ZSCAN. Like Redis,
+// the elements are visited in the order of a hash of each element, so that
+// elements that exist during the whole iteration are always returned, even
+// if other elements are added or removed between the calls.
+func scanPage(elements []string, opts scanOptions, keep func(string) bool) (string, []string) {
+	type hashed struct {
+		hash    int
+		element string
+	}
+	remaining := make([]hashed, 0, len(elements))
+	for _, element := range elements {
+		if hash := scanHash(element); hash >= opts.cursor {
+			remaining = append(remaining, hashed{hash, element})
+		}
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		if remaining[i].hash != remaining[j].hash {
+			return remaining[i].hash < remaining[j].hash
+		}
+		return remaining[i].element < remaining[j].element
+	})
+	found := []string{}
+	i := 0
+	// Elements with the same hash are always returned together
+	for ; i < len(remaining) && (i < opts.count || remaining[i].hash == remaining[i-1].hash); i++ {
+		element := remaining[i].element
+		if match(opts.pattern, element) && (keep == nil || keep(element)) {
+			found = append(found, element)
+		}
+	}
+	if i >= len(remaining) {
+		return "0", found
+	}
+	return strconv.Itoa(remaining[i].hash), found
+}
+
+// The position of an element in a SCAN iteration, which is never 0
+func scanHash(element string) int {
+	h := fnv.New32a()
+	h.Write([]byte(element))
+	return int(h.Sum32()) + 1
+}
+
+func cmdScan(c *client, args []string) interface{} {
+	opts, err := parseScan(args[1:])
+	if err != nil {
+		return err
+	}
+	keys := c.s.sortedKeys(c.db, "*")
+	cursor, found := scanPage(keys, opts, func(key string) bool {
+		return opts.kind == "" || c.s.dbs[c.db][key].kind == opts.kind
+	})
+	return []interface{}{cursor, found}
+}
+
+func cmdDel(c *client, args []string) interface{} {
+	var n int64
+	for _, key := range args[1:] {
+		if c.s.del(c.db, key) {
+			c.s.notify(c.db, 'g', "del", key)
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(c *client, args []string) interface{} {
+	var n int64
+	for _, key := range args[1:] {
+		if c.s.lookup(c.db, key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdType(c *client, args []string) interface{} {
+	it := c.s.lookup(c.db, args[1])
+	if it == nil {
+		return Status("none")
+	}
+	return Status(it.kind)
+}
+
+func cmdKeys(c *client, args []string) interface{} {
+	return c.s.sortedKeys(c.db, args[1])
+}
+
+func cmdRandomKey(c *client, args []string) interface{} {
+	keys := c.s.sortedKeys(c.db, "*")
+	if len(keys) == 0 {
+		return nil
+	}
+	return keys[0]
+}
+
+func cmdExpire(c *client, args []string) interface{} {
+	n, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	d := time.Duration(n) * time.Second
+	if strings.ToUpper(args[0]) == "PEXPIRE" {
+		d = time.Duration(n) * time.Millisecond
+	}
+	it := c.s.lookup(c.db, args[1])
+	if it == nil {
+		return int64(0)
+	}
+	if len(args) > 3 {
+		switch strings.ToUpper(args[3]) {
+		case "NX":
+			if !it.expireAt.IsZero() {
+				return int64(0)
+			}
+		case "XX":
+			if it.expireAt.IsZero() {
+				return int64(0)
+			}
+		case "GT":
+			if it.expireAt.IsZero() || !c.s.now().Add(d).After(it.expireAt) {
+				return int64(0)
+			}
+		case "LT":
+			if !it.expireAt.IsZero() && !c.s.now().Add(d).Before(it.expireAt) {
+				return int64(0)
+			}
+		default:
+			return errSyntax
+		}
+	}
+	c.s.touch(c.db, args[1])
+	if d <= 0 {
+		delete(c.s.dbs[c.db], args[1])
+		c.s.notify(c.db, 'g', "del", args[1])
+		return int64(1)
+	}
+	it.expireAt = c.s.now().Add(d)
+	c.s.notify(c.db, 'g', "expire", args[1])
+	return int64(1)
+}
+
+func cmdPersist(c *client, args []string) interface{} {
+	it := c.s.lookup(c.db, args[1])
+	if it == nil || it.expireAt.IsZero() {
+		return int64(0)
+	}
+	it.expireAt = time.Time{}
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'g', "persist", args[1])
+	return int64(1)
+}
+
+func cmdTTL(c *client, args []string) interface{} {
+	it := c.s.lookup(c.db, args[1])
+	if it == nil {
+		return int64(-2)
+	}
+	if it.expireAt.IsZero() {
+		return int64(-1)
+	}
+	d := it.expireAt.Sub(c.s.now())
+	if strings.ToUpper(args[0]) == "PTTL" {
+		return int64((d + time.Millisecond/2) / time.Millisecond)
+	}
+	return int64((d + time.Second/2) / time.Second)
+}
+
+func cmdRename(c *client, args []string) interface{} {
+	it := c.s.lookup(c.db, args[1])
+	if it == nil {
+		return errNoSuchKey
+	}
+	delete(c.s.dbs[c.db], args[1])
+	c.s.lookup(c.db, args[2])
+	c.s.dbs[c.db][args[2]] = it
+	c.s.touch(c.db, args[1])
+	c.s.touch(c.db, args[2])
+	c.s.notify(c.db, 'g', "rename_from", args[1])
+	c.s.notify(c.db, 'g', "rename_to", args[2])
+	return Status("OK")
+}
+
+// --- Strings ---
+
+func (s *Server) getString(dbindex int, key string) (*item, error) {
+	return s.lookupKind(dbindex, key, "string")
+}
+
+func (s *Server) setString(dbindex int, key, value string, expireAt time.Time) {
+	s.dbs[dbindex][key] = &item{kind: "string", str: value, expireAt: expireAt}
+	s.touch(dbindex, key)
+	s.notify(dbindex, '$', "set", key)
+}
+
+func cmdGet(c *client, args []string) interface{} {
+	it, err := c.s.getString(c.db, args[1])
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return nil
+	}
+	return it.str
+}
+
+func cmdSet(c *client, args []string) interface{} {
+	key, value := args[1], args[2]
+	var (
+		expireAt        time.Time
+		nx, xx, get     bool
+		keepTTL, hasTTL bool
+	)
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) || hasTTL {
+				return errSyntax
+			}
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return errors.New("ERR invalid expire time in 'set' command")
+			}
+			switch opt {
+			case "EX":
+				expireAt = c.s.now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				expireAt = c.s.now().Add(time.Duration(n) * time.Millisecond)
+			case "EXAT":
+				expireAt = time.Unix(n, 0)
+			case "PXAT":
+				expireAt = time.Unix(0, n*int64(time.Millisecond))
+			}
+			hasTTL = true
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+	it := c.s.lookup(c.db, key)
+	var old interface{}
+	if get && it != nil {
+		if it.kind != "string" {
+			return errWrongType
+		}
+		old = it.str
+	}
+	if (nx && it != nil) || (xx && it == nil) {
+		if get {
+			return old
+		}
+		return nil
+	}
+	if keepTTL && it != nil {
+		expireAt = it.expireAt
+	}
+	c.s.setString(c.db, key, value, expireAt)
+	if get {
+		return old
+	}
+	return Status("OK")
+}
+
+func cmdSetNX(c *client, args []string) interface{} {
+	if c.s.lookup(c.db, args[1]) != nil {
+		return int64(0)
+	}
+	c.s.setString(c.db, args[1], args[2], time.Time{})
+	return int64(1)
+}
+
+func cmdGetDel(c *client, args []string) interface{} {
+	it, err := c.s.getString(c.db, args[1])
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return nil
+	}
+	c.s.del(c.db, args[1])
+	c.s.notify(c.db, 'g', "del", args[1])
+	return it.str
+}
+
+func cmdMGet(c *client, args []string) interface{} {
+	reply := make([]interface{}, 0, len(args)-1)
+	for _, key := range args[1:] {
+		it := c.s.lookup(c.db, key)
+		if it == nil || it.kind != "string" {
+			reply = append(reply, nil)
+			continue
+		}
+		reply = append(reply, it.str)
+	}
+	return reply
+}
+
+func cmdMSet(c *client, args []string) interface{} {
+	if len(args)%2 != 1 {
+		return errArity(args[0])
+	}
+	for i := 1; i < len(args); i += 2 {
+		c.s.setString(c.db, args[i], args[i+1], time.Time{})
+	}
+	return Status("OK")
+}
+
+func cmdIncr(c *client, args []string) interface{} {
+	var delta int64 = 1
+	if len(args) == 3 {
+		n, err := parseInt(args[2])
+		if err != nil {
+			return err
+		}
+		delta = n
+	}
+	name := strings.ToUpper(args[0])
+	if name == "DECR" || name == "DECRBY" {
+		delta = -delta
+	}
+	it, err := c.s.getString(c.db, args[1])
+	if err != nil {
+		return err
+	}
+	var n int64
+	var expireAt time.Time
+	if it != nil {
+		if n, err = parseInt(it.str); err != nil {
+			return err
+		}
+		expireAt = it.expireAt
+	}
+	n += delta
+	c.s.dbs[c.db][args[1]] = &item{kind: "string", str: strconv.FormatInt(n, 10), expireAt: expireAt}
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, '$', "incrby", args[1])
+	return n
+}
+
+func cmdAppend(c *client, args []string) interface{} {
+	it, err := c.s.getString(c.db, args[1])
+	if err != nil {
+		return err
+	}
+	value := args[2]
+	var expireAt time.Time
+	if it != nil {
+		value = it.str + value
+		expireAt = it.expireAt
+	}
+	c.s.dbs[c.db][args[1]] = &item{kind: "string", str: value, expireAt: expireAt}
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, '$', "append", args[1])
+	return int64(len(value))
+}
+
+func cmdStrlen(c *client, args []string) interface{} {
+	it, err := c.s.getString(c.db, args[1])
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.str))
+}
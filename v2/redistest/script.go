@@ -0,0 +1,109 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var scriptCommands = map[string]command{
+	"SCRIPT":  {f: cmdScript, arity: -2},
+	"EVAL":    {f: cmdEval, arity: -3, write: true},
+	"EVALSHA": {f: cmdEval, arity: -3, write: true},
+}
+
+// ScriptFunc is a Go implementation of a Lua script. The call function
+// runs a command within the script, like redis.call does in Lua, and
+// returns the reply. Errors are returned as values of type error.
+type ScriptFunc func(call func(args ...string) interface{}, keys, argv []string) interface{}
+
+// DefineScript registers a Go implementation of the given Lua script.
+// The server can not run Lua, but EVAL and EVALSHA of a script with a
+// Go implementation work as expected, including the script cache.
+func (s *Server) DefineScript(source string, f ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.funcs[scriptSHA(source)] = f
+}
+
+// ScriptLoaded checks if a script with the given SHA1 digest is cached
+func (s *Server) ScriptLoaded(sha string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.scripts[strings.ToLower(sha)]
+	return ok
+}
+
+func scriptSHA(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+func cmdScript(c *client, args []string) interface{} {
+	switch strings.ToUpper(args[1]) {
+	case "LOAD":
+		if len(args) != 3 {
+			return errArity("script|load")
+		}
+		sha := scriptSHA(args[2])
+		c.s.scripts[sha] = args[2]
+		return sha
+	case "EXISTS":
+		reply := make([]interface{}, 0, len(args)-2)
+		for _, sha := range args[2:] {
+			_, ok := c.s.scripts[strings.ToLower(sha)]
+			reply = append(reply, ok)
+		}
+		return reply
+	case "FLUSH":
+		c.s.scripts = make(map[string]string)
+		return Status("OK")
+	}
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try SCRIPT HELP.", args[1])
+}
+
+func cmdEval(c *client, args []string) interface{} {
+	var sha string
+	if strings.ToUpper(args[0]) == "EVALSHA" {
+		sha = strings.ToLower(args[1])
+		if _, ok := c.s.scripts[sha]; !ok {
+			return errors.New("NOSCRIPT No matching script. Please use EVAL.")
+		}
+	} else {
+		sha = scriptSHA(args[1])
+		c.s.scripts[sha] = args[1]
+	}
+	numKeys, err := strconv.Atoi(args[2])
+	if err != nil {
+		return errNotInteger
+	}
+	if numKeys < 0 {
+		return errors.New("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-3 {
+		return errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	f, ok := c.s.funcs[sha]
+	if !ok {
+		return errors.New("ERR redistest can not run Lua, define a Go implementation of this script with Server.DefineScript")
+	}
+	keys := args[3 : 3+numKeys]
+	argv := args[3+numKeys:]
+	call := func(callArgs ...string) interface{} {
+		if len(callArgs) == 0 {
+			return errors.New("ERR Please specify at least one argument for this redis lib call")
+		}
+		cmd, ok := commands[strings.ToUpper(callArgs[0])]
+		if !ok {
+			return errors.New("ERR Unknown Redis command called from script")
+		}
+		if !checkArity(cmd, callArgs) {
+			return errArity(callArgs[0])
+		}
+		return cmd.f(c, callArgs)
+	}
+	return f(call, keys, argv)
+}
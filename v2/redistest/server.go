@@ -0,0 +1,852 @@
+// Package redistest provides an in-memory Redis server for hermetic tests.
+//
+// The server speaks RESP2 on a local TCP port and implements the commands
+// that simpleredis uses, so that both simpleredis and applications built on
+// top of it can be tested without a running Redis service.
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of databases, like the default "databases 16" in redis.conf
+	databases = 16
+
+	// The Redis version that is reported by INFO and HELLO
+	redisVersion = "7.4.0"
+
+	// How often expired keys are actively removed
+	expireInterval = 10 * time.Millisecond
+)
+
+// Server is an in-memory Redis server listening on a local address
+type Server struct {
+	// Addr is the host:port the server is listening at
+	Addr string
+
+	ln   net.Listener
+	quit chan struct{}
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	dbs      [databases]map[string]*item
+	versions map[string]uint64
+	counter  uint64
+	offset   time.Duration
+	changed  chan struct{}
+	users    map[string]*user
+	config   map[string]string
+	scripts  map[string]string
+	funcs    map[string]ScriptFunc
+	channels map[string]map[*client]bool
+	patterns map[string]map[*client]bool
+	clients  map[*client]bool
+	nextID   int64
+	handlers map[string]CommandFunc
+	// The cluster that the server is part of, or nil
+	cluster *Cluster
+}
+
+// CommandFunc can be used for overriding or adding commands with Handle.
+// The returned value is written as the reply: nil is a null bulk string,
+// string is a bulk string, int64 and int are integers, error is an error
+// reply, Status is a status reply and []interface{} is an array.
+type CommandFunc func(args []string) interface{}
+
+// Status is a simple string reply, like "OK"
+type Status string
+
+type user struct {
+	password string
+	nopass   bool
+	disabled bool
+	// Allowed commands, or nil if all commands are allowed
+	commands map[string]bool
+	denied   map[string]bool
+}
+
+// Check if the user is allowed to run the given command
+func (u *user) allowed(name string) bool {
+	switch name {
+	case "AUTH", "HELLO", "QUIT", "RESET":
+		return true
+	}
+	if u.denied[name] {
+		return false
+	}
+	return u.commands == nil || u.commands[name]
+}
+
+// NewServer starts a new server on a random port on 127.0.0.1.
+// It panics if the server could not be started, like httptest.NewServer.
+func NewServer() *Server {
+	s, err := NewServerAddr("127.0.0.1:0")
+	if err != nil {
+		panic("redistest: failed to listen on a port: " + err.Error())
+	}
+	return s
+}
+
+// NewServerAddr starts a new server that listens at the given host:port
+func NewServerAddr(hostColonPort string) (*Server, error) {
+	ln, err := net.Listen("tcp", hostColonPort)
+	if err != nil {
+		return nil, err
+	}
+	return NewServerListener(ln), nil
+}
+
+// NewServerListener starts a new server that accepts connections from the
+// given listener. This can be used for serving over unix sockets or TLS.
+func NewServerListener(ln net.Listener) *Server {
+	s := &Server{
+		Addr:     ln.Addr().String(),
+		ln:       ln,
+		quit:     make(chan struct{}),
+		versions: make(map[string]uint64),
+		changed:  make(chan struct{}),
+		users:    map[string]*user{"default": {nopass: true}},
+		config: map[string]string{
+			"notify-keyspace-events": "",
+			"databases":              strconv.Itoa(databases),
+		},
+		scripts:  make(map[string]string),
+		funcs:    make(map[string]ScriptFunc),
+		channels: make(map[string]map[*client]bool),
+		patterns: make(map[string]map[*client]bool),
+		clients:  make(map[*client]bool),
+		handlers: make(map[string]CommandFunc),
+	}
+	for i := range s.dbs {
+		s.dbs[i] = make(map[string]*item)
+	}
+	s.wg.Add(2)
+	go s.serve()
+	go s.expireLoop()
+	return s
+}
+
+// URL returns a redis:// URL for connecting to this server
+func (s *Server) URL() string {
+	if s.ln.Addr().Network() == "unix" {
+		return "unix://" + s.Addr
+	}
+	return "redis://" + s.Addr
+}
+
+// Close stops the server and disconnects all clients
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.quit:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	close(s.quit)
+	s.ln.Close()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// CloseClients disconnects all connected clients, while the server keeps
+// on accepting new connections. Useful for testing reconnects.
+func (s *Server) CloseClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// ClientCount returns the number of connected clients
+func (s *Server) ClientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// RequirePass sets the password for the default user, like "requirepass"
+func (s *Server) RequirePass(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users["default"] = &user{password: password, nopass: password == ""}
+}
+
+// AddUser adds an ACL user with the given password. If commands are given,
+// the user is only allowed to run those commands, and gets a NOPERM error
+// for all others.
+func (s *Server) AddUser(username, password string, commands ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := &user{password: password}
+	if len(commands) > 0 {
+		u.commands = make(map[string]bool)
+		for _, command := range commands {
+			u.commands[strings.ToUpper(command)] = true
+		}
+	}
+	s.users[username] = u
+}
+
+// Handle overrides or adds a command. The command name is case-insensitive.
+// The handler is called with the server locked and must not call back into
+// the server.
+func (s *Server) Handle(command string, f CommandFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[strings.ToUpper(command)] = f
+}
+
+// FlushAll removes all keys from all databases
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.dbs {
+		s.flushdb(i)
+	}
+}
+
+// FastForward moves the clock of the server forward, so that keys and
+// hash fields with an expiry time can be expired without sleeping.
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	s.offset += d
+	s.mu.Unlock()
+	s.expireAll()
+}
+
+// Keys returns all keys in the given database, in sorted order
+func (s *Server) Keys(dbindex int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedKeys(dbindex, "*")
+}
+
+// Exists checks if the given key exists in the given database
+func (s *Server) Exists(dbindex int, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(dbindex, key) != nil
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		c := newClient(s, conn)
+		s.mu.Lock()
+		select {
+		case <-s.quit:
+			s.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		s.nextID++
+		c.id = s.nextID
+		s.clients[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.run()
+		}()
+	}
+}
+
+func (s *Server) expireLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.expireAll()
+		}
+	}
+}
+
+// Remove all keys and hash fields that have expired
+func (s *Server) expireAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dbindex := range s.dbs {
+		for key := range s.dbs[dbindex] {
+			s.lookup(dbindex, key)
+		}
+	}
+}
+
+// Wake up blocked clients
+func (s *Server) signal() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// --- Clients ---
+
+type client struct {
+	s      *Server
+	id     int64
+	conn   net.Conn
+	r      *bufio.Reader
+	out    chan []byte
+	done   chan struct{}
+	db     int
+	user   string
+	authed bool
+	name   string
+
+	// Set by ASKING, for the next command
+	asking bool
+
+	multi    bool
+	queued   [][]string
+	dirty    bool
+	watching map[string]uint64
+
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+func newClient(s *Server, conn net.Conn) *client {
+	return &client{
+		s:        s,
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		out:      make(chan []byte, 4096),
+		done:     make(chan struct{}),
+		user:     "default",
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+	}
+}
+
+func (c *client) run() {
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		w := bufio.NewWriter(c.conn)
+		for {
+			select {
+			case b := <-c.out:
+				w.Write(b)
+				// Write everything that is queued before flushing
+				for more := true; more; {
+					select {
+					case b := <-c.out:
+						w.Write(b)
+					default:
+						more = false
+					}
+				}
+				if w.Flush() != nil {
+					c.conn.Close()
+				}
+			case <-c.done:
+				for more := true; more; {
+					select {
+					case b := <-c.out:
+						w.Write(b)
+					default:
+						more = false
+					}
+				}
+				w.Flush()
+				return
+			}
+		}
+	}()
+	defer func() {
+		c.s.mu.Lock()
+		c.s.unsubscribeAll(c)
+		delete(c.s.clients, c)
+		c.s.mu.Unlock()
+		close(c.done)
+		<-writerDone
+		c.conn.Close()
+	}()
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				c.write(encode(perr))
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		reply := c.s.exec(c, args)
+		if reply == errQuit {
+			c.write(encode(Status("OK")))
+			return
+		}
+		if reply != noReply {
+			c.write(encode(reply))
+		}
+	}
+}
+
+// Queue data for the client
+func (c *client) write(b []byte) {
+	select {
+	case c.out <- b:
+	case <-c.done:
+	}
+}
+
+// --- RESP ---
+
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "ERR Protocol error: " + string(e)
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		// Inline command, as sent by telnet or redis-cli
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > 1024*1024 {
+		return nil, protocolError("invalid multibulk length")
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError("expected '$', got '" + line + "'")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > 512*1024*1024 {
+			return nil, protocolError("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+type nullArray struct{}
+
+var (
+	// Returned by a command when nothing should be written
+	noReply = &struct{ noReply bool }{}
+	// Returned by QUIT
+	errQuit = errors.New("quit")
+	// A null multi bulk reply (*-1)
+	nilArray = nullArray{}
+)
+
+func encode(v interface{}) []byte {
+	var sb strings.Builder
+	encodeTo(&sb, v)
+	return []byte(sb.String())
+}
+
+func encodeTo(sb *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		sb.WriteString("$-1\r\n")
+	case nullArray:
+		sb.WriteString("*-1\r\n")
+	case Status:
+		sb.WriteString("+" + string(v) + "\r\n")
+	case error:
+		msg := v.Error()
+		msg = strings.ReplaceAll(msg, "\r", " ")
+		msg = strings.ReplaceAll(msg, "\n", " ")
+		sb.WriteString("-" + msg + "\r\n")
+	case int:
+		sb.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case int64:
+		sb.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case bool:
+		if v {
+			sb.WriteString(":1\r\n")
+		} else {
+			sb.WriteString(":0\r\n")
+		}
+	case float64:
+		s := formatFloat(v)
+		sb.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+	case string:
+		sb.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []byte:
+		sb.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + string(v) + "\r\n")
+	case []string:
+		sb.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, s := range v {
+			encodeTo(sb, s)
+		}
+	case []interface{}:
+		sb.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			encodeTo(sb, e)
+		}
+	default:
+		encodeTo(sb, fmt.Errorf("ERR redistest: can not encode reply of type %T", v))
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case f > 0 && f > 1e308:
+		return "inf"
+	case f < 0 && f < -1e308:
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// --- Errors ---
+
+var (
+	errSyntax     = errors.New("ERR syntax error")
+	errWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errNotFloat   = errors.New("ERR value is not a valid float")
+	errNoSuchKey  = errors.New("ERR no such key")
+	errNoAuth     = errors.New("NOAUTH Authentication required.")
+	errWrongPass  = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errDBIndex    = errors.New("ERR DB index is out of range")
+)
+
+func errArity(command string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+}
+
+func errUnknown(command string, args []string) error {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + arg + "'"
+	}
+	return fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", command, strings.Join(quoted, " "))
+}
+
+// --- Command dispatch ---
+
+type command struct {
+	f     func(c *client, args []string) interface{}
+	arity int // like in COMMAND INFO: negative means "at least"
+	write bool
+}
+
+var commands map[string]command
+
+func init() {
+	commands = make(map[string]command)
+	for name, cmd := range connectionCommands {
+		commands[name] = cmd
+	}
+	for _, table := range []map[string]command{clusterCommands, dumpCommands, keyCommands, stringCommands, listCommands, setCommands, hashCommands, zsetCommands, streamCommands, scriptCommands} {
+		for name, cmd := range table {
+			commands[name] = cmd
+		}
+	}
+}
+
+func checkArity(cmd command, args []string) bool {
+	if cmd.arity > 0 {
+		return len(args) == cmd.arity
+	}
+	return len(args) >= -cmd.arity
+}
+
+// Execute a command for a client, with the server locked
+func (s *Server) exec(c *client, args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.ToUpper(args[0])
+	handler, hasHandler := s.handlers[name]
+	cmd, ok := commands[name]
+	if !ok && !hasHandler {
+		if c.multi {
+			c.dirty = true
+		}
+		return errUnknown(args[0], args[1:])
+	}
+
+	// Authentication
+	if !c.authed && name != "AUTH" && name != "HELLO" && name != "QUIT" {
+		if u := s.users["default"]; u == nil || !u.nopass {
+			return errNoAuth
+		}
+		c.authed = true
+	}
+	if u := s.users[c.user]; u != nil && !u.allowed(name) {
+		if c.multi {
+			c.dirty = true
+		}
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", c.user, strings.ToLower(name))
+	}
+
+	// Redirects to the server that owns the hash slot of the key
+	if s.cluster != nil {
+		if err := s.cluster.redirect(s, c, name, args); err != nil {
+			if c.multi {
+				c.dirty = true
+			}
+			return err
+		}
+	}
+
+	if hasHandler {
+		return handler(args)
+	}
+	if !checkArity(cmd, args) {
+		if c.multi {
+			c.dirty = true
+		}
+		return errArity(name)
+	}
+
+	// Subscribe mode only allows some commands
+	if len(c.channels)+len(c.patterns) > 0 {
+		switch name {
+		case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PING", "QUIT", "RESET":
+		default:
+			return fmt.Errorf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(name))
+		}
+	}
+
+	// Transactions
+	if c.multi {
+		switch name {
+		case "EXEC", "DISCARD", "MULTI", "WATCH", "QUIT", "RESET":
+		default:
+			c.queued = append(c.queued, args)
+			return Status("QUEUED")
+		}
+	}
+
+	return cmd.f(c, args)
+}
+
+// --- Key space ---
+
+type item struct {
+	kind        string // "string", "list", "set", "hash", "zset" or "stream"
+	str         string
+	list        []string
+	set         map[string]bool
+	hash        map[string]string
+	fieldExpire map[string]time.Time
+	zset        map[string]float64
+	stream      *stream
+	expireAt    time.Time
+}
+
+func versionKey(dbindex int, key string) string {
+	return strconv.Itoa(dbindex) + "\x00" + key
+}
+
+// Mark a key as modified, for WATCH and for blocking commands
+func (s *Server) touch(dbindex int, key string) {
+	s.counter++
+	s.versions[versionKey(dbindex, key)] = s.counter
+	s.signal()
+}
+
+// Look up a key, removing it first if it has expired
+func (s *Server) lookup(dbindex int, key string) *item {
+	it, ok := s.dbs[dbindex][key]
+	if !ok {
+		return nil
+	}
+	now := s.now()
+	if !it.expireAt.IsZero() && !now.Before(it.expireAt) {
+		delete(s.dbs[dbindex], key)
+		s.touch(dbindex, key)
+		s.notify(dbindex, 'x', "expired", key)
+		return nil
+	}
+	if it.kind == "hash" && len(it.fieldExpire) > 0 {
+		for field, t := range it.fieldExpire {
+			if !now.Before(t) {
+				delete(it.hash, field)
+				delete(it.fieldExpire, field)
+				s.touch(dbindex, key)
+				s.notify(dbindex, 'x', "hexpired", key)
+			}
+		}
+		if len(it.hash) == 0 {
+			delete(s.dbs[dbindex], key)
+			s.notify(dbindex, 'g', "del", key)
+			return nil
+		}
+	}
+	return it
+}
+
+// Look up a key of the given kind. Returns nil if the key does not exist.
+func (s *Server) lookupKind(dbindex int, key, kind string) (*item, error) {
+	it := s.lookup(dbindex, key)
+	if it == nil {
+		return nil, nil
+	}
+	if it.kind != kind {
+		return nil, errWrongType
+	}
+	return it, nil
+}
+
+// Look up a key of the given kind, creating it if it does not exist
+func (s *Server) lookupCreate(dbindex int, key, kind string) (*item, error) {
+	it, err := s.lookupKind(dbindex, key, kind)
+	if err != nil || it != nil {
+		return it, err
+	}
+	it = &item{kind: kind}
+	switch kind {
+	case "set":
+		it.set = make(map[string]bool)
+	case "hash":
+		it.hash = make(map[string]string)
+	case "zset":
+		it.zset = make(map[string]float64)
+	case "stream":
+		it.stream = newStream()
+	}
+	s.dbs[dbindex][key] = it
+	return it, nil
+}
+
+// Remove a key if the data structure it holds is empty
+func (s *Server) removeIfEmpty(dbindex int, key string) {
+	it, ok := s.dbs[dbindex][key]
+	if !ok {
+		return
+	}
+	var empty bool
+	switch it.kind {
+	case "list":
+		empty = len(it.list) == 0
+	case "set":
+		empty = len(it.set) == 0
+	case "hash":
+		empty = len(it.hash) == 0
+	case "zset":
+		empty = len(it.zset) == 0
+	}
+	if empty {
+		delete(s.dbs[dbindex], key)
+		s.notify(dbindex, 'g', "del", key)
+	}
+}
+
+func (s *Server) del(dbindex int, key string) bool {
+	if s.lookup(dbindex, key) == nil {
+		return false
+	}
+	delete(s.dbs[dbindex], key)
+	s.touch(dbindex, key)
+	return true
+}
+
+func (s *Server) flushdb(dbindex int) {
+	for key := range s.dbs[dbindex] {
+		s.touch(dbindex, key)
+	}
+	s.dbs[dbindex] = make(map[string]*item)
+}
+
+// --- Keyspace notifications ---
+
+// Publish keyspace and keyevent notifications, if enabled with
+// CONFIG SET notify-keyspace-events. The class is one of the
+// characters used in the configuration string, like 'g' or 'h'.
+func (s *Server) notify(dbindex int, class byte, event, key string) {
+	flags := s.config["notify-keyspace-events"]
+	if flags == "" {
+		return
+	}
+	enabled := strings.IndexByte(flags, class) >= 0
+	if strings.IndexByte(flags, 'A') >= 0 && strings.IndexByte("g$lshzxet", class) >= 0 {
+		enabled = true
+	}
+	if !enabled {
+		return
+	}
+	db := strconv.Itoa(dbindex)
+	if strings.IndexByte(flags, 'K') >= 0 {
+		s.publish("__keyspace@"+db+"__:"+key, event)
+	}
+	if strings.IndexByte(flags, 'E') >= 0 {
+		s.publish("__keyevent@"+db+"__:"+event, key)
+	}
+}
+
+// Parse an integer argument
+func parseInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	return n, nil
+}
+
+// Parse a float argument, accepting inf and -inf
+func parseFloat(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return posInf, nil
+	case "-inf":
+		return negInf, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != f {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
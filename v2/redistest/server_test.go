@@ -0,0 +1,227 @@
+package redistest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Connect to the given server, or fail
+func dial(t *testing.T, s *Server, options ...redis.DialOption) redis.Conn {
+	t.Helper()
+	conn, err := redis.Dial("tcp", s.Addr, options...)
+	if err != nil {
+		t.Fatalf("Error, could not connect! %s", err)
+	}
+	return conn
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	conn := dial(t, s)
+	defer conn.Close()
+
+	if pong, err := redis.String(conn.Do("PING")); err != nil || pong != "PONG" {
+		t.Errorf("Error, wrong reply to PING: %s %v", pong, err)
+	}
+	if _, err := conn.Do("SET", "a", "1"); err != nil {
+		t.Errorf("Error, could not set value! %s", err)
+	}
+	if value, err := redis.String(conn.Do("GET", "a")); err != nil || value != "1" {
+		t.Errorf("Error, wrong value: %s %v", value, err)
+	}
+	if _, err := conn.Do("RPUSH", "list", "x", "y", "z"); err != nil {
+		t.Errorf("Error, could not push to list! %s", err)
+	}
+	if elements, err := redis.Strings(conn.Do("LRANGE", "list", 0, -1)); err != nil || len(elements) != 3 || elements[2] != "z" {
+		t.Errorf("Error, wrong elements: %v %v", elements, err)
+	}
+	if _, err := conn.Do("GET", "list"); err == nil {
+		t.Error("Error, GET of a list should fail with WRONGTYPE")
+	}
+	if _, err := conn.Do("NOSUCHCOMMAND"); err == nil {
+		t.Error("Error, unknown commands should fail")
+	}
+
+	// Databases are separate
+	if _, err := conn.Do("SELECT", 1); err != nil {
+		t.Fatalf("Error, could not select database! %s", err)
+	}
+	if _, err := redis.String(conn.Do("GET", "a")); err != redis.ErrNil {
+		t.Errorf("Error, the key should only be in database 0: %v", err)
+	}
+	if keys := s.Keys(0); len(keys) != 2 || keys[0] != "a" || keys[1] != "list" {
+		t.Errorf("Error, wrong keys: %v", keys)
+	}
+
+	s.FlushAll()
+	if s.Exists(0, "a") {
+		t.Error("Error, all keys should have been removed")
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RequirePass("s3cret")
+	s.AddUser("reader", "pw", "get", "ping")
+
+	conn := dial(t, s)
+	defer conn.Close()
+	if _, err := conn.Do("GET", "a"); err == nil {
+		t.Error("Error, commands should require authentication")
+	}
+	if _, err := conn.Do("AUTH", "wrong"); err == nil {
+		t.Error("Error, a wrong password should fail")
+	}
+	if _, err := conn.Do("AUTH", "s3cret"); err != nil {
+		t.Errorf("Error, could not authenticate! %s", err)
+	}
+
+	reader := dial(t, s, redis.DialUsername("reader"), redis.DialPassword("pw"))
+	defer reader.Close()
+	if _, err := redis.String(reader.Do("GET", "a")); err != redis.ErrNil {
+		t.Errorf("Error, the user should be allowed to GET! %v", err)
+	}
+	if _, err := reader.Do("SET", "a", "1"); err == nil {
+		t.Error("Error, the user should not be allowed to SET")
+	}
+}
+
+func TestServerFastForward(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	conn := dial(t, s)
+	defer conn.Close()
+
+	if _, err := conn.Do("SET", "a", "1", "EX", 60); err != nil {
+		t.Fatalf("Error, could not set value! %s", err)
+	}
+	if ttl, err := redis.Int(conn.Do("TTL", "a")); err != nil || ttl != 60 {
+		t.Errorf("Error, wrong TTL: %d %v", ttl, err)
+	}
+	s.FastForward(time.Minute)
+	if s.Exists(0, "a") {
+		t.Error("Error, the key should have expired")
+	}
+}
+
+func TestServerScript(t *testing.T) {
+	const source = `return redis.call("INCRBY", KEYS[1], ARGV[1])`
+	s := NewServer()
+	defer s.Close()
+	s.DefineScript(source, func(call func(args ...string) interface{}, keys, argv []string) interface{} {
+		return call("INCRBY", keys[0], argv[0])
+	})
+	conn := dial(t, s)
+	defer conn.Close()
+
+	script := redis.NewScript(1, source)
+	if n, err := redis.Int(script.Do(conn, "counter", 2)); err != nil || n != 2 {
+		t.Errorf("Error, wrong reply from script: %d %v", n, err)
+	}
+	if !s.ScriptLoaded(script.Hash()) {
+		t.Error("Error, the script should be in the script cache")
+	}
+	if _, err := conn.Do("EVAL", `return 1`, 0); err == nil {
+		t.Error("Error, scripts without a Go implementation should fail")
+	}
+}
+
+func TestServerCloseClients(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	conn := dial(t, s)
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.ClientCount(); n != 1 {
+		t.Errorf("Error, wrong number of clients: %d", n)
+	}
+	s.CloseClients()
+	if _, err := conn.Do("PING"); err == nil {
+		t.Error("Error, the connection should have been closed")
+	}
+}
+
+func TestCluster(t *testing.T) {
+	cl := NewCluster(2)
+	defer cl.Close()
+	if slot := KeySlot("{user1000}.following"); slot != KeySlot("user1000") {
+		t.Errorf("Error, the hashtag should be hashed: %d", slot)
+	}
+
+	owner := cl.Owner("a")
+	other := cl.Servers[0]
+	if other == owner {
+		other = cl.Servers[1]
+	}
+	conn := dial(t, other)
+	defer conn.Close()
+	if _, err := conn.Do("SET", "a", "1"); err == nil || !strings.HasPrefix(err.Error(), "MOVED ") {
+		t.Errorf("Error, expected a MOVED redirect! %v", err)
+	}
+	ownerConn := dial(t, owner)
+	defer ownerConn.Close()
+	if _, err := ownerConn.Do("SET", "a", "1"); err != nil {
+		t.Errorf("Error, could not set value! %s", err)
+	}
+	slots, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil || len(slots) != 2 {
+		t.Errorf("Error, wrong slot ranges: %v %v", slots, err)
+	}
+
+	// While migrating, the owner replies with ASK for keys it does not have
+	to := 0
+	if cl.Servers[0] == owner {
+		to = 1
+	}
+	cl.StartMigration(KeySlot("a"), to)
+	if _, err := ownerConn.Do("GET", "a"); err == nil || !strings.HasPrefix(err.Error(), "ASK ") {
+		t.Errorf("Error, expected an ASK redirect! %v", err)
+	}
+	conn.Send("ASKING")
+	if value, err := redis.String(conn.Do("GET", "a")); err != nil || value != "1" {
+		t.Errorf("Error, wrong value after ASKING: %s %v", value, err)
+	}
+	cl.MoveSlot(KeySlot("a"), to)
+	if value, err := redis.String(conn.Do("GET", "a")); err != nil || value != "1" {
+		t.Errorf("Error, wrong value after the migration: %s %v", value, err)
+	}
+}
+
+func TestServerDumpRestore(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	other := NewServer()
+	defer other.Close()
+	conn := dial(t, s)
+	defer conn.Close()
+	otherConn := dial(t, other)
+	defer otherConn.Close()
+
+	conn.Do("HSET", "h", "a", "1", "b", "2")
+	data, err := conn.Do("DUMP", "h")
+	if err != nil || data == nil {
+		t.Fatalf("Error, could not dump key! %v", err)
+	}
+	if _, err := otherConn.Do("RESTORE", "h", 60000, data); err != nil {
+		t.Fatalf("Error, could not restore key! %s", err)
+	}
+	if value, err := redis.String(otherConn.Do("HGET", "h", "b")); err != nil || value != "2" {
+		t.Errorf("Error, wrong value: %s %v", value, err)
+	}
+	if ttl, err := redis.Int(otherConn.Do("TTL", "h")); err != nil || ttl != 60 {
+		t.Errorf("Error, wrong TTL: %d %v", ttl, err)
+	}
+	if _, err := otherConn.Do("RESTORE", "h", 0, data); err == nil || !strings.HasPrefix(err.Error(), "BUSYKEY") {
+		t.Errorf("Error, expected BUSYKEY! %v", err)
+	}
+	if _, err := otherConn.Do("RESTORE", "h", 0, data, "REPLACE"); err != nil {
+		t.Errorf("Error, could not replace key! %s", err)
+	}
+}
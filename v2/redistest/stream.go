@@ -0,0 +1,971 @@
+package redistest
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var streamCommands = map[string]command{
+	"XADD":       {f: cmdXAdd, arity: -5, write: true},
+	"XLEN":       {f: cmdXLen, arity: 2},
+	"XRANGE":     {f: cmdXRange, arity: -4},
+	"XREVRANGE":  {f: cmdXRange, arity: -4},
+	"XDEL":       {f: cmdXDel, arity: -3, write: true},
+	"XTRIM":      {f: cmdXTrim, arity: -4, write: true},
+	"XREAD":      {f: cmdXRead, arity: -4},
+	"XGROUP":     {f: cmdXGroup, arity: -2, write: true},
+	"XREADGROUP": {f: cmdXReadGroup, arity: -7, write: true},
+	"XACK":       {f: cmdXAck, arity: -4, write: true},
+	"XPENDING":   {f: cmdXPending, arity: -3},
+	"XCLAIM":     {f: cmdXClaim, arity: -6, write: true},
+	"XAUTOCLAIM": {f: cmdXAutoClaim, arity: -6, write: true},
+}
+
+var (
+	errStreamID   = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errStreamTop  = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamZero = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+)
+
+type streamID struct {
+	ms, seq uint64
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(other streamID) bool {
+	if id.ms != other.ms {
+		return id.ms < other.ms
+	}
+	return id.seq < other.seq
+}
+
+// Parse a stream ID. A missing sequence number is set to missingSeq.
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	var id streamID
+	switch s {
+	case "-":
+		return streamID{0, 0}, nil
+	case "+":
+		return streamID{math.MaxUint64, math.MaxUint64}, nil
+	}
+	msPart, seqPart := s, ""
+	hasSeq := false
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		msPart, seqPart, hasSeq = s[:i], s[i+1:], true
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return id, errStreamID
+	}
+	id.ms = ms
+	id.seq = missingSeq
+	if hasSeq {
+		if id.seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return id, errStreamID
+		}
+	}
+	return id, nil
+}
+
+// Parse a range boundary for XRANGE, which may be exclusive, like "(1-0"
+func parseRangeID(s string, missingSeq uint64) (streamID, error) {
+	if !strings.HasPrefix(s, "(") {
+		return parseStreamID(s, missingSeq)
+	}
+	id, err := parseStreamID(s[1:], missingSeq)
+	if err != nil {
+		return id, err
+	}
+	if missingSeq == 0 {
+		// Exclusive start
+		if id.seq == math.MaxUint64 {
+			return streamID{id.ms + 1, 0}, nil
+		}
+		id.seq++
+		return id, nil
+	}
+	// Exclusive end
+	if id.seq == 0 {
+		return streamID{id.ms - 1, math.MaxUint64}, nil
+	}
+	id.seq--
+	return id, nil
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+type pendingEntry struct {
+	consumer   string
+	delivered  time.Time
+	deliveries int64
+}
+
+type consumerGroup struct {
+	last      streamID
+	pending   map[streamID]*pendingEntry
+	consumers map[string]time.Time
+}
+
+type stream struct {
+	entries []streamEntry
+	last    streamID
+	groups  map[string]*consumerGroup
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*consumerGroup)}
+}
+
+func (st *stream) find(id streamID) (streamEntry, bool) {
+	i := sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(id)
+	})
+	if i < len(st.entries) && st.entries[i].id == id {
+		return st.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// Entries with IDs after the given ID
+func (st *stream) after(id streamID, count int) []streamEntry {
+	i := sort.Search(len(st.entries), func(i int) bool {
+		return id.less(st.entries[i].id)
+	})
+	entries := st.entries[i:]
+	if count > 0 && count < len(entries) {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+func (st *stream) trim(maxLen int) int64 {
+	if maxLen < 0 || len(st.entries) <= maxLen {
+		return 0
+	}
+	removed := len(st.entries) - maxLen
+	st.entries = append([]streamEntry{}, st.entries[removed:]...)
+	return int64(removed)
+}
+
+func (st *stream) trimMinID(minID streamID) int64 {
+	i := sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(minID)
+	})
+	st.entries = append([]streamEntry{}, st.entries[i:]...)
+	return int64(i)
+}
+
+func encodeEntry(e streamEntry) []interface{} {
+	fields := make([]interface{}, len(e.fields))
+	for i, f := range e.fields {
+		fields[i] = f
+	}
+	return []interface{}{e.id.String(), fields}
+}
+
+func encodeEntries(entries []streamEntry) []interface{} {
+	reply := make([]interface{}, len(entries))
+	for i, e := range entries {
+		reply[i] = encodeEntry(e)
+	}
+	return reply
+}
+
+// Parse [MAXLEN|MINID [=|~] threshold [LIMIT count]], returns the number of arguments used
+func parseTrim(args []string) (maxLen int, minID *streamID, used int, err error) {
+	maxLen = -1
+	if len(args) == 0 {
+		return
+	}
+	strategy := strings.ToUpper(args[0])
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return
+	}
+	used = 1
+	if used < len(args) && (args[used] == "=" || args[used] == "~") {
+		used++
+	}
+	if used >= len(args) {
+		return maxLen, nil, used, errSyntax
+	}
+	if strategy == "MAXLEN" {
+		n, perr := strconv.Atoi(args[used])
+		if perr != nil || n < 0 {
+			return maxLen, nil, used, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		maxLen = n
+	} else {
+		id, perr := parseStreamID(args[used], 0)
+		if perr != nil {
+			return maxLen, nil, used, perr
+		}
+		minID = &id
+	}
+	used++
+	if used+1 < len(args) && strings.ToUpper(args[used]) == "LIMIT" {
+		used += 2
+	}
+	return maxLen, minID, used, nil
+}
+
+func cmdXAdd(c *client, args []string) interface{} {
+	key := args[1]
+	rest := args[2:]
+	noMkStream := false
+	if strings.ToUpper(rest[0]) == "NOMKSTREAM" {
+		noMkStream = true
+		rest = rest[1:]
+	}
+	maxLen, minID, used, err := parseTrim(rest)
+	if err != nil {
+		return err
+	}
+	rest = rest[used:]
+	if len(rest) < 3 || len(rest)%2 != 1 {
+		return errArity("xadd")
+	}
+	it, err := c.s.lookupKind(c.db, key, "stream")
+	if err != nil {
+		return err
+	}
+	if it == nil && noMkStream {
+		return nil
+	}
+	var st *stream
+	if it != nil {
+		st = it.stream
+	} else {
+		st = newStream()
+	}
+	var id streamID
+	if rest[0] == "*" {
+		ms := uint64(c.s.now().UnixNano() / int64(time.Millisecond))
+		if ms <= st.last.ms {
+			id = streamID{st.last.ms, st.last.seq + 1}
+		} else {
+			id = streamID{ms, 0}
+		}
+	} else if strings.HasSuffix(rest[0], "-*") {
+		ms, err := strconv.ParseUint(strings.TrimSuffix(rest[0], "-*"), 10, 64)
+		if err != nil {
+			return errStreamID
+		}
+		id = streamID{ms, 0}
+		if ms == st.last.ms {
+			id.seq = st.last.seq + 1
+		}
+	} else {
+		if id, err = parseStreamID(rest[0], 0); err != nil {
+			return err
+		}
+	}
+	if id == (streamID{}) {
+		return errStreamZero
+	}
+	if !st.last.less(id) {
+		return errStreamTop
+	}
+	if it == nil {
+		it, _ = c.s.lookupCreate(c.db, key, "stream")
+		it.stream = st
+	}
+	st.entries = append(st.entries, streamEntry{id: id, fields: append([]string{}, rest[1:]...)})
+	st.last = id
+	c.s.touch(c.db, key)
+	c.s.notify(c.db, 't', "xadd", key)
+	if maxLen >= 0 {
+		if st.trim(maxLen) > 0 {
+			c.s.notify(c.db, 't', "xtrim", key)
+		}
+	} else if minID != nil {
+		if st.trimMinID(*minID) > 0 {
+			c.s.notify(c.db, 't', "xtrim", key)
+		}
+	}
+	return id.String()
+}
+
+func (c *client) lookupStream(key string) (*stream, error) {
+	it, err := c.s.lookupKind(c.db, key, "stream")
+	if err != nil || it == nil {
+		return nil, err
+	}
+	return it.stream, nil
+}
+
+func cmdXLen(c *client, args []string) interface{} {
+	st, err := c.lookupStream(args[1])
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return int64(0)
+	}
+	return int64(len(st.entries))
+}
+
+func cmdXRange(c *client, args []string) interface{} {
+	rev := strings.ToUpper(args[0]) == "XREVRANGE"
+	startArg, endArg := args[2], args[3]
+	if rev {
+		startArg, endArg = args[3], args[2]
+	}
+	start, err := parseRangeID(startArg, 0)
+	if err != nil {
+		return err
+	}
+	end, err := parseRangeID(endArg, math.MaxUint64)
+	if err != nil {
+		return err
+	}
+	count := -1
+	if len(args) == 6 && strings.ToUpper(args[4]) == "COUNT" {
+		if count, err = strconv.Atoi(args[5]); err != nil {
+			return errNotInteger
+		}
+	} else if len(args) != 4 {
+		return errSyntax
+	}
+	st, err := c.lookupStream(args[1])
+	if err != nil {
+		return err
+	}
+	reply := []interface{}{}
+	if st == nil {
+		return reply
+	}
+	var selected []streamEntry
+	for _, e := range st.entries {
+		if !e.id.less(start) && !end.less(e.id) {
+			selected = append(selected, e)
+		}
+	}
+	if rev {
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+	}
+	if count >= 0 && count < len(selected) {
+		selected = selected[:count]
+	}
+	return encodeEntries(selected)
+}
+
+func cmdXDel(c *client, args []string) interface{} {
+	st, err := c.lookupStream(args[1])
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return int64(0)
+	}
+	var removed int64
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		for i, e := range st.entries {
+			if e.id == id {
+				st.entries = append(st.entries[:i:i], st.entries[i+1:]...)
+				removed++
+				break
+			}
+		}
+	}
+	if removed > 0 {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 't', "xdel", args[1])
+	}
+	return removed
+}
+
+func cmdXTrim(c *client, args []string) interface{} {
+	maxLen, minID, used, err := parseTrim(args[2:])
+	if err != nil {
+		return err
+	}
+	if used == 0 || used != len(args)-2 {
+		return errSyntax
+	}
+	st, err := c.lookupStream(args[1])
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return int64(0)
+	}
+	var removed int64
+	if minID != nil {
+		removed = st.trimMinID(*minID)
+	} else {
+		removed = st.trim(maxLen)
+	}
+	if removed > 0 {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 't', "xtrim", args[1])
+	}
+	return removed
+}
+
+// Parse the common options of XREAD and XREADGROUP, until STREAMS
+type readOptions struct {
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	ids     []string
+}
+
+func parseRead(args []string, group bool) (readOptions, error) {
+	var opts readOptions
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return opts, errSyntax
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			opts.count = n
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return opts, errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n < 0 {
+				return opts, errors.New("ERR timeout is not an integer or out of range")
+			}
+			opts.block = true
+			opts.timeout = time.Duration(n) * time.Millisecond
+			i++
+		case "NOACK":
+			if !group {
+				return opts, errSyntax
+			}
+			opts.noAck = true
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return opts, errors.New("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			opts.keys = rest[:len(rest)/2]
+			opts.ids = rest[len(rest)/2:]
+			return opts, nil
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, errSyntax
+}
+
+func (opts readOptions) deadline() time.Time {
+	if !opts.block || opts.timeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(opts.timeout)
+}
+
+func cmdXRead(c *client, args []string) interface{} {
+	opts, err := parseRead(args[1:], false)
+	if err != nil {
+		return err
+	}
+	if opts.block && c.multi {
+		opts.block = false
+	}
+	ids := make([]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		if opts.ids[i] == "$" {
+			st, err := c.lookupStream(key)
+			if err != nil {
+				return err
+			}
+			if st != nil {
+				ids[i] = st.last
+			}
+			continue
+		}
+		if ids[i], err = parseStreamID(opts.ids[i], 0); err != nil {
+			return err
+		}
+	}
+	deadline := opts.deadline()
+	for {
+		reply := []interface{}{}
+		for i, key := range opts.keys {
+			st, err := c.lookupStream(key)
+			if err != nil {
+				return err
+			}
+			if st == nil {
+				continue
+			}
+			if entries := st.after(ids[i], opts.count); len(entries) > 0 {
+				reply = append(reply, []interface{}{key, encodeEntries(entries)})
+			}
+		}
+		if len(reply) > 0 {
+			return reply
+		}
+		if !opts.block || !c.block(deadline) {
+			return nilArray
+		}
+	}
+}
+
+func errNoGroup(key, group string) error {
+	return errors.New("NOGROUP No such key '" + key + "' or consumer group '" + group + "' in XREADGROUP with GROUP option")
+}
+
+func cmdXGroup(c *client, args []string) interface{} {
+	sub := strings.ToUpper(args[1])
+	if sub == "HELP" {
+		return []string{"XGROUP CREATE|DESTROY|CREATECONSUMER|DELCONSUMER|SETID"}
+	}
+	if len(args) < 4 {
+		return errArity("xgroup|" + strings.ToLower(sub))
+	}
+	key, groupName := args[2], args[3]
+	it, err := c.s.lookupKind(c.db, key, "stream")
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "CREATE":
+		if len(args) < 5 {
+			return errArity("xgroup|create")
+		}
+		mkStream := false
+		for _, opt := range args[5:] {
+			if strings.ToUpper(opt) == "MKSTREAM" {
+				mkStream = true
+			}
+		}
+		if it == nil {
+			if !mkStream {
+				return errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+			}
+			it, _ = c.s.lookupCreate(c.db, key, "stream")
+		}
+		st := it.stream
+		if _, exists := st.groups[groupName]; exists {
+			return errors.New("BUSYGROUP Consumer Group name already exists")
+		}
+		last := st.last
+		if args[4] != "$" {
+			if last, err = parseStreamID(args[4], 0); err != nil {
+				return err
+			}
+		}
+		st.groups[groupName] = &consumerGroup{
+			last:      last,
+			pending:   make(map[streamID]*pendingEntry),
+			consumers: make(map[string]time.Time),
+		}
+		c.s.touch(c.db, key)
+		c.s.notify(c.db, 't', "xgroup-create", key)
+		return Status("OK")
+	}
+	if it == nil {
+		return errors.New("ERR The XGROUP subcommand requires the key to exist.")
+	}
+	st := it.stream
+	g, ok := st.groups[groupName]
+	switch sub {
+	case "DESTROY":
+		if !ok {
+			return int64(0)
+		}
+		delete(st.groups, groupName)
+		c.s.touch(c.db, key)
+		return int64(1)
+	}
+	if !ok {
+		return errors.New("NOGROUP No such consumer group '" + groupName + "' for key name '" + key + "'")
+	}
+	switch sub {
+	case "SETID":
+		if len(args) < 5 {
+			return errArity("xgroup|setid")
+		}
+		last := st.last
+		if args[4] != "$" {
+			if last, err = parseStreamID(args[4], 0); err != nil {
+				return err
+			}
+		}
+		g.last = last
+		return Status("OK")
+	case "CREATECONSUMER":
+		if len(args) != 5 {
+			return errArity("xgroup|createconsumer")
+		}
+		if _, exists := g.consumers[args[4]]; exists {
+			return int64(0)
+		}
+		g.consumers[args[4]] = c.s.now()
+		return int64(1)
+	case "DELCONSUMER":
+		if len(args) != 5 {
+			return errArity("xgroup|delconsumer")
+		}
+		var pending int64
+		for id, p := range g.pending {
+			if p.consumer == args[4] {
+				delete(g.pending, id)
+				pending++
+			}
+		}
+		delete(g.consumers, args[4])
+		return pending
+	}
+	return errors.New("ERR unknown subcommand '" + args[1] + "'. Try XGROUP HELP.")
+}
+
+func cmdXReadGroup(c *client, args []string) interface{} {
+	if strings.ToUpper(args[1]) != "GROUP" {
+		return errSyntax
+	}
+	groupName, consumer := args[2], args[3]
+	opts, err := parseRead(args[4:], true)
+	if err != nil {
+		return err
+	}
+	if opts.block && c.multi {
+		opts.block = false
+	}
+	deadline := opts.deadline()
+	for {
+		reply := []interface{}{}
+		for i, key := range opts.keys {
+			st, err := c.lookupStream(key)
+			if err != nil {
+				return err
+			}
+			if st == nil || st.groups[groupName] == nil {
+				return errNoGroup(key, groupName)
+			}
+			g := st.groups[groupName]
+			g.consumers[consumer] = c.s.now()
+			if opts.ids[i] != ">" {
+				// Read the pending entries of this consumer
+				start, err := parseStreamID(opts.ids[i], 0)
+				if err != nil {
+					return err
+				}
+				var ids []streamID
+				for id, p := range g.pending {
+					if p.consumer == consumer && start.less(id) {
+						ids = append(ids, id)
+					}
+				}
+				sort.Slice(ids, func(a, b int) bool { return ids[a].less(ids[b]) })
+				if opts.count > 0 && opts.count < len(ids) {
+					ids = ids[:opts.count]
+				}
+				history := make([]interface{}, 0, len(ids))
+				for _, id := range ids {
+					if e, ok := st.find(id); ok {
+						history = append(history, encodeEntry(e))
+					} else {
+						history = append(history, []interface{}{id.String(), nil})
+					}
+				}
+				reply = append(reply, []interface{}{key, history})
+				continue
+			}
+			entries := st.after(g.last, opts.count)
+			if len(entries) == 0 {
+				continue
+			}
+			now := c.s.now()
+			for _, e := range entries {
+				if !opts.noAck {
+					g.pending[e.id] = &pendingEntry{consumer: consumer, delivered: now, deliveries: 1}
+				}
+			}
+			g.last = entries[len(entries)-1].id
+			c.s.touch(c.db, key)
+			reply = append(reply, []interface{}{key, encodeEntries(entries)})
+		}
+		if len(reply) > 0 {
+			return reply
+		}
+		if !opts.block || !c.block(deadline) {
+			return nilArray
+		}
+	}
+}
+
+func (c *client) lookupGroup(key, groupName string) (*stream, *consumerGroup, error) {
+	st, err := c.lookupStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st == nil || st.groups[groupName] == nil {
+		return st, nil, nil
+	}
+	return st, st.groups[groupName], nil
+}
+
+func cmdXAck(c *client, args []string) interface{} {
+	_, g, err := c.lookupGroup(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	var acked int64
+	for _, arg := range args[3:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		if g == nil {
+			continue
+		}
+		if _, ok := g.pending[id]; ok {
+			delete(g.pending, id)
+			acked++
+		}
+	}
+	return acked
+}
+
+func (g *consumerGroup) sortedPending() []streamID {
+	ids := make([]streamID, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a].less(ids[b]) })
+	return ids
+}
+
+func cmdXPending(c *client, args []string) interface{} {
+	_, g, err := c.lookupGroup(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	if g == nil {
+		return errors.New("NOGROUP No such key '" + args[1] + "' or consumer group '" + args[2] + "'")
+	}
+	ids := g.sortedPending()
+	if len(args) == 3 {
+		// Summary form
+		if len(ids) == 0 {
+			return []interface{}{int64(0), nil, nil, nilArray}
+		}
+		counts := make(map[string]int64)
+		for _, id := range ids {
+			counts[g.pending[id].consumer]++
+		}
+		var consumers []string
+		for consumer := range counts {
+			consumers = append(consumers, consumer)
+		}
+		sort.Strings(consumers)
+		perConsumer := make([]interface{}, 0, len(consumers))
+		for _, consumer := range consumers {
+			perConsumer = append(perConsumer, []interface{}{consumer, strconv.FormatInt(counts[consumer], 10)})
+		}
+		return []interface{}{int64(len(ids)), ids[0].String(), ids[len(ids)-1].String(), perConsumer}
+	}
+	// Extended form: [IDLE min-idle-time] start end count [consumer]
+	rest := args[3:]
+	var minIdle time.Duration
+	if strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			return errSyntax
+		}
+		n, err := parseInt(rest[1])
+		if err != nil {
+			return err
+		}
+		minIdle = time.Duration(n) * time.Millisecond
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return errSyntax
+	}
+	start, err := parseRangeID(rest[0], 0)
+	if err != nil {
+		return err
+	}
+	end, err := parseRangeID(rest[1], math.MaxUint64)
+	if err != nil {
+		return err
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		return errNotInteger
+	}
+	consumer := ""
+	if len(rest) == 4 {
+		consumer = rest[3]
+	}
+	now := c.s.now()
+	reply := []interface{}{}
+	for _, id := range ids {
+		if len(reply) >= count {
+			break
+		}
+		p := g.pending[id]
+		idle := now.Sub(p.delivered)
+		if id.less(start) || end.less(id) || (consumer != "" && p.consumer != consumer) || idle < minIdle {
+			continue
+		}
+		reply = append(reply, []interface{}{id.String(), p.consumer, int64(idle / time.Millisecond), p.deliveries})
+	}
+	return reply
+}
+
+// Claim a pending entry for a consumer, returns false if the entry was deleted
+func (c *client) claim(st *stream, g *consumerGroup, id streamID, consumer string, justID bool) (streamEntry, bool) {
+	e, ok := st.find(id)
+	if !ok {
+		delete(g.pending, id)
+		return e, false
+	}
+	p := g.pending[id]
+	p.consumer = consumer
+	p.delivered = c.s.now()
+	if !justID {
+		p.deliveries++
+	}
+	g.consumers[consumer] = c.s.now()
+	return e, true
+}
+
+func cmdXClaim(c *client, args []string) interface{} {
+	st, g, err := c.lookupGroup(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	if g == nil {
+		return errNoGroup(args[1], args[2])
+	}
+	consumer := args[3]
+	n, err := parseInt(args[4])
+	if err != nil {
+		return err
+	}
+	minIdle := time.Duration(n) * time.Millisecond
+	var ids []streamID
+	justID := false
+	i := 5
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "JUSTID":
+			justID = true
+		case "FORCE":
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	now := c.s.now()
+	reply := []interface{}{}
+	for _, id := range ids {
+		p, ok := g.pending[id]
+		if !ok || now.Sub(p.delivered) < minIdle {
+			continue
+		}
+		e, ok := c.claim(st, g, id, consumer, justID)
+		if !ok {
+			continue
+		}
+		if justID {
+			reply = append(reply, id.String())
+		} else {
+			reply = append(reply, encodeEntry(e))
+		}
+	}
+	c.s.touch(c.db, args[1])
+	return reply
+}
+
+func cmdXAutoClaim(c *client, args []string) interface{} {
+	st, g, err := c.lookupGroup(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	if g == nil {
+		return errNoGroup(args[1], args[2])
+	}
+	consumer := args[3]
+	n, err := parseInt(args[4])
+	if err != nil {
+		return err
+	}
+	minIdle := time.Duration(n) * time.Millisecond
+	start, err := parseStreamID(args[5], 0)
+	if err != nil {
+		return err
+	}
+	count, justID := 100, false
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			if count, err = strconv.Atoi(args[i+1]); err != nil {
+				return errNotInteger
+			}
+			i++
+		case "JUSTID":
+			justID = true
+		default:
+			return errSyntax
+		}
+	}
+	now := c.s.now()
+	claimed := []interface{}{}
+	deleted := []interface{}{}
+	next := "0-0"
+	for _, id := range g.sortedPending() {
+		if id.less(start) {
+			continue
+		}
+		if len(claimed)+len(deleted) >= count {
+			next = id.String()
+			break
+		}
+		if now.Sub(g.pending[id].delivered) < minIdle {
+			continue
+		}
+		e, ok := c.claim(st, g, id, consumer, justID)
+		if !ok {
+			deleted = append(deleted, id.String())
+			continue
+		}
+		if justID {
+			claimed = append(claimed, id.String())
+		} else {
+			claimed = append(claimed, encodeEntry(e))
+		}
+	}
+	c.s.touch(c.db, args[1])
+	return []interface{}{next, claimed, deleted}
+}
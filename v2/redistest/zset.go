@@ -0,0 +1,537 @@
+package redistest
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var zsetCommands = map[string]command{
+	"ZADD":             {f: cmdZAdd, arity: -4, write: true},
+	"ZINCRBY":          {f: cmdZIncrBy, arity: 4, write: true},
+	"ZSCORE":           {f: cmdZScore, arity: 3},
+	"ZMSCORE":          {f: cmdZMScore, arity: -3},
+	"ZRANK":            {f: cmdZRank, arity: -3},
+	"ZREVRANK":         {f: cmdZRank, arity: -3},
+	"ZCARD":            {f: cmdZCard, arity: 2},
+	"ZCOUNT":           {f: cmdZCount, arity: 4},
+	"ZRANGE":           {f: cmdZRange, arity: -4},
+	"ZREVRANGE":        {f: cmdZRange, arity: -4},
+	"ZRANGEBYSCORE":    {f: cmdZRange, arity: -4},
+	"ZREVRANGEBYSCORE": {f: cmdZRange, arity: -4},
+	"ZREM":             {f: cmdZRem, arity: -3, write: true},
+	"ZREMRANGEBYSCORE": {f: cmdZRemRangeByScore, arity: 4, write: true},
+	"ZREMRANGEBYRANK":  {f: cmdZRemRangeByRank, arity: 4, write: true},
+	"ZPOPMIN":          {f: cmdZPop, arity: -2, write: true},
+	"ZPOPMAX":          {f: cmdZPop, arity: -2, write: true},
+	"ZSCAN":            {f: cmdZScan, arity: -3},
+}
+
+type scored struct {
+	member string
+	score  float64
+}
+
+// The members of a sorted set, ordered by score and then member
+func sortedScores(zset map[string]float64) []scored {
+	members := make([]scored, 0, len(zset))
+	for member, score := range zset {
+		members = append(members, scored{member, score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score != members[j].score {
+			return members[i].score < members[j].score
+		}
+		return members[i].member < members[j].member
+	})
+	return members
+}
+
+// A score boundary, like "1.5", "(1.5", "-inf" or "+inf"
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseBound(s string) (scoreBound, error) {
+	var b scoreBound
+	if strings.HasPrefix(s, "(") {
+		b.exclusive = true
+		s = s[1:]
+	}
+	f, err := parseFloat(s)
+	if err != nil {
+		return b, errors.New("ERR min or max is not a float")
+	}
+	b.value = f
+	return b, nil
+}
+
+func (b scoreBound) below(score float64) bool {
+	if b.exclusive {
+		return b.value < score
+	}
+	return b.value <= score
+}
+
+func (b scoreBound) above(score float64) bool {
+	if b.exclusive {
+		return b.value > score
+	}
+	return b.value >= score
+}
+
+func cmdZAdd(c *client, args []string) interface{} {
+	var nx, xx, gt, lt, ch, incr bool
+	i := 2
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break loop
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 || (nx && xx) || (incr && len(pairs) != 2) || (nx && (gt || lt)) || (gt && lt) {
+		return errSyntax
+	}
+	scores := make([]float64, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		f, err := parseFloat(pairs[j])
+		if err != nil {
+			return err
+		}
+		scores = append(scores, f)
+	}
+	it, err := c.s.lookupCreate(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	var changed, added int64
+	var result interface{}
+	for j := 0; j < len(pairs); j += 2 {
+		member, score := pairs[j+1], scores[j/2]
+		old, exists := it.zset[member]
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if incr {
+			score += old
+		}
+		if exists && ((gt && score <= old) || (lt && score >= old)) {
+			continue
+		}
+		it.zset[member] = score
+		result = score
+		if !exists {
+			added++
+			changed++
+		} else if old != score {
+			changed++
+		}
+	}
+	c.s.touch(c.db, args[1])
+	c.s.removeIfEmpty(c.db, args[1])
+	if changed > 0 {
+		if incr {
+			c.s.notify(c.db, 'z', "zincr", args[1])
+		} else {
+			c.s.notify(c.db, 'z', "zadd", args[1])
+		}
+	}
+	if incr {
+		return result
+	}
+	if ch {
+		return changed
+	}
+	return added
+}
+
+func cmdZIncrBy(c *client, args []string) interface{} {
+	delta, err := parseFloat(args[2])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupCreate(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	it.zset[args[3]] += delta
+	c.s.touch(c.db, args[1])
+	c.s.notify(c.db, 'z', "zincr", args[1])
+	return it.zset[args[3]]
+}
+
+func cmdZScore(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return nil
+	}
+	score, ok := it.zset[args[2]]
+	if !ok {
+		return nil
+	}
+	return score
+}
+
+func cmdZMScore(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	reply := make([]interface{}, 0, len(args)-2)
+	for _, member := range args[2:] {
+		if it == nil {
+			reply = append(reply, nil)
+			continue
+		}
+		if score, ok := it.zset[member]; ok {
+			reply = append(reply, score)
+		} else {
+			reply = append(reply, nil)
+		}
+	}
+	return reply
+}
+
+func cmdZRank(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	withScore := len(args) == 4 && strings.ToUpper(args[3]) == "WITHSCORE"
+	if len(args) > 3 && !withScore {
+		return errSyntax
+	}
+	if it == nil {
+		return nil
+	}
+	members := sortedScores(it.zset)
+	for i, m := range members {
+		if m.member != args[2] {
+			continue
+		}
+		rank := int64(i)
+		if strings.ToUpper(args[0]) == "ZREVRANK" {
+			rank = int64(len(members) - 1 - i)
+		}
+		if withScore {
+			return []interface{}{rank, m.score}
+		}
+		return rank
+	}
+	if withScore {
+		return nilArray
+	}
+	return nil
+}
+
+func cmdZCard(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	return int64(len(it.zset))
+}
+
+func cmdZCount(c *client, args []string) interface{} {
+	min, err := parseBound(args[2])
+	if err != nil {
+		return err
+	}
+	max, err := parseBound(args[3])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var n int64
+	for _, score := range it.zset {
+		if min.below(score) && max.above(score) {
+			n++
+		}
+	}
+	return n
+}
+
+// ZRANGE, ZREVRANGE, ZRANGEBYSCORE and ZREVRANGEBYSCORE
+func cmdZRange(c *client, args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	byScore := name == "ZRANGEBYSCORE" || name == "ZREVRANGEBYSCORE"
+	rev := name == "ZREVRANGE" || name == "ZREVRANGEBYSCORE"
+	var (
+		withScores, limit bool
+		offset, count     int64 = 0, -1
+	)
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHSCORES":
+			withScores = true
+		case "BYSCORE":
+			if name != "ZRANGE" {
+				return errSyntax
+			}
+			byScore = true
+		case "REV":
+			if name != "ZRANGE" {
+				return errSyntax
+			}
+			rev = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return errSyntax
+			}
+			var err error
+			if offset, err = parseInt(args[i+1]); err != nil {
+				return err
+			}
+			if count, err = parseInt(args[i+2]); err != nil {
+				return err
+			}
+			limit = true
+			i += 2
+		default:
+			return errSyntax
+		}
+	}
+	if limit && !byScore {
+		return errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	members := []scored{}
+	if it != nil {
+		members = sortedScores(it.zset)
+	}
+	if rev {
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	}
+	var selected []scored
+	if byScore {
+		// For reversed ranges, the max score comes first
+		minArg, maxArg := args[2], args[3]
+		if rev {
+			minArg, maxArg = args[3], args[2]
+		}
+		min, err := parseBound(minArg)
+		if err != nil {
+			return err
+		}
+		max, err := parseBound(maxArg)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if min.below(m.score) && max.above(m.score) {
+				selected = append(selected, m)
+			}
+		}
+		if offset < 0 {
+			selected = nil
+		} else if offset >= int64(len(selected)) {
+			selected = nil
+		} else {
+			selected = selected[offset:]
+		}
+		if count >= 0 && count < int64(len(selected)) {
+			selected = selected[:count]
+		}
+	} else {
+		start, err := parseInt(args[2])
+		if err != nil {
+			return err
+		}
+		stop, err := parseInt(args[3])
+		if err != nil {
+			return err
+		}
+		from, to := listRange(len(members), start, stop)
+		selected = members[from:to]
+	}
+	reply := make([]interface{}, 0, len(selected)*2)
+	for _, m := range selected {
+		reply = append(reply, m.member)
+		if withScores {
+			reply = append(reply, m.score)
+		}
+	}
+	return reply
+}
+
+func cmdZRem(c *client, args []string) interface{} {
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var removed int64
+	for _, member := range args[2:] {
+		if _, ok := it.zset[member]; ok {
+			delete(it.zset, member)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 'z', "zrem", args[1])
+		c.s.removeIfEmpty(c.db, args[1])
+	}
+	return removed
+}
+
+func cmdZRemRangeByScore(c *client, args []string) interface{} {
+	min, err := parseBound(args[2])
+	if err != nil {
+		return err
+	}
+	max, err := parseBound(args[3])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	var removed int64
+	for member, score := range it.zset {
+		if min.below(score) && max.above(score) {
+			delete(it.zset, member)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 'z', "zremrangebyscore", args[1])
+		c.s.removeIfEmpty(c.db, args[1])
+	}
+	return removed
+}
+
+func cmdZRemRangeByRank(c *client, args []string) interface{} {
+	start, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	stop, err := parseInt(args[3])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return int64(0)
+	}
+	members := sortedScores(it.zset)
+	from, to := listRange(len(members), start, stop)
+	for _, m := range members[from:to] {
+		delete(it.zset, m.member)
+	}
+	if to > from {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 'z', "zremrangebyrank", args[1])
+		c.s.removeIfEmpty(c.db, args[1])
+	}
+	return int64(to - from)
+}
+
+func cmdZPop(c *client, args []string) interface{} {
+	count := 1
+	if len(args) > 3 {
+		return errSyntax
+	}
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return errors.New("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	reply := []interface{}{}
+	if it == nil {
+		return reply
+	}
+	members := sortedScores(it.zset)
+	if strings.ToUpper(args[0]) == "ZPOPMAX" {
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	}
+	if count > len(members) {
+		count = len(members)
+	}
+	for _, m := range members[:count] {
+		delete(it.zset, m.member)
+		reply = append(reply, m.member, m.score)
+	}
+	if count > 0 {
+		c.s.touch(c.db, args[1])
+		c.s.notify(c.db, 'z', strings.ToLower(args[0]), args[1])
+		c.s.removeIfEmpty(c.db, args[1])
+	}
+	return reply
+}
+
+func cmdZScan(c *client, args []string) interface{} {
+	opts, err := parseScan(args[2:])
+	if err != nil {
+		return err
+	}
+	it, err := c.s.lookupKind(c.db, args[1], "zset")
+	if err != nil {
+		return err
+	}
+	if it == nil {
+		return []interface{}{"0", []string{}}
+	}
+	members := sortedScores(it.zset)
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.member
+	}
+	cursor, found := scanPage(names, opts, nil)
+	reply := make([]interface{}, 0, len(found)*2)
+	for _, member := range found {
+		reply = append(reply, member, it.zset[member])
+	}
+	return []interface{}{cursor, reply}
+}
@@ -0,0 +1,541 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ReadPolicy decides which replica a read-only command is sent to
+type ReadPolicy int
+
+const (
+	// ReadRoundRobin sends read-only commands to each replica in turn
+	ReadRoundRobin ReadPolicy = iota
+
+	// ReadLowestLatency sends read-only commands to the replica with the
+	// lowest measured round trip time
+	ReadLowestLatency
+)
+
+var (
+	// How long a replica is skipped after it could not be reached
+	replicaRetryDelay = 5 * time.Second
+
+	// How often each replica is used for measuring its round trip time,
+	// even if it is not the fastest one, for ReadLowestLatency
+	replicaProbeInterval = time.Second
+)
+
+// Commands that only read data, and that can be sent to a replica
+var readOnlyCommands = map[string]bool{
+	"GET": true, "MGET": true, "STRLEN": true, "GETRANGE": true, "GETBIT": true,
+	"BITCOUNT": true, "EXISTS": true, "TYPE": true, "TTL": true, "PTTL": true,
+	"EXPIRETIME": true, "PEXPIRETIME": true, "SCAN": true, "KEYS": true,
+	"RANDOMKEY": true, "DBSIZE": true,
+	"LRANGE": true, "LLEN": true, "LINDEX": true, "LPOS": true,
+	"SMEMBERS": true, "SISMEMBER": true, "SMISMEMBER": true, "SCARD": true,
+	"SRANDMEMBER": true, "SSCAN": true, "SINTER": true, "SUNION": true, "SDIFF": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "HKEYS": true, "HVALS": true,
+	"HLEN": true, "HEXISTS": true, "HSTRLEN": true, "HSCAN": true, "HRANDFIELD": true,
+	"HTTL": true, "HPTTL": true, "HEXPIRETIME": true, "HPEXPIRETIME": true,
+	"ZRANGE": true, "ZRANGEBYSCORE": true, "ZRANGEBYLEX": true, "ZREVRANGE": true,
+	"ZREVRANGEBYSCORE": true, "ZREVRANGEBYLEX": true, "ZSCORE": true, "ZMSCORE": true,
+	"ZCARD": true, "ZCOUNT": true, "ZLEXCOUNT": true, "ZRANK": true, "ZREVRANK": true,
+	"ZSCAN": true, "ZRANDMEMBER": true,
+	"XRANGE": true, "XREVRANGE": true, "XLEN": true, "XREAD": true,
+	"EVAL_RO": true, "EVALSHA_RO": true,
+}
+
+// Commands that neither read nor write data, and that are sent to the primary
+var connectionCommands = map[string]bool{
+	"": true, "PING": true, "ECHO": true, "SELECT": true, "AUTH": true,
+	"HELLO": true, "QUIT": true, "RESET": true, "CLIENT": true, "INFO": true,
+	"ROLE": true, "TIME": true, "COMMAND": true, "CONFIG": true, "SCRIPT": true,
+	"WATCH": true, "UNWATCH": true, "MULTI": true, "DISCARD": true,
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "UNSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+}
+
+// A primary server and its replicas, shared by all connections of a pool
+type replicaSet struct {
+	mut      sync.Mutex
+	primary  *connectionSettings
+	replicas []*replica
+	policy   ReadPolicy
+	window   time.Duration
+	// The next replica for ReadRoundRobin
+	next int
+	// The time of the last write, in Unix nanoseconds
+	lastWrite int64
+}
+
+// A replica, and what is known about it
+type replica struct {
+	settings *connectionSettings
+	// The average round trip time, or 0 if it is not known
+	latency time.Duration
+	// When the replica was last chosen for measuring the round trip time
+	measured time.Time
+	// The replica is skipped until this time, after it could not be reached
+	downUntil time.Time
+}
+
+// A connection to a primary server and its replicas. Read-only commands
+// are sent to a replica, and all other commands to the primary. Within
+// transactions, when subscribed and after READWRITE, all commands are sent
+// to the primary.
+type replicaConn struct {
+	set     *replicaSet
+	primary redis.Conn
+	// Connections to the replicas, by index, which are connected to when needed
+	replicas map[int]redis.Conn
+	// The selected database, and the database that is selected on each replica
+	db        int
+	replicaDB map[int]int
+	// Replies that Receive should return, for commands that were sent with Send
+	pending []replicaReply
+	// WATCH or MULTI has been sent, and EXEC, DISCARD or UNWATCH has not
+	transaction bool
+	subscribed  bool
+	// All commands are sent to the primary, after READWRITE and until READONLY
+	pinned bool
+	// Where the current SCAN, SSCAN, HSCAN or ZSCAN iteration is done, since
+	// the cursors are only valid for one server
+	scan int
+}
+
+// A reply that is expected from a connection
+type replicaReply struct {
+	conn redis.Conn
+	// The number of replies to discard first, like for a SELECT
+	skip int
+	// The reply was given without sending the command
+	local bool
+	reply interface{}
+}
+
+// Create a replica set, given the settings for the primary
+func newReplicaSet(cu *connectionSettings) *replicaSet {
+	rs := &replicaSet{
+		primary: cu,
+		policy:  cu.readPolicy,
+		window:  cu.readYourWrites,
+	}
+	for _, addr := range cu.replicas {
+		settings := *cu
+		settings.network, settings.address = networkAndAddress(addr)
+		settings.replicas = nil
+		rs.replicas = append(rs.replicas, &replica{settings: &settings})
+	}
+	return rs
+}
+
+// Connect to the primary. The replicas are connected to when needed.
+func (rs *replicaSet) dialContext(ctx context.Context) (redis.Conn, error) {
+	primary, err := rs.primary.dialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &replicaConn{
+		set:       rs,
+		primary:   primary,
+		replicas:  make(map[int]redis.Conn),
+		db:        rs.primary.dbindex,
+		replicaDB: make(map[int]int),
+		scan:      -1,
+	}, nil
+}
+
+// Note that data has been written to the primary
+func (rs *replicaSet) wrote() {
+	if rs.window > 0 {
+		atomic.StoreInt64(&rs.lastWrite, time.Now().UnixNano())
+	}
+}
+
+// Choose the replica for a read-only command, or -1 for the primary
+func (rs *replicaSet) choose() int {
+	if rs.window > 0 && time.Since(time.Unix(0, atomic.LoadInt64(&rs.lastWrite))) < rs.window {
+		return -1
+	}
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+	now := time.Now()
+	best := -1
+	for i := range rs.replicas {
+		j := (rs.next + i) % len(rs.replicas)
+		r := rs.replicas[j]
+		if now.Before(r.downUntil) {
+			continue
+		}
+		if rs.policy == ReadRoundRobin {
+			rs.next = j + 1
+			return j
+		}
+		if now.Sub(r.measured) >= replicaProbeInterval {
+			// Measure the round trip time again
+			r.measured = now
+			return j
+		}
+		if best == -1 || r.latency < rs.replicas[best].latency {
+			best = j
+		}
+	}
+	return best
+}
+
+// Update the average round trip time of a replica
+func (rs *replicaSet) measure(i int, d time.Duration) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+	r := rs.replicas[i]
+	if r.latency == 0 {
+		r.latency = d
+	} else {
+		r.latency += (d - r.latency) / 4
+	}
+	r.measured = time.Now()
+}
+
+// Skip a replica for a while, since it could not be reached
+func (rs *replicaSet) markDown(i int) {
+	rs.mut.Lock()
+	rs.replicas[i].downUntil = time.Now().Add(replicaRetryDelay)
+	rs.mut.Unlock()
+}
+
+/* --- Replica connection functions --- */
+
+// The cursor of a SCAN, SSCAN, HSCAN or ZSCAN command, if it is one
+func scanCursor(command string, args []interface{}) (string, bool) {
+	switch command {
+	case "SCAN":
+		if len(args) > 0 {
+			return argString(args[0]), true
+		}
+	case "SSCAN", "HSCAN", "ZSCAN":
+		if len(args) > 1 {
+			return argString(args[1]), true
+		}
+	}
+	return "", false
+}
+
+// Decide where a command is sent, as the index of a replica or -1 for the primary
+func (c *replicaConn) route(command string, args []interface{}) int {
+	switch command {
+	case "WATCH", "MULTI":
+		c.transaction = true
+	case "EXEC", "DISCARD", "UNWATCH":
+		c.transaction = false
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		c.subscribed = true
+	case "SELECT":
+		if len(args) > 0 {
+			if db, err := strconv.Atoi(argString(args[0])); err == nil {
+				c.db = db
+			}
+		}
+	}
+	if !readOnlyCommands[command] {
+		if !connectionCommands[command] {
+			c.set.wrote()
+		}
+		return -1
+	}
+	if c.transaction || c.subscribed || c.pinned {
+		return -1
+	}
+	if cursor, ok := scanCursor(command, args); ok && cursor != "0" {
+		// Continue the iteration on the same server
+		return c.scan
+	}
+	return c.set.choose()
+}
+
+// Close the connection to a replica that has failed
+func (c *replicaConn) drop(i int) {
+	if conn, ok := c.replicas[i]; ok {
+		conn.Close()
+		delete(c.replicas, i)
+		delete(c.replicaDB, i)
+	}
+}
+
+// Get the connection to a replica, and connect if needed. The same database
+// as for the primary is selected. Returns the number of replies to discard
+// before the reply to the next command.
+func (c *replicaConn) replica(ctx context.Context, i int) (redis.Conn, int, error) {
+	conn, ok := c.replicas[i]
+	if !ok {
+		var err error
+		if conn, err = c.set.replicas[i].settings.dialContext(ctx); err != nil {
+			return nil, 0, err
+		}
+		c.replicas[i] = conn
+		c.replicaDB[i] = c.set.replicas[i].settings.dbindex
+	}
+	if c.replicaDB[i] == c.db {
+		return conn, 0, nil
+	}
+	if err := conn.Send("SELECT", c.db); err != nil {
+		return nil, 0, err
+	}
+	c.replicaDB[i] = c.db
+	return conn, 1, nil
+}
+
+// Find the connection that a command should be sent to. If the chosen
+// replica can not be reached, the primary is used instead.
+func (c *replicaConn) target(ctx context.Context, command string, args []interface{}) (int, redis.Conn, int) {
+	i := c.route(command, args)
+	conn, skip := c.primary, 0
+	if i >= 0 {
+		var err error
+		if conn, skip, err = c.replica(ctx, i); err != nil {
+			c.set.markDown(i)
+			c.drop(i)
+			i, conn, skip = -1, c.primary, 0
+		}
+	}
+	if cursor, ok := scanCursor(command, args); ok && cursor == "0" {
+		// The iteration is continued on the same server
+		c.scan = i
+	}
+	return i, conn, skip
+}
+
+// Handle READWRITE and READONLY without sending them. After READWRITE, all
+// commands are sent to the primary, like when keys that are found with SCAN
+// are deleted, until READONLY is given.
+func (c *replicaConn) local(command string) (interface{}, bool) {
+	switch command {
+	case "READWRITE":
+		c.pinned = true
+	case "READONLY":
+		c.pinned = false
+	default:
+		return nil, false
+	}
+	return "OK", true
+}
+
+// Send a command and return the reply, or receive all pending replies
+func (c *replicaConn) do(io connIO, command string, args []interface{}) (interface{}, error) {
+	if command == "" || len(c.pending) > 0 {
+		return c.doPending(io, command, args)
+	}
+	if reply, ok := c.local(command); ok {
+		return reply, nil
+	}
+	i, conn, _ := c.target(io.ctx, command, args)
+	start := time.Now()
+	reply, err := io.do(conn, command, args...)
+	if i < 0 {
+		return reply, err
+	}
+	if _, ok := err.(redis.Error); err != nil && !ok {
+		// The replica has failed, so read from the primary instead
+		c.set.markDown(i)
+		c.drop(i)
+		return io.do(c.primary, command, args...)
+	}
+	c.set.measure(i, time.Since(start))
+	return reply, err
+}
+
+// Send a command, if given, and receive all pending replies. Like for a
+// single connection, the last reply and the first error is returned, or a
+// slice of all replies if no command is given.
+func (c *replicaConn) doPending(io connIO, command string, args []interface{}) (interface{}, error) {
+	if command != "" {
+		if err := c.Send(command, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, 0, len(c.pending))
+	var firstErr error
+	for len(c.pending) > 0 {
+		reply, err := c.receivePending(io)
+		if err != nil {
+			replyErr, ok := err.(redis.Error)
+			if !ok {
+				return nil, err
+			}
+			reply = replyErr
+			if firstErr == nil {
+				firstErr = replyErr
+			}
+		}
+		replies = append(replies, reply)
+	}
+	if command == "" {
+		return replies, nil
+	}
+	if len(replies) == 0 {
+		return nil, firstErr
+	}
+	return replies[len(replies)-1], firstErr
+}
+
+// Receive the next pending reply from its connection
+func (c *replicaConn) receivePending(io connIO) (interface{}, error) {
+	p := c.pending[0]
+	c.pending = c.pending[1:]
+	if p.local {
+		return p.reply, nil
+	}
+	for ; p.skip > 0; p.skip-- {
+		if _, err := io.receive(p.conn); err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				return nil, err
+			}
+		}
+	}
+	return io.receive(p.conn)
+}
+
+// Receive a reply, which is either pending, or a message when subscribed
+func (c *replicaConn) receive(io connIO) (interface{}, error) {
+	if len(c.pending) > 0 {
+		return c.receivePending(io)
+	}
+	reply, err := io.receive(c.primary)
+	// Leave subscribe mode when there are no more subscriptions
+	if values, ok := reply.([]interface{}); ok && len(values) == 3 {
+		kind, _ := redis.String(values[0], nil)
+		if count, ok := values[2].(int64); ok && count == 0 && (kind == "unsubscribe" || kind == "punsubscribe") {
+			c.subscribed = false
+		}
+	}
+	return reply, err
+}
+
+func (c *replicaConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return c.do(plainIO, strings.ToUpper(command), args)
+}
+
+func (c *replicaConn) DoContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	return c.do(contextIO(ctx), strings.ToUpper(command), args)
+}
+
+func (c *replicaConn) DoWithTimeout(timeout time.Duration, command string, args ...interface{}) (interface{}, error) {
+	return c.do(timeoutIO(timeout), strings.ToUpper(command), args)
+}
+
+func (c *replicaConn) Send(command string, args ...interface{}) error {
+	command = strings.ToUpper(command)
+	if reply, ok := c.local(command); ok {
+		c.pending = append(c.pending, replicaReply{local: true, reply: reply})
+		return nil
+	}
+	_, conn, skip := c.target(context.Background(), command, args)
+	if err := conn.Send(command, args...); err != nil {
+		return err
+	}
+	switch command {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		// The replies are received as messages
+		return nil
+	}
+	if c.subscribed {
+		return nil
+	}
+	c.pending = append(c.pending, replicaReply{conn: conn, skip: skip})
+	return nil
+}
+
+func (c *replicaConn) Flush() error {
+	if err := c.primary.Flush(); err != nil {
+		return err
+	}
+	for _, conn := range c.replicas {
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *replicaConn) Receive() (interface{}, error) {
+	return c.receive(plainIO)
+}
+
+func (c *replicaConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return c.receive(contextIO(ctx))
+}
+
+func (c *replicaConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.receive(timeoutIO(timeout))
+}
+
+// Err returns an error if the connection to the primary or to any of the
+// replicas has failed
+func (c *replicaConn) Err() error {
+	if err := c.primary.Err(); err != nil {
+		return err
+	}
+	for _, conn := range c.replicas {
+		if err := conn.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *replicaConn) Close() error {
+	err := c.primary.Close()
+	for i := range c.replicas {
+		c.drop(i)
+	}
+	return err
+}
+
+/* --- Replica pool functions --- */
+
+// A connection where all commands are sent to the primary, until it is
+// returned to the pool
+type primaryConn struct {
+	redis.Conn
+}
+
+// Close allows read-only commands to be sent to the replicas again, and
+// returns the connection to the pool
+func (c *primaryConn) Close() error {
+	// The reply is received when the connection is closed
+	c.Conn.Send("READONLY")
+	return c.Conn.Close()
+}
+
+// Get a connection from the pool, given a context and a database index,
+// where also the read-only commands are sent to the primary. This is used
+// when keys are found with SCAN and then deleted, since a replica may not
+// have all keys yet.
+func (pool *ConnectionPool) getPrimary(ctx context.Context, dbindex int) redis.Conn {
+	conn := pool.get(ctx, dbindex)
+	if pool.state().replicas == nil {
+		return conn
+	}
+	if _, err := conn.Do("READWRITE"); err != nil {
+		return conn
+	}
+	return &primaryConn{conn}
+}
+
+// Check that the given read policy is known
+func (p ReadPolicy) valid() error {
+	switch p {
+	case ReadRoundRobin, ReadLowestLatency:
+		return nil
+	}
+	return errors.New("unknown read policy: " + strconv.Itoa(int(p)))
+}
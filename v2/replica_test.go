@@ -0,0 +1,242 @@
+package simpleredis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+// Start a primary and two replicas, where the key "a" has a different
+// value on each server
+func replicaServers(t *testing.T) (*redistest.Server, []*redistest.Server) {
+	primary := redistest.NewServer()
+	replicas := []*redistest.Server{redistest.NewServer(), redistest.NewServer()}
+	for i, s := range append([]*redistest.Server{primary}, replicas...) {
+		conn, err := redis.Dial("tcp", s.Addr)
+		if err != nil {
+			t.Fatalf("Error, could not connect! %s", err)
+		}
+		conn.Do("SET", "a", []string{"primary", "replica1", "replica2"}[i])
+		conn.Do("SELECT", 1)
+		conn.Do("SET", "a", "db1")
+		conn.Close()
+	}
+	return primary, replicas
+}
+
+func TestReplicaRouting(t *testing.T) {
+	primary, replicas := replicaServers(t)
+	defer primary.Close()
+	defer replicas[0].Close()
+	defer replicas[1].Close()
+	pool, err := NewConnectionPoolOptions(primary.Addr, &PoolOptions{
+		Replicas: []string{replicas[0].Addr, replicas[1].Addr},
+	})
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+
+	conn := pool.Get(0)
+	defer conn.Close()
+	// Reads go to the replicas in turn
+	for i := 0; i < 4; i++ {
+		want := []string{"replica1", "replica2"}[i%2]
+		if value, err := redis.String(conn.Do("GET", "a")); err != nil || value != want {
+			t.Errorf("Error, wrong value: %s %v", value, err)
+		}
+	}
+	// Writes go to the primary
+	if _, err := conn.Do("SET", "b", "1"); err != nil {
+		t.Fatalf("Error, could not set value! %s", err)
+	}
+	if !primary.Exists(0, "b") || replicas[0].Exists(0, "b") || replicas[1].Exists(0, "b") {
+		t.Error("Error, the value should only be written to the primary")
+	}
+	// Transactions go to the primary
+	conn.Send("MULTI")
+	conn.Send("GET", "a")
+	if values, err := redis.Strings(conn.Do("EXEC")); err != nil || len(values) != 1 || values[0] != "primary" {
+		t.Errorf("Error, the transaction should use the primary: %v %v", values, err)
+	}
+	// Pipelines can mix reads and writes
+	conn.Send("GET", "a")
+	conn.Send("SET", "c", "1")
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil || len(replies) != 2 {
+		t.Fatalf("Error, wrong replies: %v %v", replies, err)
+	}
+	if value, _ := redis.String(replies[0], nil); value != "replica1" {
+		t.Errorf("Error, the read should use a replica: %s", value)
+	}
+
+	// The database index is also selected on the replicas
+	conn1 := pool.Get(1)
+	if value, err := redis.String(conn1.Do("GET", "a")); err != nil || value != "db1" {
+		t.Errorf("Error, wrong value in database 1: %s %v", value, err)
+	}
+	conn1.Close()
+
+	// When a replica is down, the other one is used
+	replicas[1].Close()
+	for i := 0; i < 4; i++ {
+		if value, err := redis.String(conn.Do("GET", "a")); err != nil || value == "replica2" {
+			t.Errorf("Error, wrong value: %s %v", value, err)
+		}
+	}
+}
+
+func TestReplicaReadYourWrites(t *testing.T) {
+	primary, replicas := replicaServers(t)
+	defer primary.Close()
+	defer replicas[0].Close()
+	defer replicas[1].Close()
+	pool, err := NewConnectionPoolOptions(primary.Addr, &PoolOptions{
+		Replicas:             []string{replicas[0].Addr},
+		ReadYourWritesWindow: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+
+	kv := NewKeyValue(pool, "abc123_test_replica")
+	if _, err := kv.Get("x"); err == nil {
+		t.Error("Error, the replica should not have the value")
+	}
+	if err := kv.Set("x", "1"); err != nil {
+		t.Fatalf("Error, could not set value! %s", err)
+	}
+	// Right after the write, reads go to the primary
+	if value, err := kv.Get("x"); err != nil || value != "1" {
+		t.Errorf("Error, the value should be read from the primary: %s %v", value, err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := kv.Get("x"); err == nil {
+		t.Error("Error, the value should be read from the replica again")
+	}
+}
+
+func TestReplicaLowestLatency(t *testing.T) {
+	primary, replicas := replicaServers(t)
+	defer primary.Close()
+	defer replicas[0].Close()
+	defer replicas[1].Close()
+	replicas[0].Handle("GET", func(args []string) interface{} {
+		time.Sleep(20 * time.Millisecond)
+		return "replica1"
+	})
+	pool, err := NewConnectionPoolOptions(primary.Addr, &PoolOptions{
+		Replicas:   []string{replicas[0].Addr, replicas[1].Addr},
+		ReadPolicy: ReadLowestLatency,
+	})
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+
+	conn := pool.Get(0)
+	defer conn.Close()
+	fast := 0
+	for i := 0; i < 20; i++ {
+		value, err := redis.String(conn.Do("GET", "a"))
+		if err != nil {
+			t.Fatalf("Error, could not get value! %s", err)
+		}
+		if value == "replica2" {
+			fast++
+		}
+	}
+	if fast < 15 {
+		t.Errorf("Error, most reads should go to the fastest replica: %d", fast)
+	}
+
+	if _, err := NewConnectionPoolOptions(primary.Addr, &PoolOptions{ReadPolicy: 42}); err == nil {
+		t.Error("Error, an unknown read policy should fail")
+	}
+}
+
+func TestReplicaRemove(t *testing.T) {
+	primary, replicas := replicaServers(t)
+	defer primary.Close()
+	defer replicas[0].Close()
+	defer replicas[1].Close()
+	pool, err := NewConnectionPoolOptions(primary.Addr, &PoolOptions{
+		Replicas: []string{replicas[0].Addr, replicas[1].Addr},
+	})
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+
+	// The replicas lag behind, and do not have the keys yet
+	kv := NewKeyValue(pool, "abc123_test_replica_remove")
+	users := NewHashMap(pool, "abc123_test_replica_users")
+	for _, key := range []string{"a", "b", "c"} {
+		if err := kv.Set(key, "1"); err != nil {
+			t.Fatalf("Error, could not set value! %s", err)
+		}
+		if err := users.Set(key, "password", "x"); err != nil {
+			t.Fatalf("Error, could not set value! %s", err)
+		}
+	}
+	if err := kv.Remove(); err != nil {
+		t.Errorf("Error, could not remove key/value! %s", err)
+	}
+	if err := users.Remove(); err != nil {
+		t.Errorf("Error, could not remove hash map! %s", err)
+	}
+	if keys := primary.Keys(0); len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("Error, the keys should be removed from the primary: %v", keys)
+	}
+
+	// Reads go to the replicas again afterwards
+	conn := pool.Get(0)
+	defer conn.Close()
+	if value, err := redis.String(conn.Do("GET", "a")); err != nil || value == "primary" {
+		t.Errorf("Error, the read should use a replica: %s %v", value, err)
+	}
+}
+
+func TestReplicaScan(t *testing.T) {
+	primary, replicas := replicaServers(t)
+	defer primary.Close()
+	defer replicas[0].Close()
+	defer replicas[1].Close()
+	for i, s := range replicas {
+		conn, err := redis.Dial("tcp", s.Addr)
+		if err != nil {
+			t.Fatalf("Error, could not connect! %s", err)
+		}
+		for j := 0; j < 10; j++ {
+			conn.Do("SET", "replica"+strconv.Itoa(i+1)+"_"+strconv.Itoa(j), "x")
+		}
+		conn.Close()
+	}
+	pool, err := NewConnectionPoolOptions(primary.Addr, &PoolOptions{
+		Replicas: []string{replicas[0].Addr, replicas[1].Addr},
+	})
+	if err != nil {
+		t.Fatalf("Error, could not create pool! %s", err)
+	}
+	defer checkLeaks(pool).Close()
+
+	// Each page of a SCAN iteration is read from the same replica
+	conn := pool.Get(0)
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		seen := make(map[string]bool)
+		err := scanKeys(conn, "replica*", 2, func(keys []string) error {
+			for _, key := range keys {
+				seen[key[:len("replica1")]] = true
+			}
+			return nil
+		})
+		if err != nil || len(seen) != 1 {
+			t.Errorf("Error, the keys should come from one replica: %v %v", seen, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package simpleredis
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Returned by a scan function for stopping the scan early
+var errStopScan = errors.New("stop scan")
+
+// SetScanCount sets the default COUNT hint for SCAN, which is how many keys
+// Redis should look at for each batch when iterating over keys. The default
+// is 1024. A larger value means fewer round trips, but each SCAN call may
+// block the server for longer.
+func SetScanCount(count int) {
+	defaultsMutex.Lock()
+	scanCount = count
+	defaultsMutex.Unlock()
+}
+
+// ScanCount returns the current default COUNT hint for SCAN
+func ScanCount() int {
+	defaultsMutex.RLock()
+	defer defaultsMutex.RUnlock()
+	return scanCount
+}
+
+// The COUNT hint for SCAN for this connection pool
+func (pool *ConnectionPool) scanCountHint() int {
+	if count := pool.state().scanCount; count > 0 {
+		return count
+	}
+	return ScanCount()
+}
+
+// Escape the glob-style special characters in a key, for use in a MATCH pattern
+func escapePattern(key string) string {
+	var sb strings.Builder
+	for _, r := range key {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Call SCAN with the given cursor, or SSCAN, HSCAN or ZSCAN if a key is
+// given. Returns the next cursor, which is "0" when the iteration is
+// complete, and the returned batch of values.
+func scanOnce(conn redis.Conn, command, key, cursor, pattern string, count int) (string, []string, error) {
+	args := make([]interface{}, 0, 6)
+	if key != "" {
+		args = append(args, key)
+	}
+	args = append(args, cursor, "MATCH", pattern, "COUNT", count)
+	values, err := redis.Values(conn.Do(command, args...))
+	if err != nil {
+		return "", nil, err
+	}
+	var batch []string
+	if _, err := redis.Scan(values, &cursor, &batch); err != nil {
+		return "", nil, err
+	}
+	return cursor, batch, nil
+}
+
+// Iterate over all keys matching the given pattern with SCAN, without
+// blocking the server like KEYS does. The given function is called for each
+// batch of keys. If it returns errStopScan, the iteration stops without an
+// error. A key may be given to the function more than once.
+func scanKeys(conn redis.Conn, pattern string, count int, f func(keys []string) error) error {
+	cursor := "0"
+	for {
+		var (
+			keys []string
+			err  error
+		)
+		cursor, keys, err = scanOnce(conn, "SCAN", "", cursor, pattern, count)
+		if err != nil {
+			return wrapError("SCAN", pattern, err)
+		}
+		if len(keys) > 0 {
+			if err := f(keys); err != nil {
+				if err == errStopScan {
+					return nil
+				}
+				return err
+			}
+		}
+		// The iteration is complete when the cursor is 0 again
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// Delete the given keys with UNLINK, which frees the memory in the
+// background. DEL is used if the server does not support UNLINK.
+func unlinkKeys(conn redis.Conn, keys []string) error {
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	_, err := conn.Do("UNLINK", args...)
+	if isUnknownCommand(err) {
+		// Redis versions before 4.0
+		_, err := conn.Do("DEL", args...)
+		return wrapError("DEL", keys[0], err)
+	}
+	return wrapError("UNLINK", keys[0], err)
+}
+
+// Delete all keys matching the given pattern, in batches
+func removeKeys(conn redis.Conn, pattern string, count int) error {
+	return scanKeys(conn, pattern, count, func(keys []string) error {
+		return unlinkKeys(conn, keys)
+	})
+}
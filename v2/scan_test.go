@@ -0,0 +1,91 @@
+package simpleredis
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestEscapePattern(t *testing.T) {
+	if escaped := escapePattern(`a*b?c[d]e\f`); escaped != `a\*b\?c\[d\]e\\f` {
+		t.Errorf("Error, wrong escaped pattern: %s", escaped)
+	}
+}
+
+func TestScan(t *testing.T) {
+	const hashmapname = "abc123_test_scan_123abc"
+	// A small COUNT hint, so that several SCAN calls are needed
+	scanPool, err := NewConnectionPoolOptions(testHost, &PoolOptions{DatabaseIndex: 1, ScanCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scanPool.Close()
+	checkLeaks(scanPool)
+
+	hashmap := NewHashMap(scanPool, hashmapname)
+	var expected []string
+	for i := 0; i < 25; i++ {
+		elementid := "user" + strconv.Itoa(i)
+		if err := hashmap.Set(elementid, "name", elementid); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, elementid)
+	}
+	sort.Strings(expected)
+
+	// A hash map with a glob character in the id should not match the other hash map
+	wildcard := NewHashMap(scanPool, "abc123_test_*")
+	if err := wildcard.Set("x", "name", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := wildcard.All(); err != nil || len(all) != 1 || all[0] != "x" {
+		t.Errorf("Error, wrong elements for the hash map with a glob character in the id: %v %v", all, err)
+	}
+
+	all, err := hashmap.All()
+	sort.Strings(all)
+	if err != nil || len(all) != len(expected) {
+		t.Fatalf("Error, wrong number of elements: %d %v", len(all), err)
+	}
+	for i := range all {
+		if all[i] != expected[i] {
+			t.Errorf("Error, wrong element: %s != %s", all[i], expected[i])
+		}
+	}
+	if id, err := hashmap.FindIDByFieldValue("name", "user24"); err != nil || id != "user24" {
+		t.Errorf("Error, could not find element: %s %v", id, err)
+	}
+	if exists, err := hashmap.Exists("user3"); err != nil || !exists {
+		t.Errorf("Error, user3 should exist: %v", err)
+	}
+	if exists, err := hashmap.Exists("user*"); err != nil || exists {
+		t.Errorf("Error, Exists should not treat the element id as a pattern: %v", err)
+	}
+
+	// Removing all keys, in batches
+	if err := hashmap.Remove(); err != nil {
+		t.Errorf("Error, could not remove hash map! %s", err)
+	}
+	if empty, err := hashmap.Empty(); err != nil || !empty {
+		t.Errorf("Error, the hash map should be empty: %v", err)
+	}
+	if empty, err := wildcard.Empty(); err != nil || empty {
+		t.Errorf("Error, the other hash map should not have been removed: %v", err)
+	}
+	if err := wildcard.Remove(); err != nil {
+		t.Error(err)
+	}
+
+	kv := NewKeyValue(scanPool, hashmapname)
+	for i := 0; i < 10; i++ {
+		if err := kv.Set(strconv.Itoa(i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Remove(); err != nil {
+		t.Errorf("Error, could not remove key/value! %s", err)
+	}
+	if found, err := hasKey(nil, scanPool, hashmapname+":*", 1); err != nil || found {
+		t.Errorf("Error, the keys should have been removed: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrUnknownScript is returned when running a script that is not registered
+var ErrUnknownScript = errors.New("unknown script")
+
+// The Lua scripts that are registered for a connection pool
+type scriptRegistry struct {
+	mut     sync.RWMutex
+	scripts map[string]registeredScript
+}
+
+type registeredScript struct {
+	source string
+	script *redis.Script
+}
+
+// Script is a registered Lua script, that can be run with keys of the data
+// structures, like the keys returned by List.RedisKey or HashMap.RedisKey.
+// EVALSHA is used, with EVAL as a fallback if the server does not have the
+// script in its script cache.
+type Script struct {
+	pool    *ConnectionPool
+	name    string
+	script  *redis.Script
+	dbindex int
+	ctx     context.Context
+}
+
+// Load all registered scripts into the script cache of the server, using the
+// given connection. Errors are ignored, since the scripts are also sent with
+// EVAL if the server does not have them.
+func (r *scriptRegistry) load(conn redis.Conn) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	if len(r.scripts) == 0 {
+		return
+	}
+	for _, s := range r.scripts {
+		conn.Send("SCRIPT", "LOAD", s.source)
+	}
+	conn.Do("")
+}
+
+// Wrap a dial function, so that the registered scripts are loaded for each new connection
+func (r *scriptRegistry) wrapDial(dial func() (redis.Conn, error)) func() (redis.Conn, error) {
+	if dial == nil {
+		return nil
+	}
+	return func() (redis.Conn, error) {
+		conn, err := dial()
+		if err == nil {
+			r.load(conn)
+		}
+		return conn, err
+	}
+}
+
+// Wrap a dial function with a context, so that the registered scripts are
+// loaded for each new connection
+func (r *scriptRegistry) wrapDialContext(dial func(context.Context) (redis.Conn, error)) func(context.Context) (redis.Conn, error) {
+	if dial == nil {
+		return nil
+	}
+	return func(ctx context.Context) (redis.Conn, error) {
+		conn, err := dial(ctx)
+		if err == nil {
+			r.load(conn)
+		}
+		return conn, err
+	}
+}
+
+/* --- Script functions --- */
+
+// RegisterScript registers a Lua script with the given name, and loads it
+// into the script cache of the server. The script is also loaded for each
+// new connection in the pool, for instance after the server has restarted.
+// Registering a script with the same name again replaces the script.
+func (pool *ConnectionPool) RegisterScript(name, source string) error {
+	// The number of keys is given when running the script
+	script := redis.NewScript(-1, source)
+	conn := pool.get(nil, pool.state().dbindex)
+	defer conn.Close()
+	if err := script.Load(conn); err != nil {
+		return wrapError("SCRIPT", "", err)
+	}
+	r := &pool.mutableState().scripts
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.scripts == nil {
+		r.scripts = make(map[string]registeredScript)
+	}
+	r.scripts[name] = registeredScript{source, script}
+	return nil
+}
+
+// Script returns the registered script with the given name. If there is no
+// such script, running it returns ErrUnknownScript.
+func (pool *ConnectionPool) Script(name string) *Script {
+	r := &pool.state().scripts
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return &Script{pool: pool, name: name, script: r.scripts[name].script, dbindex: pool.state().dbindex}
+}
+
+// Select a different database
+func (s *Script) SelectDatabase(dbindex int) {
+	s.dbindex = dbindex
+}
+
+// WithContext returns a copy of the script where running it uses the
+// given context, so that it is aborted when the context is done
+func (s *Script) WithContext(ctx context.Context) *Script {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// The first of the given keys, for error messages
+func firstKey(keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
+
+// Do runs the script with the given keys and arguments, and returns the reply
+func (s *Script) Do(keys []string, args ...interface{}) (interface{}, error) {
+	key := firstKey(keys)
+	if s.script == nil {
+		return nil, wrapError("EVALSHA", key, fmt.Errorf("%w: %s", ErrUnknownScript, s.name))
+	}
+	keysAndArgs := make([]interface{}, 0, 1+len(keys)+len(args))
+	keysAndArgs = append(keysAndArgs, len(keys))
+	for _, k := range keys {
+		keysAndArgs = append(keysAndArgs, k)
+	}
+	keysAndArgs = append(keysAndArgs, args...)
+	conn := s.pool.get(s.ctx, s.dbindex)
+	defer conn.Close()
+	reply, err := s.script.Do(conn, keysAndArgs...)
+	return reply, wrapError("EVALSHA", key, err)
+}
+
+// String runs the script and returns the reply as a string
+func (s *Script) String(keys []string, args ...interface{}) (string, error) {
+	reply, err := s.Do(keys, args...)
+	if err != nil {
+		return "", err
+	}
+	// Lua numbers are returned as integers
+	if n, ok := reply.(int64); ok {
+		return strconv.FormatInt(n, 10), nil
+	}
+	str, err := redis.String(reply, nil)
+	return str, wrapError("EVALSHA", firstKey(keys), err)
+}
+
+// Strings runs the script and returns the reply as a slice of strings
+func (s *Script) Strings(keys []string, args ...interface{}) ([]string, error) {
+	reply, err := s.Do(keys, args...)
+	if err != nil {
+		return nil, err
+	}
+	strs, err := redis.Strings(reply, nil)
+	return strs, wrapError("EVALSHA", firstKey(keys), err)
+}
+
+// Int64 runs the script and returns the reply as an integer
+func (s *Script) Int64(keys []string, args ...interface{}) (int64, error) {
+	reply, err := s.Do(keys, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := redis.Int64(reply, nil)
+	return n, wrapError("EVALSHA", firstKey(keys), err)
+}
+
+// Bool runs the script and returns the reply as a boolean.
+// A Lua true is returned as 1, and a Lua false as nil.
+func (s *Script) Bool(keys []string, args ...interface{}) (bool, error) {
+	reply, err := s.Do(keys, args...)
+	if err != nil || reply == nil {
+		return false, err
+	}
+	b, err := redis.Bool(reply, nil)
+	return b, wrapError("EVALSHA", firstKey(keys), err)
+}
+
+// StringMap runs the script and returns the reply, which must be a list of
+// keys and values, as a map
+func (s *Script) StringMap(keys []string, args ...interface{}) (map[string]string, error) {
+	reply, err := s.Do(keys, args...)
+	if err != nil {
+		return nil, err
+	}
+	m, err := redis.StringMap(reply, nil)
+	return m, wrapError("EVALSHA", firstKey(keys), err)
+}
+
+/* --- Keys for scripts --- */
+
+// RedisKey returns the key of the list, for use with scripts
+func (rl *List) RedisKey() string {
+	return rl.id
+}
+
+// RedisKey returns the key of the set, for use with scripts
+func (rs *Set) RedisKey() string {
+	return rs.id
+}
+
+// RedisKey returns the key of an element of the hash map, for use with scripts
+func (rh *HashMap) RedisKey(elementid string) string {
+	return rh.id + ":" + elementid
+}
+
+// RedisKey returns the key of a key in the key/value, for use with scripts
+func (rkv *KeyValue) RedisKey(key string) string {
+	return rkv.id + ":" + key
+}
@@ -0,0 +1,183 @@
+package simpleredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+const (
+	// Move a member from one set to another, if it is in the first set
+	moveScript = `if redis.call("SREM", KEYS[1], ARGV[1]) == 1 then
+	redis.call("SADD", KEYS[2], ARGV[1])
+	return 1
+end
+return 0`
+
+	// Set a field of a hash map, and return the old value
+	swapScript = `local old = redis.call("HGET", KEYS[1], ARGV[1])
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+return old`
+
+	// Return all fields and values of a hash map
+	fieldsScript = `return redis.call("HGETALL", KEYS[1])`
+
+	// Return all elements of a list
+	elementsScript = `return redis.call("LRANGE", KEYS[1], 0, -1)`
+)
+
+// The in-memory test server can not run Lua, so the scripts that are used
+// by the tests are implemented in Go
+func defineTestScripts(s *redistest.Server) {
+	s.DefineScript(moveScript, func(call func(args ...string) interface{}, keys, argv []string) interface{} {
+		if n, _ := call("SREM", keys[0], argv[0]).(int64); n == 1 {
+			call("SADD", keys[1], argv[0])
+			return int64(1)
+		}
+		return int64(0)
+	})
+	s.DefineScript(swapScript, func(call func(args ...string) interface{}, keys, argv []string) interface{} {
+		old := call("HGET", keys[0], argv[0])
+		call("HSET", keys[0], argv[0], argv[1])
+		return old
+	})
+	s.DefineScript(fieldsScript, func(call func(args ...string) interface{}, keys, argv []string) interface{} {
+		return call("HGETALL", keys[0])
+	})
+	s.DefineScript(elementsScript, func(call func(args ...string) interface{}, keys, argv []string) interface{} {
+		return call("LRANGE", keys[0], "0", "-1")
+	})
+}
+
+func TestScript(t *testing.T) {
+	scriptPool := NewConnectionPoolHost(testHost)
+	defer scriptPool.Close()
+	checkLeaks(scriptPool)
+
+	for name, source := range map[string]string{"move": moveScript, "swap": swapScript, "fields": fieldsScript, "elements": elementsScript} {
+		if err := scriptPool.RegisterScript(name, source); err != nil {
+			t.Fatalf("Error, could not register script! %s", err)
+		}
+	}
+
+	from := NewSet(scriptPool, "abc123_test_script_from")
+	to := NewSet(scriptPool, "abc123_test_script_to")
+	users := NewHashMap(scriptPool, "abc123_test_script_users")
+	list := NewList(scriptPool, "abc123_test_script_list")
+	defer from.Remove()
+	defer to.Remove()
+	defer users.Remove()
+	defer list.Remove()
+
+	from.Add("bob")
+	moved, err := scriptPool.Script("move").Bool([]string{from.RedisKey(), to.RedisKey()}, "bob")
+	if err != nil || !moved {
+		t.Errorf("Error, bob should have been moved! %v", err)
+	}
+	if has, err := to.Has("bob"); err != nil || !has {
+		t.Errorf("Error, bob should have been moved! %v", err)
+	}
+	if n, err := scriptPool.Script("move").Int64([]string{from.RedisKey(), to.RedisKey()}, "bob"); err != nil || n != 0 {
+		t.Errorf("Error, bob should not be moved again! %d %v", n, err)
+	}
+
+	swap := scriptPool.Script("swap")
+	if _, err := swap.String([]string{users.RedisKey("bob")}, "group", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound for a missing field! %v", err)
+	}
+	if old, err := swap.String([]string{users.RedisKey("bob")}, "group", "b"); err != nil || old != "a" {
+		t.Errorf("Error, wrong old value: %s %v", old, err)
+	}
+	if fields, err := scriptPool.Script("fields").StringMap([]string{users.RedisKey("bob")}); err != nil || fields["group"] != "b" {
+		t.Errorf("Error, wrong fields: %v %v", fields, err)
+	}
+
+	list.Add("a")
+	list.Add("b")
+	if elements, err := scriptPool.Script("elements").Strings([]string{list.RedisKey()}); err != nil || len(elements) != 2 || elements[1] != "b" {
+		t.Errorf("Error, wrong elements: %v %v", elements, err)
+	}
+
+	if _, err := scriptPool.Script("nosuchscript").Do(nil); !errors.Is(err, ErrUnknownScript) {
+		t.Errorf("Error, expected ErrUnknownScript! %v", err)
+	}
+}
+
+func TestScriptPreload(t *testing.T) {
+	scriptPool := NewConnectionPoolHost(testHost)
+	defer scriptPool.Close()
+	checkLeaks(scriptPool)
+
+	if err := scriptPool.RegisterScript("elements", elementsScript); err != nil {
+		t.Fatalf("Error, could not register script! %s", err)
+	}
+	sha := redis.NewScript(-1, elementsScript).Hash()
+
+	// Empty the script cache, like when the server is restarted
+	conn := scriptPool.Get(scriptPool.DatabaseIndex())
+	defer conn.Close()
+	if _, err := conn.Do("SCRIPT", "FLUSH"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new connection loads the registered scripts
+	newConn := scriptPool.Get(scriptPool.DatabaseIndex())
+	exists, err := redis.Ints(newConn.Do("SCRIPT", "EXISTS", sha))
+	newConn.Close()
+	if err != nil || len(exists) != 1 || exists[0] != 1 {
+		t.Errorf("Error, the script should have been loaded! %v %v", exists, err)
+	}
+
+	// Scripts that are not in the script cache are sent with EVAL
+	if _, err := conn.Do("SCRIPT", "FLUSH"); err != nil {
+		t.Fatal(err)
+	}
+	list := NewList(scriptPool, "abc123_test_script_preload")
+	defer list.Remove()
+	list.Add("a")
+	if elements, err := scriptPool.Script("elements").Strings([]string{list.RedisKey()}); err != nil || len(elements) != 1 {
+		t.Errorf("Error, wrong elements: %v %v", elements, err)
+	}
+}
+
+func TestScriptRedisPool(t *testing.T) {
+	// A ConnectionPool may also be created directly from a redis.Pool
+	scriptPool := (*ConnectionPool)(&redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", testHost)
+		},
+	})
+	defer scriptPool.Close()
+	checkLeaks(scriptPool)
+
+	if err := scriptPool.RegisterScript("elements", elementsScript); err != nil {
+		t.Fatalf("Error, could not register script! %s", err)
+	}
+	list := NewList(scriptPool, "abc123_test_script_redis_pool")
+	defer list.Remove()
+	list.Add("a")
+	if elements, err := scriptPool.Script("elements").Strings([]string{list.RedisKey()}); err != nil || len(elements) != 1 {
+		t.Errorf("Error, wrong elements: %v %v", elements, err)
+	}
+}
+
+func TestPoolStateClosed(t *testing.T) {
+	scriptPool := NewConnectionPoolHost(testHost)
+	if err := scriptPool.RegisterScript("elements", elementsScript); err != nil {
+		t.Fatalf("Error, could not register script! %s", err)
+	}
+	scriptPool.Close()
+	if _, ok := poolStates.Load(scriptPool); ok {
+		t.Error("Error, the state should be released when the pool is closed")
+	}
+	// Using the pool after it has been closed does not create the state again
+	list := NewList(scriptPool, "abc123_test_pool_state")
+	list.Add("a")
+	scriptPool.RegisterScript("elements", elementsScript)
+	NewHashMap(scriptPool, "abc123_test_pool_state").Set("bob", "token", "123abc")
+	if _, ok := poolStates.Load(scriptPool); ok {
+		t.Error("Error, the state should not be created again after the pool is closed")
+	}
+}
@@ -0,0 +1,215 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// ErrMasterNotFound is returned when none of the sentinels know the address of the master
+	ErrMasterNotFound = errors.New("the master was not found by any sentinel")
+
+	// ErrNotMaster is returned when a server that should be the master is a replica,
+	// for instance while a failover is in progress
+	ErrNotMaster = errors.New("the server is not a master")
+)
+
+// Idle connections that were used more recently than this are not checked
+// with ROLE again when they are borrowed
+var roleCheckInterval = time.Second
+
+// The sentinels that are asked for the address of a master
+type sentinel struct {
+	mut        sync.Mutex
+	masterName string
+	// The sentinel that answered last is first
+	addrs []string
+	// Settings for connecting to the sentinels
+	settings *connectionSettings
+	// The address of the current master, or empty if it must be resolved
+	master string
+}
+
+// Ask the sentinels for the address of the master, and remember it
+func (s *sentinel) resolve(ctx context.Context) (string, error) {
+	s.mut.Lock()
+	addrs := append([]string(nil), s.addrs...)
+	s.mut.Unlock()
+	var lastErr error
+	for _, addr := range addrs {
+		cu := *s.settings
+		cu.network, cu.address = networkAndAddress(addr)
+		conn, err := cu.dialContext(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		hostAndPort, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.masterName))
+		conn.Close()
+		if err != nil || len(hostAndPort) != 2 {
+			if err == nil || err == redis.ErrNil {
+				err = errors.New(addr + " does not know the master")
+			}
+			lastErr = err
+			continue
+		}
+		master := net.JoinHostPort(hostAndPort[0], hostAndPort[1])
+		s.mut.Lock()
+		// Ask the same sentinel first the next time
+		for i, a := range s.addrs {
+			if a == addr {
+				copy(s.addrs[1:i+1], s.addrs[:i])
+				s.addrs[0] = addr
+				break
+			}
+		}
+		s.master = master
+		s.mut.Unlock()
+		return master, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no sentinels")
+	}
+	return "", fmt.Errorf("%w: %s: %v", ErrMasterNotFound, s.masterName, lastErr)
+}
+
+// The address of the current master, which is resolved if needed
+func (s *sentinel) masterAddr(ctx context.Context) (string, error) {
+	s.mut.Lock()
+	master := s.master
+	s.mut.Unlock()
+	if master != "" {
+		return master, nil
+	}
+	return s.resolve(ctx)
+}
+
+// Forget the address of the master, so that it is resolved again.
+// If an address is given, it is only forgotten if it is still the current one.
+func (s *sentinel) invalidate(master string) {
+	s.mut.Lock()
+	if master == "" || s.master == master {
+		s.master = ""
+	}
+	s.mut.Unlock()
+}
+
+// Check that the server at the other end of the connection is a master
+func checkMaster(conn redis.Conn) error {
+	values, err := redis.Values(conn.Do("ROLE"))
+	if isNoPermission(err) {
+		// ROLE is not allowed for this user, trust the address from the sentinel
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	role := ""
+	if len(values) > 0 {
+		role, _ = redis.String(values[0], nil)
+	}
+	if role != "master" {
+		return fmt.Errorf("%w, the role is %q", ErrNotMaster, role)
+	}
+	return nil
+}
+
+// Check if the error is a NOPERM reply, for a command the user may not run
+func isNoPermission(err error) bool {
+	var replyErr redis.Error
+	return errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "NOPERM")
+}
+
+// Connect to the master with the given settings. If the master can not be
+// reached, or is not a master any longer, the sentinels are asked again.
+func (s *sentinel) dialContext(ctx context.Context, cu *connectionSettings) (redis.Conn, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		master, err := s.masterAddr(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mcu := *cu
+		mcu.network, mcu.address = "tcp", master
+		conn, err := mcu.dialContext(ctx)
+		if err == nil {
+			if err = checkMaster(conn); err == nil {
+				return conn, nil
+			}
+			conn.Close()
+		}
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			return nil, err
+		}
+		lastErr = err
+		s.invalidate(master)
+	}
+	return nil, lastErr
+}
+
+// Check the role of idle connections before they are used again, so that
+// connections to a master that has become a replica are closed. Connections
+// that were just used are not checked, to avoid a ROLE for each command.
+func (s *sentinel) testOnBorrow(test func(c redis.Conn, t time.Time) error) func(c redis.Conn, t time.Time) error {
+	return func(c redis.Conn, t time.Time) error {
+		if test != nil {
+			if err := test(c, t); err != nil {
+				return err
+			}
+		}
+		if time.Since(t) < roleCheckInterval {
+			return nil
+		}
+		if err := checkMaster(c); err != nil {
+			// There may have been a failover
+			s.invalidate("")
+			return err
+		}
+		return nil
+	}
+}
+
+// Create a new connection pool for the master with the given name, that is
+// monitored by Redis Sentinel. The sentinels are given as host:port strings,
+// and are asked for the address of the master with
+// SENTINEL get-master-addr-by-name. The role of each connection is checked
+// when it is created and before an idle connection is used again, and the
+// sentinels are asked again after a failover. If the user may not run ROLE,
+// the address from the sentinels is trusted. The options are used for
+// connecting to the master, and may be nil for using the defaults.
+// Replicas are not supported.
+// Close must be called when the pool is no longer needed.
+func NewConnectionPoolSentinel(masterName string, sentinelAddrs []string, options *PoolOptions) (*ConnectionPool, error) {
+	if len(sentinelAddrs) == 0 {
+		return nil, errors.New("at least one sentinel address is needed")
+	}
+	cu, err := options.checkedSettings(false)
+	if err != nil {
+		return nil, err
+	}
+	// The sentinels have their own credentials, and no databases
+	sentinelSettings := *cu
+	if options != nil {
+		sentinelSettings.username, sentinelSettings.password = options.SentinelUsername, options.SentinelPassword
+	}
+	sentinelSettings.dbindex = 0
+	sentinelSettings.useHello = false
+	s := &sentinel{
+		masterName: masterName,
+		addrs:      append([]string(nil), sentinelAddrs...),
+		settings:   &sentinelSettings,
+	}
+	cu.testOnBorrow = s.testOnBorrow(cu.testOnBorrow)
+	// Connect to the current master
+	return newPool(cu, func(ctx context.Context) (redis.Conn, error) {
+		return s.dialContext(ctx, cu)
+	}), nil
+}
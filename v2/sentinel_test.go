@@ -0,0 +1,170 @@
+package simpleredis
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+// A fake sentinel, that answers SENTINEL get-master-addr-by-name with the
+// address of the given master
+type fakeSentinel struct {
+	*redistest.Server
+	mut    sync.Mutex
+	master string
+}
+
+func newFakeSentinel(masterName string, master *redistest.Server) *fakeSentinel {
+	s := &fakeSentinel{Server: redistest.NewServer(), master: master.Addr}
+	s.Handle("SENTINEL", func(args []string) interface{} {
+		if len(args) != 3 || !strings.EqualFold(args[1], "get-master-addr-by-name") {
+			return errors.New("ERR unknown sentinel subcommand")
+		}
+		if args[2] != masterName {
+			return nil
+		}
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		host, port, _ := net.SplitHostPort(s.master)
+		return []interface{}{host, port}
+	})
+	return s
+}
+
+// Point the sentinel to a new master
+func (s *fakeSentinel) failover(master *redistest.Server) {
+	s.mut.Lock()
+	s.master = master.Addr
+	s.mut.Unlock()
+}
+
+// Make the given server answer ROLE like a replica
+func demote(s *redistest.Server) {
+	s.Handle("ROLE", func(args []string) interface{} {
+		return []interface{}{"slave", "127.0.0.1", int64(6379), "connected", int64(0)}
+	})
+}
+
+func TestSentinelPool(t *testing.T) {
+	defer func(interval time.Duration) {
+		roleCheckInterval = interval
+	}(roleCheckInterval)
+	// Check the role each time an idle connection is borrowed
+	roleCheckInterval = 0
+
+	master := redistest.NewServer()
+	defer master.Close()
+	replica := redistest.NewServer()
+	defer replica.Close()
+
+	// The first sentinel is down
+	down := redistest.NewServer()
+	down.Close()
+	sentinel := newFakeSentinel("mymaster", master)
+	defer sentinel.Close()
+
+	sentinelPool, err := NewConnectionPoolSentinel("mymaster", []string{down.Addr, sentinel.Addr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sentinelPool.Close()
+	checkLeaks(sentinelPool)
+
+	kv := NewKeyValue(sentinelPool, "abc123_test_sentinel")
+	if err := kv.Set("a", "1"); err != nil {
+		t.Fatalf("Error, could not set value! %s", err)
+	}
+	if !master.Exists(0, "abc123_test_sentinel:a") {
+		t.Error("Error, the value should have been written to the master")
+	}
+
+	// Fail over to the replica, which becomes the new master
+	demote(master)
+	sentinel.failover(replica)
+
+	// The idle connection to the old master is closed when borrowed
+	if err := kv.Set("a", "2"); err != nil {
+		t.Fatalf("Error, could not set value after the failover! %s", err)
+	}
+	if !replica.Exists(0, "abc123_test_sentinel:a") {
+		t.Error("Error, the value should have been written to the new master")
+	}
+	if value, err := kv.Get("a"); err != nil || value != "2" {
+		t.Errorf("Error, wrong value: %s %v", value, err)
+	}
+}
+
+func TestSentinelPoolNotFound(t *testing.T) {
+	master := redistest.NewServer()
+	defer master.Close()
+	sentinel := newFakeSentinel("mymaster", master)
+	defer sentinel.Close()
+
+	sentinelPool, err := NewConnectionPoolSentinel("othermaster", []string{sentinel.Addr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sentinelPool.Close()
+	if err := sentinelPool.Ping(); !errors.Is(err, ErrMasterNotFound) {
+		t.Errorf("Error, expected ErrMasterNotFound! %v", err)
+	}
+
+	// A sentinel that points to a replica
+	demote(master)
+	replicaPool, err := NewConnectionPoolSentinel("mymaster", []string{sentinel.Addr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaPool.Close()
+	if err := replicaPool.Ping(); !errors.Is(err, ErrNotMaster) {
+		t.Errorf("Error, expected ErrNotMaster! %v", err)
+	}
+
+	if _, err := NewConnectionPoolSentinel("mymaster", nil, nil); err == nil {
+		t.Error("Error, sentinel addresses should be required")
+	}
+}
+
+func TestSentinelRoleCheck(t *testing.T) {
+	master := redistest.NewServer()
+	defer master.Close()
+	sentinel := newFakeSentinel("mymaster", master)
+	defer sentinel.Close()
+
+	// The user may not run ROLE
+	var mut sync.Mutex
+	roles := 0
+	master.Handle("ROLE", func(args []string) interface{} {
+		mut.Lock()
+		roles++
+		mut.Unlock()
+		return errors.New("NOPERM User default has no permissions to run the 'role' command")
+	})
+	sentinelPool, err := NewConnectionPoolSentinel("mymaster", []string{sentinel.Addr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkLeaks(sentinelPool).Close()
+
+	kv := NewKeyValue(sentinelPool, "abc123_test_sentinel_role")
+	for i := 0; i < 5; i++ {
+		if err := kv.Set("a", "1"); err != nil {
+			t.Fatalf("Error, could not set value! %s", err)
+		}
+	}
+	// The role is checked when connecting, but not for connections that were just used
+	mut.Lock()
+	defer mut.Unlock()
+	if roles != 1 {
+		t.Errorf("Error, the role should only be checked once: %d", roles)
+	}
+
+	if _, err := NewConnectionPoolSentinel("mymaster", []string{sentinel.Addr}, &PoolOptions{Replicas: []string{master.Addr}}); err == nil {
+		t.Error("Error, replicas should not be supported")
+	}
+}
@@ -0,0 +1,244 @@
+package simpleredis
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// The number of points on the hash ring for each shard
+const ringPointsPerShard = 160
+
+// ShardedPool spreads data structures, or the elements of hash maps and
+// key/values, over several independent Redis servers, with consistent
+// hashing. Like for Redis Cluster, a key with a hashtag, like
+// "{users}:alice", is placed on the shard of the hashtag.
+type ShardedPool struct {
+	mut   sync.RWMutex
+	pools []*ConnectionPool
+	// Sorted by hash
+	ring []ringPoint
+}
+
+// A point on the hash ring
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// Hash a string for the hash ring
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// Find the part of a key that decides the shard. This is the hashtag, if
+// the key contains one, or else the whole key.
+func shardKey(key string) string {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
+
+// Create a new sharded pool, given the pools for each shard. The order of
+// the pools must be the same each time, since the shards are numbered.
+func NewShardedPool(pools ...*ConnectionPool) *ShardedPool {
+	sp := &ShardedPool{}
+	for _, pool := range pools {
+		sp.addShard(pool)
+	}
+	return sp
+}
+
+// Add a shard to the ring. Must be called with the mutex locked.
+func (sp *ShardedPool) addShard(pool *ConnectionPool) {
+	shard := len(sp.pools)
+	sp.pools = append(sp.pools, pool)
+	for i := 0; i < ringPointsPerShard; i++ {
+		sp.ring = append(sp.ring, ringPoint{ringHash("shard-" + strconv.Itoa(shard) + "-" + strconv.Itoa(i)), shard})
+	}
+	sort.Slice(sp.ring, func(i, j int) bool {
+		return sp.ring[i].hash < sp.ring[j].hash
+	})
+}
+
+// The index of the shard for the given key. Must be called with the mutex locked.
+func (sp *ShardedPool) shard(key string) int {
+	hash := ringHash(shardKey(key))
+	i := sort.Search(len(sp.ring), func(i int) bool {
+		return sp.ring[i].hash >= hash
+	})
+	if i == len(sp.ring) {
+		i = 0
+	}
+	return sp.ring[i].shard
+}
+
+// Pools returns the pools of all shards
+func (sp *ShardedPool) Pools() []*ConnectionPool {
+	sp.mut.RLock()
+	defer sp.mut.RUnlock()
+	return append([]*ConnectionPool(nil), sp.pools...)
+}
+
+// Pool returns the pool of the shard for the given Redis key, or nil if
+// there are no shards
+func (sp *ShardedPool) Pool(key string) *ConnectionPool {
+	sp.mut.RLock()
+	defer sp.mut.RUnlock()
+	if len(sp.pools) == 0 {
+		return nil
+	}
+	return sp.pools[sp.shard(key)]
+}
+
+// ElementPool returns the pool of the shard for an element of a hash map,
+// or a key of a key/value, so that the elements are spread over all shards:
+//
+//	users := simpleredis.NewHashMap(sp.ElementPool("users", "alice"), "users")
+//	users.Set("alice", "password", "...")
+func (sp *ShardedPool) ElementPool(id, elementid string) *ConnectionPool {
+	return sp.Pool(id + ":" + elementid)
+}
+
+// The id of a data structure that is kept on one shard. The id is used as a
+// hashtag, so that all keys of the data structure are moved together when
+// resharding.
+func structureHashtag(id string) string {
+	return "{" + id + "}"
+}
+
+// NewList creates a new list on the shard for the given id
+func (sp *ShardedPool) NewList(id string) *List {
+	id = structureHashtag(id)
+	return NewList(sp.Pool(id), id)
+}
+
+// NewSet creates a new set on the shard for the given id
+func (sp *ShardedPool) NewSet(id string) *Set {
+	id = structureHashtag(id)
+	return NewSet(sp.Pool(id), id)
+}
+
+// NewHashMap creates a new hash map where all elements are on the shard for
+// the given id. Use ElementPool for spreading the elements over the shards.
+func (sp *ShardedPool) NewHashMap(id string) *HashMap {
+	id = structureHashtag(id)
+	return NewHashMap(sp.Pool(id), id)
+}
+
+// NewKeyValue creates a new key/value where all keys are on the shard for
+// the given id. Use ElementPool for spreading the keys over the shards.
+func (sp *ShardedPool) NewKeyValue(id string) *KeyValue {
+	id = structureHashtag(id)
+	return NewKeyValue(sp.Pool(id), id)
+}
+
+// AddShard adds a shard to the sharded pool. Some of the keys on the other
+// shards now belong to the new shard, and can be moved with Reshard.
+func (sp *ShardedPool) AddShard(pool *ConnectionPool) {
+	sp.mut.Lock()
+	sp.addShard(pool)
+	sp.mut.Unlock()
+}
+
+// Reshard moves all keys that are on the wrong shard, like after AddShard,
+// with DUMP and RESTORE. All keys in the databases of the shards are
+// expected to belong to the sharded pool. Keys that already exist on the
+// other shard are left in place. The keys should not be written to while
+// resharding. Returns the number of keys that were moved.
+func (sp *ShardedPool) Reshard() (int, error) {
+	sp.mut.RLock()
+	defer sp.mut.RUnlock()
+	moved := 0
+	for from, pool := range sp.pools {
+		conn := pool.getPrimary(nil, pool.state().dbindex)
+		err := scanKeys(conn, "*", pool.scanCountHint(), func(keys []string) error {
+			for _, key := range keys {
+				to := sp.shard(key)
+				if to == from {
+					continue
+				}
+				ok, err := migrateKey(conn, sp.pools[to], key)
+				if err != nil {
+					return err
+				}
+				if ok {
+					moved++
+				}
+			}
+			return nil
+		})
+		conn.Close()
+		if err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// Move a key to another pool, with the same time to live.
+// Returns false if the key does not exist any longer.
+func migrateKey(conn redis.Conn, to *ConnectionPool, key string) (bool, error) {
+	conn.Send("PTTL", key)
+	conn.Send("DUMP", key)
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return false, wrapError("DUMP", key, err)
+	}
+	if err := pipelineError(replies); err != nil {
+		return false, wrapError("DUMP", key, err)
+	}
+	if len(replies) != 2 || replies[1] == nil {
+		return false, nil
+	}
+	ttl, _ := redis.Int64(replies[0], nil)
+	if ttl < 0 {
+		ttl = 0
+	}
+	toConn := to.get(nil, to.DatabaseIndex())
+	_, err = toConn.Do("RESTORE", key, ttl, replies[1])
+	toConn.Close()
+	if replyErr, ok := err.(redis.Error); ok && strings.HasPrefix(string(replyErr), "BUSYKEY") {
+		// The key is also on the other shard, like the expiry index of a
+		// hash map where the elements are spread over the shards
+		return false, nil
+	}
+	if err != nil {
+		return false, wrapError("RESTORE", key, err)
+	}
+	if _, err := conn.Do("DEL", key); err != nil {
+		return false, wrapError("DEL", key, err)
+	}
+	return true, nil
+}
+
+// Close the pools of all shards
+func (sp *ShardedPool) Close() {
+	for _, pool := range sp.Pools() {
+		pool.Close()
+	}
+}
+
+// Ping all shards, and return the first error
+func (sp *ShardedPool) Ping() error {
+	pools := sp.Pools()
+	if len(pools) == 0 {
+		return errors.New("no shards")
+	}
+	for _, pool := range pools {
+		if err := pool.Ping(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
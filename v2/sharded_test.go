@@ -0,0 +1,106 @@
+package simpleredis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+func TestShardedPool(t *testing.T) {
+	var servers []*redistest.Server
+	var pools []*ConnectionPool
+	for i := 0; i < 3; i++ {
+		s := redistest.NewServer()
+		defer s.Close()
+		servers = append(servers, s)
+		pools = append(pools, checkLeaks(NewConnectionPoolHost(s.Addr)))
+	}
+	sp := NewShardedPool(pools[0], pools[1])
+	defer sp.Close()
+	if err := sp.Ping(); err != nil {
+		t.Fatalf("Error, could not ping the shards! %s", err)
+	}
+
+	// The elements are spread over the shards
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		kv := NewKeyValue(sp.ElementPool("abc123_test_sessions", key), "abc123_test_sessions")
+		if err := kv.SetExpire(key, strconv.Itoa(i), time.Hour); err != nil {
+			t.Fatalf("Error, could not set value! %s", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if n := len(servers[i].Keys(0)); n < 10 {
+			t.Errorf("Error, too few keys on shard %d: %d", i, n)
+		}
+	}
+
+	// All elements of a hash map are on one shard
+	users := sp.NewHashMap("abc123_test_users")
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := users.Set(name, "password", "x"); err != nil {
+			t.Fatalf("Error, could not set value! %s", err)
+		}
+	}
+	list := sp.NewList("abc123_test_list")
+	list.Add("a")
+	owner := 0
+	if servers[1].Exists(0, users.RedisKey("alice")) {
+		owner = 1
+	}
+	for _, name := range []string{"bob", "carol"} {
+		if !servers[owner].Exists(0, users.RedisKey(name)) {
+			t.Errorf("Error, %s should be on the same shard as alice", name)
+		}
+	}
+
+	before := [2]map[string]bool{{}, {}}
+	for i := 0; i < 2; i++ {
+		for _, key := range servers[i].Keys(0) {
+			before[i][key] = true
+		}
+	}
+
+	// Add a shard, and move the keys that belong to it
+	sp.AddShard(pools[2])
+	moved, err := sp.Reshard()
+	if err != nil {
+		t.Fatalf("Error, could not reshard! %s", err)
+	}
+	if n := len(servers[2].Keys(0)); moved == 0 || n != moved {
+		t.Errorf("Error, the moved keys should be on the new shard: %d %d", moved, n)
+	}
+	// With consistent hashing, keys are only moved to the new shard
+	for i := 0; i < 2; i++ {
+		for _, key := range servers[i].Keys(0) {
+			if !before[i][key] {
+				t.Errorf("Error, %s was moved between the old shards", key)
+			}
+		}
+	}
+	if moved, err := sp.Reshard(); err != nil || moved != 0 {
+		t.Errorf("Error, nothing should be moved the second time: %d %v", moved, err)
+	}
+
+	// All values can still be found, with the same time to live
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		kv := NewKeyValue(sp.ElementPool("abc123_test_sessions", key), "abc123_test_sessions")
+		if value, err := kv.Get(key); err != nil || value != strconv.Itoa(i) {
+			t.Errorf("Error, wrong value for %s: %s %v", key, value, err)
+		}
+		if ttl, err := kv.TimeToLive(key); err != nil || ttl <= 0 || ttl > time.Hour {
+			t.Errorf("Error, wrong time to live for %s: %s %v", key, ttl, err)
+		}
+	}
+	users = sp.NewHashMap("abc123_test_users")
+	if all, err := users.All(); err != nil || len(all) != 3 {
+		t.Errorf("Error, wrong elements: %v %v", all, err)
+	}
+	list = sp.NewList("abc123_test_list")
+	if all, err := list.All(); err != nil || len(all) != 1 {
+		t.Errorf("Error, wrong elements: %v %v", all, err)
+	}
+}
@@ -2,9 +2,11 @@
 package simpleredis
 
 import (
+	"context"
 	"errors"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -23,10 +25,15 @@ type redisDatastructure struct {
 	pool    *ConnectionPool
 	id      string
 	dbindex int
+	// The context used for all operations, may be nil
+	ctx context.Context
 }
 
 type (
-	// A pool of readily available Redis connections
+	// A pool of readily available Redis connections. The pools that are
+	// created with the NewConnectionPool functions have state that is kept
+	// outside of the redis.Pool, and goroutines for some features, which are
+	// only released when Close is called.
 	ConnectionPool redis.Pool
 
 	List     redisDatastructure
@@ -36,6 +43,9 @@ type (
 )
 
 var (
+	// Protects the default settings below, that can be changed with the setters
+	defaultsMutex sync.RWMutex
+
 	// Timeout settings for new connections
 	connectTimeout = 7 * time.Second
 	readTimeout    = 7 * time.Second
@@ -46,6 +56,11 @@ var (
 	// When an idle connection is used, new idle connections are created.
 	maxIdleConnections = 3
 
+	// The COUNT hint for SCAN, when iterating over keys
+	scanCount = 1024
+
+	// ErrNotFound is returned when a key or an element does not exist.
+	// Use errors.Is(err, ErrNotFound) to check for it.
 	ErrNotFound = errors.New("not found")
 )
 
@@ -57,14 +72,15 @@ func newRedisConnection() (redis.Conn, error) {
 }
 
 // Connect to host:port, host may be omitted, so ":6379" is valid.
+// A path to a unix domain socket may also be given.
 // Will not try to AUTH with any given password (password@host:port).
 func newRedisConnectionTo(hostColonPort string) (redis.Conn, error) {
 	// Discard the password, if provided
-	if _, theRest, ok := twoFields(hostColonPort, "@"); ok {
+	if _, theRest, ok := passwordAndHost(hostColonPort); ok {
 		hostColonPort = theRest
 	}
-	hostColonPort = strings.TrimSpace(hostColonPort)
-	c, err := redis.Dial("tcp", hostColonPort, redis.DialConnectTimeout(connectTimeout), redis.DialReadTimeout(readTimeout), redis.DialWriteTimeout(writeTimeout))
+	network, address := networkAndAddress(hostColonPort)
+	c, err := redis.Dial(network, address, redis.DialConnectTimeout(ConnectTimeout()), redis.DialReadTimeout(ReadTimeout()), redis.DialWriteTimeout(WriteTimeout()))
 	if err != nil {
 		if c != nil {
 			c.Close()
@@ -74,17 +90,13 @@ func newRedisConnectionTo(hostColonPort string) (redis.Conn, error) {
 	return c, nil
 }
 
-// Get a string from a list of results at a given position
-func getString(bi []interface{}, i int) string {
-	return string(bi[i].([]uint8))
-}
-
 // Test if the local Redis server is up and running
 func TestConnection() (err error) {
 	return TestConnectionHost(defaultRedisServer)
 }
 
 // Test if a given Redis server at host:port is up and running.
+// The host:port may also be a path to a unix domain socket.
 // Does not try to PING or AUTH.
 func TestConnectionHost(hostColonPort string) (err error) {
 	// Connect to the given host:port
@@ -102,10 +114,51 @@ func TestConnectionHost(hostColonPort string) (err error) {
 
 /* --- ConnectionPool functions --- */
 
-func copyPoolValues(src *redis.Pool) ConnectionPool {
-	return ConnectionPool{
-		Dial:            src.Dial,
-		DialContext:     src.DialContext,
+// The state of a connection pool that is not kept in the redis.Pool
+type poolState struct {
+	// The database index used by data structures created with this pool
+	dbindex int
+	// The COUNT hint for SCAN, or 0 for the default
+	scanCount int
+	// Per-field expiry for hash maps
+	expiry fieldExpiry
+	// The registered Lua scripts
+	scripts scriptRegistry
+	// The slot map, for Redis Cluster
+	cluster *cluster
+	// The failover list, for NewConnectionPoolHosts
+	hosts *hostList
+	// The replicas, for pools where reads are sent to replicas
+	replicas *replicaSet
+}
+
+// The state of each connection pool, until the pool is closed
+var poolStates sync.Map
+
+// Get the state of the connection pool, for reading it. If the pool has no
+// state, like a pool that has been closed, an empty state is returned.
+func (pool *ConnectionPool) state() *poolState {
+	if state, ok := poolStates.Load(pool); ok {
+		return state.(*poolState)
+	}
+	return &poolState{}
+}
+
+// Get the state of the connection pool, for changing it. The state is created
+// the first time, so that a ConnectionPool that is created directly from a
+// redis.Pool can also be used. Apart from when creating a pool, this is only
+// used after a reply from the server, so that the state is not created again
+// for a pool that has been closed.
+func (pool *ConnectionPool) mutableState() *poolState {
+	if state, ok := poolStates.Load(pool); ok {
+		return state.(*poolState)
+	}
+	state, _ := poolStates.LoadOrStore(pool, &poolState{})
+	return state.(*poolState)
+}
+
+func copyPoolValues(src *redis.Pool) *ConnectionPool {
+	pool := &ConnectionPool{
 		TestOnBorrow:    src.TestOnBorrow,
 		MaxIdle:         src.MaxIdle,
 		MaxActive:       src.MaxActive,
@@ -113,19 +166,23 @@ func copyPoolValues(src *redis.Pool) ConnectionPool {
 		Wait:            src.Wait,
 		MaxConnLifetime: src.MaxConnLifetime,
 	}
+	scripts := &pool.mutableState().scripts
+	pool.Dial = scripts.wrapDial(src.Dial)
+	pool.DialContext = scripts.wrapDialContext(src.DialContext)
+	return pool
 }
 
-// Create a new connection pool
+// Create a new connection pool.
+// Close must be called when the pool is no longer needed.
 func NewConnectionPool() *ConnectionPool {
 	// The second argument is the maximum number of idle connections
 	redisPool := &redis.Pool{
-		MaxIdle:     maxIdleConnections,
-		IdleTimeout: idleTimeout,
+		MaxIdle:     MaxIdleConnections(),
+		IdleTimeout: IdleTimeout(),
 		Dial:        newRedisConnection,
 	}
 
-	pool := copyPoolValues(redisPool)
-	return &pool
+	return copyPoolValues(redisPool)
 }
 
 // Split a string into two parts, given a delimiter.
@@ -138,34 +195,64 @@ func twoFields(s, delim string) (string, string, bool) {
 	return fields[0], fields[1], true
 }
 
+// Split a "password@host:port" string into the password and the host:port.
+// The last "@" is used as the delimiter, so that the password may contain "@".
+// Returns the two parts and true if a password was given.
+func passwordAndHost(s string) (string, string, bool) {
+	pos := strings.LastIndex(s, "@")
+	if pos == -1 {
+		return "", s, false
+	}
+	return s[:pos], s[pos+1:], true
+}
+
+// Find the network and address to dial, given a host:port string or the
+// path to a unix domain socket, like "/var/run/redis/redis.sock".
+// A "unix:" prefix may also be used for the socket path.
+func networkAndAddress(hostColonPort string) (string, string) {
+	address := strings.TrimSpace(hostColonPort)
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		return "unix", strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//")
+	case strings.HasPrefix(address, "/"), strings.HasSuffix(address, ".sock"):
+		return "unix", address
+	}
+	return "tcp", address
+}
+
 // Create a new connection pool given a host:port string.
 // A password may be supplied as well, on the form "password@host:port".
+// The host:port may also be a path to a unix domain socket.
+// Close must be called when the pool is no longer needed.
 func NewConnectionPoolHost(hostColonPort string) *ConnectionPool {
 	// Create a redis Pool
 	redisPool := &redis.Pool{
 		// Maximum number of idle connections to the redis database
-		MaxIdle:     maxIdleConnections,
-		IdleTimeout: idleTimeout,
-		// Anonymous function for calling new RedisConnectionTo with the host:port
+		MaxIdle:     MaxIdleConnections(),
+		IdleTimeout: IdleTimeout(),
+		// Anonymous function for calling dialHost with the host:port
 		Dial: func() (redis.Conn, error) {
-			conn, err := newRedisConnectionTo(hostColonPort)
-			if err != nil {
-				return nil, err
-			}
-			// If a password is given, use it to authenticate
-			if password, _, ok := twoFields(hostColonPort, "@"); ok {
-				if password != "" {
-					if _, err := conn.Do("AUTH", password); err != nil {
-						conn.Close()
-						return nil, err
-					}
-				}
-			}
-			return conn, err
+			return dialHost(hostColonPort)
 		},
 	}
-	pool := copyPoolValues(redisPool)
-	return &pool
+	return copyPoolValues(redisPool)
+}
+
+// Connect to the given host:port, and authenticate if a password is given,
+// on the form "password@host:port"
+func dialHost(hostColonPort string) (redis.Conn, error) {
+	conn, err := newRedisConnectionTo(hostColonPort)
+	if err != nil {
+		return nil, err
+	}
+	// If a password is given, use it to authenticate
+	if password, _, ok := passwordAndHost(hostColonPort); ok {
+		if err := authenticate(conn, "", password, false); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
 }
 
 // Set the number of maximum *idle* connections standing ready when
@@ -173,40 +260,80 @@ func NewConnectionPoolHost(hostColonPort string) *ConnectionPool {
 // a new idle connection is created. The default is 3 and should be fine
 // for most cases.
 func SetMaxIdleConnections(maximum int) {
+	defaultsMutex.Lock()
 	maxIdleConnections = maximum
+	defaultsMutex.Unlock()
+}
+
+// MaxIdleConnections returns the current maximum number of idle connections
+// for new connection pools
+func MaxIdleConnections() int {
+	defaultsMutex.RLock()
+	defer defaultsMutex.RUnlock()
+	return maxIdleConnections
+}
+
+// DatabaseIndex returns the database index that is used by default
+// for data structures created with this connection pool
+func (pool *ConnectionPool) DatabaseIndex() int {
+	return pool.state().dbindex
 }
 
-// Get one of the available connections from the connection pool, given a database index
+// Get one of the available connections from the connection pool, given a database index.
+// The connection must be closed after use, to return it to the pool.
 func (pool *ConnectionPool) Get(dbindex int) redis.Conn {
 	redisPool := (*redis.Pool)(pool)
 	conn := redisPool.Get()
-	// The default database index is 0
-	if dbindex != 0 {
+	// Connections in the pool have selected the default database index
+	if defaultIndex := pool.state().dbindex; dbindex != defaultIndex {
 		// SELECT is not critical, ignore the return values
 		conn.Do("SELECT", strconv.Itoa(dbindex))
+		return &selectedConn{conn, defaultIndex}
 	}
 	return conn
 }
 
+// A connection where another database index than the default has been
+// selected. The default database index is selected again when the
+// connection is returned to the pool.
+type selectedConn struct {
+	redis.Conn
+	dbindex int
+}
+
+// Close selects the default database index and returns the connection to the pool
+func (c *selectedConn) Close() error {
+	// The reply is received when the connection is closed
+	c.Conn.Send("SELECT", strconv.Itoa(c.dbindex))
+	return c.Conn.Close()
+}
+
 // Ping the server by sending a PING command
 func (pool *ConnectionPool) Ping() error {
 	redisPool := (*redis.Pool)(pool)
 	conn := redisPool.Get()
+	defer conn.Close()
 	_, err := conn.Do("PING")
-	return err
+	return wrapError("PING", "", err)
 }
 
-// Close down the connection pool
+// Close down the connection pool, and release the state and the goroutines of
+// the pool. The pool can not be used after it has been closed.
 func (pool *ConnectionPool) Close() {
+	pool.stopReaper()
+	if hosts := pool.state().hosts; hosts != nil {
+		hosts.stopProbing()
+	}
 	redisPool := (*redis.Pool)(pool)
 	redisPool.Close()
+	poolStates.Delete(pool)
 }
 
 /* --- List functions --- */
 
 // Create a new list
 func NewList(pool *ConnectionPool, id string) *List {
-	return &List{pool, id, 0}
+	return &List{pool: pool, id: pool.structureID(id), dbindex: pool.state().dbindex}
 }
 
 // Select a different database
@@ -216,56 +343,50 @@ func (rl *List) SelectDatabase(dbindex int) {
 
 // Returns the element at index index in the list
 func (rl *List) Get(index int64) (string, error) {
-	conn := rl.pool.Get(rl.dbindex)
-	result, err := conn.Do("LINDEX", rl.id, index)
-	if err != nil {
-		panic(err)
-	}
-	return redis.String(result, err)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
+	result, err := redis.String(conn.Do("LINDEX", rl.id, index))
+	return result, wrapError("LINDEX", rl.id, err)
 }
 
 // Get the size of the list
 func (rl *List) Size() (int64, error) {
-	conn := rl.pool.Get(rl.dbindex)
-	size, err := conn.Do("LLEN", rl.id)
-	if err != nil {
-		panic(err)
-	}
-	return redis.Int64(size, err)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
+	size, err := redis.Int64(conn.Do("LLEN", rl.id))
+	return size, wrapError("LLEN", rl.id, err)
 }
 
 // Removes and returns the first element of the list
 func (rl *List) PopFirst() (string, error) {
-	conn := rl.pool.Get(rl.dbindex)
-	result, err := conn.Do("LPOP", rl.id)
-	if err != nil {
-		panic(err)
-	}
-	return redis.String(result, err)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
+	result, err := redis.String(conn.Do("LPOP", rl.id))
+	return result, wrapError("LPOP", rl.id, err)
 }
 
 // Removes and returns the last element of the list
 func (rl *List) PopLast() (string, error) {
-	conn := rl.pool.Get(rl.dbindex)
-	result, err := conn.Do("LPOP", rl.id)
-	if err != nil {
-		panic(err)
-	}
-	return redis.String(result, err)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
+	result, err := redis.String(conn.Do("LPOP", rl.id))
+	return result, wrapError("LPOP", rl.id, err)
 }
 
 // Add an element to the start of the list
 func (rl *List) AddStart(value string) error {
-	conn := rl.pool.Get(rl.dbindex)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("RPUSH", rl.id, value)
-	return err
+	return wrapError("RPUSH", rl.id, err)
 }
 
 // Add an element to the end of the list list
 func (rl *List) AddEnd(value string) error {
-	conn := rl.pool.Get(rl.dbindex)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("LPUSH", rl.id, value)
-	return err
+	return wrapError("LPUSH", rl.id, err)
 }
 
 // Default Add, aliased to List.AddStart
@@ -275,13 +396,10 @@ func (rl *List) Add(value string) error {
 
 // Get all elements of a list
 func (rl *List) All() ([]string, error) {
-	conn := rl.pool.Get(rl.dbindex)
-	result, err := redis.Values(conn.Do("LRANGE", rl.id, "0", "-1"))
-	strs := make([]string, len(result))
-	for i := 0; i < len(result); i++ {
-		strs[i] = getString(result, i)
-	}
-	return strs, err
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
+	strs, err := redis.Strings(conn.Do("LRANGE", rl.id, "0", "-1"))
+	return strs, wrapError("LRANGE", rl.id, err)
 }
 
 // Deprecated
@@ -291,12 +409,13 @@ func (rl *List) GetAll() ([]string, error) {
 
 // Get the last element of a list
 func (rl *List) Last() (string, error) {
-	conn := rl.pool.Get(rl.dbindex)
-	result, err := redis.Values(conn.Do("LRANGE", rl.id, "-1", "-1"))
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
+	result, err := redis.Strings(conn.Do("LRANGE", rl.id, "-1", "-1"))
 	if len(result) == 1 {
-		return getString(result, 0), err
+		return result[0], wrapError("LRANGE", rl.id, err)
 	}
-	return "", err
+	return "", wrapError("LRANGE", rl.id, err)
 }
 
 // Deprecated
@@ -306,13 +425,10 @@ func (rl *List) GetLast() (string, error) {
 
 // Get the last N elements of a list
 func (rl *List) LastN(n int) ([]string, error) {
-	conn := rl.pool.Get(rl.dbindex)
-	result, err := redis.Values(conn.Do("LRANGE", rl.id, "-"+strconv.Itoa(n), "-1"))
-	strs := make([]string, len(result))
-	for i := 0; i < len(result); i++ {
-		strs[i] = getString(result, i)
-	}
-	return strs, err
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
+	strs, err := redis.Strings(conn.Do("LRANGE", rl.id, "-"+strconv.Itoa(n), "-1"))
+	return strs, wrapError("LRANGE", rl.id, err)
 }
 
 // Deprecated
@@ -322,31 +438,35 @@ func (rl *List) GetLastN(n int) ([]string, error) {
 
 // Remove the first occurrence of an element from the list
 func (rl *List) RemoveElement(value string) error {
-	conn := rl.pool.Get(rl.dbindex)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("LREM", rl.id, value)
-	return err
+	return wrapError("LREM", rl.id, err)
 }
 
 // Set element of list at index n to value
 func (rl *List) Set(index int64, value string) error {
-	conn := rl.pool.Get(rl.dbindex)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("LSET", rl.id, index, value)
-	return err
+	return wrapError("LSET", rl.id, err)
 }
 
 // Trim an existing list so that it will contain only the specified range of
 // elements specified.
 func (rl *List) Trim(start, stop int64) error {
-	conn := rl.pool.Get(rl.dbindex)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("LTRIM", rl.id, start, stop)
-	return err
+	return wrapError("LTRIM", rl.id, err)
 }
 
 // Remove this list
 func (rl *List) Remove() error {
-	conn := rl.pool.Get(rl.dbindex)
+	conn := rl.pool.get(rl.ctx, rl.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("DEL", rl.id)
-	return err
+	return wrapError("DEL", rl.id, err)
 }
 
 // Clear the contents
@@ -358,7 +478,7 @@ func (rl *List) Clear() error {
 
 // Create a new set
 func NewSet(pool *ConnectionPool, id string) *Set {
-	return &Set{pool, id, 0}
+	return &Set{pool: pool, id: pool.structureID(id), dbindex: pool.state().dbindex}
 }
 
 // Select a different database
@@ -368,40 +488,34 @@ func (rs *Set) SelectDatabase(dbindex int) {
 
 // Add an element to the set
 func (rs *Set) Add(value string) error {
-	conn := rs.pool.Get(rs.dbindex)
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("SADD", rs.id, value)
-	return err
+	return wrapError("SADD", rs.id, err)
 }
 
 // Returns the set cardinality (number of elements) of the set
 func (rs *Set) Size() (int64, error) {
-	conn := rs.pool.Get(rs.dbindex)
-	size, err := conn.Do("SCARD", rs.id)
-	if err != nil {
-		panic(err)
-	}
-	return redis.Int64(size, err)
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	size, err := redis.Int64(conn.Do("SCARD", rs.id))
+	return size, wrapError("SCARD", rs.id, err)
 }
 
 // Check if a given value is in the set
 func (rs *Set) Has(value string) (bool, error) {
-	conn := rs.pool.Get(rs.dbindex)
-	retval, err := conn.Do("SISMEMBER", rs.id, value)
-	if err != nil {
-		panic(err)
-	}
-	return redis.Bool(retval, err)
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	retval, err := redis.Bool(conn.Do("SISMEMBER", rs.id, value))
+	return retval, wrapError("SISMEMBER", rs.id, err)
 }
 
 // Get all elements of the set
 func (rs *Set) All() ([]string, error) {
-	conn := rs.pool.Get(rs.dbindex)
-	result, err := redis.Values(conn.Do("SMEMBERS", rs.id))
-	strs := make([]string, len(result))
-	for i := 0; i < len(result); i++ {
-		strs[i] = getString(result, i)
-	}
-	return strs, err
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	strs, err := redis.Strings(conn.Do("SMEMBERS", rs.id))
+	return strs, wrapError("SMEMBERS", rs.id, err)
 }
 
 // Deprecated
@@ -411,36 +525,34 @@ func (rs *Set) GetAll() ([]string, error) {
 
 // Remove a random member from the set
 func (rs *Set) Pop() (string, error) {
-	conn := rs.pool.Get(rs.dbindex)
-	result, err := conn.Do("SPOP", rs.id)
-	if err != nil {
-		panic(err)
-	}
-	return redis.String(result, err)
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	result, err := redis.String(conn.Do("SPOP", rs.id))
+	return result, wrapError("SPOP", rs.id, err)
 }
 
 // Get a random member of the set
 func (rs *Set) Random() (string, error) {
-	conn := rs.pool.Get(rs.dbindex)
-	result, err := conn.Do("SRANDMEMBER", rs.id)
-	if err != nil {
-		panic(err)
-	}
-	return redis.String(result, err)
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	result, err := redis.String(conn.Do("SRANDMEMBER", rs.id))
+	return result, wrapError("SRANDMEMBER", rs.id, err)
 }
 
 // Remove an element from the set
 func (rs *Set) Del(value string) error {
-	conn := rs.pool.Get(rs.dbindex)
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("SREM", rs.id, value)
-	return err
+	return wrapError("SREM", rs.id, err)
 }
 
 // Remove this set
 func (rs *Set) Remove() error {
-	conn := rs.pool.Get(rs.dbindex)
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("DEL", rs.id)
-	return err
+	return wrapError("DEL", rs.id, err)
 }
 
 // Clear the contents
@@ -452,7 +564,7 @@ func (rs *Set) Clear() error {
 
 // Create a new hashmap
 func NewHashMap(pool *ConnectionPool, id string) *HashMap {
-	return &HashMap{pool, id, 0}
+	return &HashMap{pool: pool, id: pool.structureID(id), dbindex: pool.state().dbindex}
 }
 
 // Select a different database
@@ -462,92 +574,104 @@ func (rh *HashMap) SelectDatabase(dbindex int) {
 
 // Set a value in a hashmap given the element id (for instance a user id) and the key (for instance "password")
 func (rh *HashMap) Set(elementid, key, value string) error {
-	conn := rh.pool.Get(rh.dbindex)
-	_, err := conn.Do("HSET", rh.id+":"+elementid, key, value)
-	return err
-}
-
-// Given an element id, set a key and a value together with an expiration time
-func (rh *HashMap) SetExpire(elementid, key, value string, expire time.Duration) error {
-	conn := rh.pool.Get(rh.dbindex)
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
 	if _, err := conn.Do("HSET", rh.id+":"+elementid, key, value); err != nil {
-		return err
+		return wrapError("HSET", rh.id+":"+elementid, err)
 	}
-	// No EXPIRE in Redis for hash keys, as far as I can tell from the documentation.
-	// This is the manual way.
-	go func() {
-		time.Sleep(expire)
-		rh.DelKey(elementid, key)
-	}()
-	// Set the elementid to expire in the given duration (as milliseconds)
-	//expireMilliseconds := expire.Nanoseconds() / 1000000
-	//if _, err := conn.Do("PEXPIRE", rh.id+":"+elementid, expireMilliseconds); err != nil {
-	//	return err
-	//}
-	return nil
+	return rh.clearExpiry(conn, elementid, key)
 }
 
-// Commented out because this would only return TTL for the elementid, not for the key
-// TimeToLive returns how long a key has to live until it expires
-// Returns a duration of 0 when the time has passed
-//func (rh *HashMap) TimeToLive(elementid string) (time.Duration, error) {
-//	conn := rh.pool.Get(rh.dbindex)
-//	ttlSecondsInterface, err := conn.Do("TTL", rh.id+":"+elementid)
-//	if err != nil || ttlSecondsInterface.(int64) <= 0 {
-//		return time.Duration(0), err
-//	}
-//	ns := time.Duration(ttlSecondsInterface.(int64)) * time.Second
-//	return ns, nil
-//}
-
 // Get a value from a hashmap given the element id (for instance a user id) and the key (for instance "password")
 func (rh *HashMap) Get(elementid, key string) (string, error) {
-	conn := rh.pool.Get(rh.dbindex)
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
 	result, err := redis.String(conn.Do("HGET", rh.id+":"+elementid, key))
+	if err != nil {
+		return "", wrapError("HGET", rh.id+":"+elementid, err)
+	}
+	expired, err := rh.expired(conn, elementid, key)
 	if err != nil {
 		return "", err
 	}
+	if expired[0] {
+		return "", wrapError("HGET", rh.id+":"+elementid, redis.ErrNil)
+	}
 	return result, nil
 }
 
 // Check if a given elementid + key is in the hash map
 func (rh *HashMap) Has(elementid, key string) (bool, error) {
-	conn := rh.pool.Get(rh.dbindex)
-	retval, err := conn.Do("HEXISTS", rh.id+":"+elementid, key)
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	retval, err := redis.Bool(conn.Do("HEXISTS", rh.id+":"+elementid, key))
+	if err != nil || !retval {
+		return retval, wrapError("HEXISTS", rh.id+":"+elementid, err)
+	}
+	expired, err := rh.expired(conn, elementid, key)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
-	return redis.Bool(retval, err)
+	return !expired[0], nil
 }
 
 // Keys returns the keys of the given elementid.
 func (rh *HashMap) Keys(elementid string) ([]string, error) {
-	conn := rh.pool.Get(rh.dbindex)
-	result, err := redis.Values(conn.Do("HKEYS", rh.id+":"+elementid))
-	strs := make([]string, len(result))
-	for i := 0; i < len(result); i++ {
-		strs[i] = getString(result, i)
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	strs, err := redis.Strings(conn.Do("HKEYS", rh.id+":"+elementid))
+	if err != nil {
+		return strs, wrapError("HKEYS", rh.id+":"+elementid, err)
+	}
+	expired, err := rh.expired(conn, elementid, strs...)
+	if err != nil {
+		return nil, err
 	}
-	return strs, err
+	keys := strs[:0]
+	for i, key := range strs {
+		if !expired[i] {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
 }
 
 // Check if a given elementid exists as a hash map at all
 func (rh *HashMap) Exists(elementid string) (bool, error) {
-	// TODO: key is not meant to be a wildcard, check for "*"
-	return hasKey(rh.pool, rh.id+":"+elementid, rh.dbindex)
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	exists, err := redis.Bool(conn.Do("EXISTS", rh.id+":"+elementid))
+	return exists, wrapError("EXISTS", rh.id+":"+elementid, err)
 }
 
 // Get all elementid's for all hash elements
 func (rh *HashMap) All() ([]string, error) {
-	conn := rh.pool.Get(rh.dbindex)
-	result, err := redis.Values(conn.Do("KEYS", rh.id+":*"))
-	strs := make([]string, len(result))
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	if rh.hasExpiryIndex(conn, "") {
+		// Remove the fields that have expired, so that elements where all
+		// fields have expired are not included
+		if _, err := rh.pool.removeExpiredFields(conn, rh.id); err != nil {
+			return nil, err
+		}
+	}
+	strs := []string{}
+	// SCAN may return the same key more than once
+	seen := make(map[string]bool)
 	idlen := len(rh.id)
-	for i := 0; i < len(result); i++ {
-		strs[i] = getString(result, i)[idlen+1:]
+	err := scanKeys(conn, escapePattern(rh.id)+":*", rh.pool.scanCountHint(), func(keys []string) error {
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				strs = append(strs, key[idlen+1:])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return strs, err
-
+	return strs, nil
 }
 
 // Deprecated
@@ -558,75 +682,77 @@ func (rh *HashMap) GetAll() ([]string, error) {
 // FindIDByFieldValue searches for an element ID (e.g., username) where the specified field has the specified value.
 // It returns the element ID if found, or an error if not.
 func (rh *HashMap) FindIDByFieldValue(field, value string) (string, error) {
-	conn := rh.pool.Get(rh.dbindex)
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
 	defer conn.Close()
 
-	var cursor int64 = 0
-	pattern := rh.id + ":*"
+	elementID, found := "", false
+	pattern := escapePattern(rh.id) + ":*"
 
-	for {
-		// Use SCAN to iterate over keys matching the pattern
-		res, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1024))
-		if err != nil {
-			return "", err
-		}
-		// Parse the SCAN response
-		var keys []string
-		_, err = redis.Scan(res, &cursor, &keys)
-		if err != nil {
-			return "", err
-		}
+	// Use SCAN to iterate over keys matching the pattern
+	err := scanKeys(conn, pattern, rh.pool.scanCountHint(), func(keys []string) error {
 		// Iterate over the keys
 		for _, key := range keys {
 			// Get the value of the specified field
 			val, err := redis.String(conn.Do("HGET", key, field))
 			if err != nil && err != redis.ErrNil {
-				return "", err
+				return wrapError("HGET", key, err)
 			}
 			if val == value {
 				// Extract the element ID from the key
-				elementID := strings.TrimPrefix(key, rh.id+":")
-				return elementID, nil
+				elementID, found = strings.TrimPrefix(key, rh.id+":"), true
+				return errStopScan
 			}
 		}
-		// If cursor is 0, the iteration is complete
-		if cursor == 0 {
-			break
-		}
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
-	return "", ErrNotFound
+	if !found {
+		return "", ErrNotFound
+	}
+	return elementID, nil
 }
 
 // Remove a key for an entry in a hashmap (for instance the email field for a user)
 func (rh *HashMap) DelKey(elementid, key string) error {
-	conn := rh.pool.Get(rh.dbindex)
-	_, err := conn.Do("HDEL", rh.id+":"+elementid, key)
-	return err
+	conn := rh.pool.get(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	if _, err := conn.Do("HDEL", rh.id+":"+elementid, key); err != nil {
+		return wrapError("HDEL", rh.id+":"+elementid, err)
+	}
+	return rh.clearExpiry(conn, elementid, key)
 }
 
 // Remove an element (for instance a user)
 func (rh *HashMap) Del(elementid string) error {
-	conn := rh.pool.Get(rh.dbindex)
-	_, err := conn.Do("DEL", rh.id+":"+elementid)
-	return err
+	conn := rh.pool.getPrimary(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	hashKey := rh.id + ":" + elementid
+	var keys []string
+	if rh.hasExpiryIndex(conn, elementid) {
+		// The keys that may have expiration times
+		var err error
+		if keys, err = redis.Strings(conn.Do("HKEYS", hashKey)); err != nil {
+			return wrapError("HKEYS", hashKey, err)
+		}
+	}
+	if _, err := conn.Do("DEL", hashKey); err != nil {
+		return wrapError("DEL", hashKey, err)
+	}
+	return rh.clearExpiry(conn, elementid, keys...)
 }
 
 // Remove this hashmap (all keys that starts with this hashmap id and a colon)
 func (rh *HashMap) Remove() error {
-	conn := rh.pool.Get(rh.dbindex)
-	// Find all hashmap keys that starts with rh.id+":"
-	results, err := redis.Values(conn.Do("KEYS", rh.id+":*"))
-	if err != nil {
+	conn := rh.pool.getPrimary(rh.ctx, rh.dbindex)
+	defer conn.Close()
+	// Delete all hashmap keys that starts with rh.id+":", in batches
+	if err := removeKeys(conn, escapePattern(rh.id)+":*", rh.pool.scanCountHint()); err != nil {
 		return err
 	}
-	// For each key id
-	for i := 0; i < len(results); i++ {
-		// Delete this key
-		if _, err = conn.Do("DEL", getString(results, i)); err != nil {
-			return err
-		}
-	}
-	return nil
+	// Delete the expiration times of the fields, if any
+	return unlinkKeys(conn, []string{expiryIndexKey(rh.id)})
 }
 
 // Clear the contents
@@ -638,7 +764,7 @@ func (rh *HashMap) Clear() error {
 
 // Create a new key/value
 func NewKeyValue(pool *ConnectionPool, id string) *KeyValue {
-	return &KeyValue{pool, id, 0}
+	return &KeyValue{pool: pool, id: pool.structureID(id), dbindex: pool.state().dbindex}
 }
 
 // Select a different database
@@ -648,78 +774,74 @@ func (rkv *KeyValue) SelectDatabase(dbindex int) {
 
 // Set a key and value
 func (rkv *KeyValue) Set(key, value string) error {
-	conn := rkv.pool.Get(rkv.dbindex)
+	conn := rkv.pool.get(rkv.ctx, rkv.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("SET", rkv.id+":"+key, value)
-	return err
+	return wrapError("SET", rkv.id+":"+key, err)
 }
 
 // Set a key and value, with expiry
 func (rkv *KeyValue) SetExpire(key, value string, expire time.Duration) error {
-	conn := rkv.pool.Get(rkv.dbindex)
+	conn := rkv.pool.get(rkv.ctx, rkv.dbindex)
+	defer conn.Close()
 	// Convert from nanoseconds to milliseconds
 	expireMilliseconds := expire.Nanoseconds() / 1000000
 	// Set the value, together with an expiry time, given in milliseconds
 	_, err := conn.Do("SET", rkv.id+":"+key, value, "PX", expireMilliseconds)
-	return err
+	return wrapError("SET", rkv.id+":"+key, err)
 }
 
 // TimeToLive returns how long a key has to live until it expires
 // Returns a duration of 0 when the time has passed
 func (rkv *KeyValue) TimeToLive(key string) (time.Duration, error) {
-	conn := rkv.pool.Get(rkv.dbindex)
-	ttlSecondsInterface, err := conn.Do("TTL", rkv.id+":"+key)
-	if err != nil || ttlSecondsInterface.(int64) <= 0 {
-		return time.Duration(0), err
+	conn := rkv.pool.get(rkv.ctx, rkv.dbindex)
+	defer conn.Close()
+	ttlSeconds, err := redis.Int64(conn.Do("TTL", rkv.id+":"+key))
+	if err != nil || ttlSeconds <= 0 {
+		return time.Duration(0), wrapError("TTL", rkv.id+":"+key, err)
 	}
-	ns := time.Duration(ttlSecondsInterface.(int64)) * time.Second
+	ns := time.Duration(ttlSeconds) * time.Second
 	return ns, nil
 }
 
 // Get a value given a key
 func (rkv *KeyValue) Get(key string) (string, error) {
-	conn := rkv.pool.Get(rkv.dbindex)
+	conn := rkv.pool.get(rkv.ctx, rkv.dbindex)
+	defer conn.Close()
 	result, err := redis.String(conn.Do("GET", rkv.id+":"+key))
 	if err != nil {
-		return "", err
+		return "", wrapError("GET", rkv.id+":"+key, err)
 	}
 	return result, nil
 }
 
 // Remove a key
 func (rkv *KeyValue) Del(key string) error {
-	conn := rkv.pool.Get(rkv.dbindex)
+	conn := rkv.pool.get(rkv.ctx, rkv.dbindex)
+	defer conn.Close()
 	_, err := conn.Do("DEL", rkv.id+":"+key)
-	return err
+	return wrapError("DEL", rkv.id+":"+key, err)
 }
 
 // Increase the value of a key, returns the new value
 // Returns an empty string if there were errors,
 // or "0" if the key does not already exist.
 func (rkv *KeyValue) Inc(key string) (string, error) {
-	conn := rkv.pool.Get(rkv.dbindex)
+	conn := rkv.pool.get(rkv.ctx, rkv.dbindex)
+	defer conn.Close()
 	result, err := redis.Int64(conn.Do("INCR", rkv.id+":"+key))
 	if err != nil {
-		return "0", err
+		return "0", wrapError("INCR", rkv.id+":"+key, err)
 	}
 	return strconv.FormatInt(result, 10), nil
 }
 
 // Remove this key/value
 func (rkv *KeyValue) Remove() error {
-	conn := rkv.pool.Get(rkv.dbindex)
-	// Find all keys that starts with rkv.id+":"
-	results, err := redis.Values(conn.Do("KEYS", rkv.id+":*"))
-	if err != nil {
-		return err
-	}
-	// For each key id
-	for i := 0; i < len(results); i++ {
-		// Delete this key
-		if _, err = conn.Do("DEL", getString(results, i)); err != nil {
-			return err
-		}
-	}
-	return nil
+	conn := rkv.pool.getPrimary(rkv.ctx, rkv.dbindex)
+	defer conn.Close()
+	// Delete all keys that starts with rkv.id+":", in batches
+	return removeKeys(conn, escapePattern(rkv.id)+":*", rkv.pool.scanCountHint())
 }
 
 // Clear the contents
@@ -730,53 +852,72 @@ func (rkv *KeyValue) Clear() error {
 // --- Generic redis functions ---
 
 // Check if a key exists. The key can be a wildcard (ie. "user*").
-func hasKey(pool *ConnectionPool, wildcard string, dbindex int) (bool, error) {
-	conn := pool.Get(dbindex)
-	result, err := redis.Values(conn.Do("KEYS", wildcard))
-	if err != nil {
-		return false, err
-	}
-	return len(result) > 0, nil
+// The keys are scanned until a match is found.
+func hasKey(ctx context.Context, pool *ConnectionPool, wildcard string, dbindex int) (bool, error) {
+	conn := pool.get(ctx, dbindex)
+	defer conn.Close()
+	found := false
+	err := scanKeys(conn, wildcard, pool.scanCountHint(), func(keys []string) error {
+		found = true
+		return errStopScan
+	})
+	return found, err
 }
 
 // --- Related to setting and retrieving timeout values
 
 // SetConnectTimeout sets the connect timeout for new connections
 func SetConnectTimeout(t time.Duration) {
+	defaultsMutex.Lock()
 	connectTimeout = t
+	defaultsMutex.Unlock()
 }
 
 // SetReadTimeout sets the read timeout for new connections
 func SetReadTimeout(t time.Duration) {
+	defaultsMutex.Lock()
 	readTimeout = t
+	defaultsMutex.Unlock()
 }
 
 // SetWriteTimeout sets the write timeout for new connections
 func SetWriteTimeout(t time.Duration) {
+	defaultsMutex.Lock()
 	writeTimeout = t
+	defaultsMutex.Unlock()
 }
 
 // SetIdleTimeout sets the idle timeout for new connections
 func SetIdleTimeout(t time.Duration) {
+	defaultsMutex.Lock()
 	idleTimeout = t
+	defaultsMutex.Unlock()
 }
 
 // ConnectTimeout returns the current connect timeout for new connections
 func ConnectTimeout() time.Duration {
+	defaultsMutex.RLock()
+	defer defaultsMutex.RUnlock()
 	return connectTimeout
 }
 
 // ReadTimeout returns the current read timeout for new connections
 func ReadTimeout() time.Duration {
+	defaultsMutex.RLock()
+	defer defaultsMutex.RUnlock()
 	return readTimeout
 }
 
 // WriteTimeout returns the current write timeout for new connections
 func WriteTimeout() time.Duration {
+	defaultsMutex.RLock()
+	defer defaultsMutex.RUnlock()
 	return writeTimeout
 }
 
 // IdleTimeout returns the current idle timeout for new connections
 func IdleTimeout() time.Duration {
+	defaultsMutex.RLock()
+	defer defaultsMutex.RUnlock()
 	return idleTimeout
 }
@@ -11,8 +11,11 @@ import (
 
 var pool *ConnectionPool
 
+// The [url]:port of the Redis server that is used by the tests
+var testHost string
+
 func TestLocalConnection(t *testing.T) {
-	if err := TestConnection(); err != nil {
+	if err := TestConnectionHost(testHost); err != nil {
 		if strings.HasSuffix(err.Error(), "i/o timeout") {
 			log.Println("Try the 'latency doctor' command in the redis-cli if I/O timeouts happens often.")
 		}
@@ -21,17 +24,17 @@ func TestLocalConnection(t *testing.T) {
 }
 
 func TestRemoteConnection(t *testing.T) {
-	if err := TestConnectionHost("foobared@ :6379"); err != nil {
+	if err := TestConnectionHost("foobared@" + testHost); err != nil {
 		t.Error(err)
 	}
 }
 
 func TestConnectionPool(t *testing.T) {
-	pool = NewConnectionPool()
+	pool = NewConnectionPoolHost(testHost)
 }
 
 func TestConnectionPoolHost(t *testing.T) {
-	pool = NewConnectionPoolHost("localhost:6379")
+	pool = NewConnectionPoolHost(testHost)
 }
 
 // Tests with password "foobared" if the previous connection test
@@ -39,7 +42,7 @@ func TestConnectionPoolHost(t *testing.T) {
 func TestConnectionPoolHostPassword(t *testing.T) {
 	if pool.Ping() != nil {
 		// Try connecting with the default password
-		pool = NewConnectionPoolHost("foobared@localhost:6379")
+		pool = NewConnectionPoolHost("foobared@" + testHost)
 	}
 }
 
@@ -0,0 +1,261 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// SortedSet is a set where each member has a score, and the members are
+// ordered by score. Useful for leaderboards and time ordered indexes.
+type SortedSet redisDatastructure
+
+// ScoredMember is a member of a sorted set, together with its score
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+/* --- SortedSet functions --- */
+
+// Create a new sorted set
+func NewSortedSet(pool *ConnectionPool, id string) *SortedSet {
+	return &SortedSet{pool: pool, id: pool.structureID(id), dbindex: pool.state().dbindex}
+}
+
+// Select a different database
+func (rz *SortedSet) SelectDatabase(dbindex int) {
+	rz.dbindex = dbindex
+}
+
+// WithContext returns a copy of the sorted set where all operations use the
+// given context, so that they are aborted when the context is done
+func (rz *SortedSet) WithContext(ctx context.Context) *SortedSet {
+	c := *rz
+	c.ctx = ctx
+	return &c
+}
+
+// Format a score for use in a score range.
+// Infinite scores are used for ranges without a lower or upper limit.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "+inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// Convert a reply with members and scores to a slice of ScoredMember
+func scoredMembers(reply interface{}, err error) ([]ScoredMember, error) {
+	values, err := redis.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ScoredMember, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ScoredMember{values[i], score})
+	}
+	return members, nil
+}
+
+// Add a member with the given score. If the member already exists, the score is updated.
+func (rz *SortedSet) Add(member string, score float64) error {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	_, err := conn.Do("ZADD", rz.id, formatScore(score), member)
+	return wrapError("ZADD", rz.id, err)
+}
+
+// Increase the score of a member, returns the new score.
+// If the member does not exist, it is added with the increment as the score.
+func (rz *SortedSet) IncrementScore(member string, increment float64) (float64, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	score, err := redis.Float64(conn.Do("ZINCRBY", rz.id, formatScore(increment), member))
+	return score, wrapError("ZINCRBY", rz.id, err)
+}
+
+// Get the score of a member
+func (rz *SortedSet) Score(member string) (float64, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	score, err := redis.Float64(conn.Do("ZSCORE", rz.id, member))
+	return score, wrapError("ZSCORE", rz.id, err)
+}
+
+// Check if a given member is in the sorted set
+func (rz *SortedSet) Has(member string) (bool, error) {
+	_, err := rz.Score(member)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Get the rank of a member, where the member with the lowest score has rank 0
+func (rz *SortedSet) Rank(member string) (int64, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	rank, err := redis.Int64(conn.Do("ZRANK", rz.id, member))
+	return rank, wrapError("ZRANK", rz.id, err)
+}
+
+// Get the reverse rank of a member, where the member with the highest score has rank 0
+func (rz *SortedSet) ReverseRank(member string) (int64, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	rank, err := redis.Int64(conn.Do("ZREVRANK", rz.id, member))
+	return rank, wrapError("ZREVRANK", rz.id, err)
+}
+
+// Returns the cardinality (number of members) of the sorted set
+func (rz *SortedSet) Size() (int64, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	size, err := redis.Int64(conn.Do("ZCARD", rz.id))
+	return size, wrapError("ZCARD", rz.id, err)
+}
+
+// Count the members with a score between min and max, inclusive
+func (rz *SortedSet) Count(min, max float64) (int64, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	count, err := redis.Int64(conn.Do("ZCOUNT", rz.id, formatScore(min), formatScore(max)))
+	return count, wrapError("ZCOUNT", rz.id, err)
+}
+
+// Get the members from rank start to rank stop, inclusive, ordered from the
+// lowest to the highest score. Negative ranks count from the end, so that
+// Range(0, -1) returns all members.
+func (rz *SortedSet) Range(start, stop int64) ([]string, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	members, err := redis.Strings(conn.Do("ZRANGE", rz.id, start, stop))
+	return members, wrapError("ZRANGE", rz.id, err)
+}
+
+// Get the members from rank start to rank stop, inclusive, ordered from the
+// highest to the lowest score
+func (rz *SortedSet) ReverseRange(start, stop int64) ([]string, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	members, err := redis.Strings(conn.Do("ZREVRANGE", rz.id, start, stop))
+	return members, wrapError("ZREVRANGE", rz.id, err)
+}
+
+// Get the members and scores from rank start to rank stop, inclusive,
+// ordered from the lowest to the highest score
+func (rz *SortedSet) RangeWithScores(start, stop int64) ([]ScoredMember, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	members, err := scoredMembers(conn.Do("ZRANGE", rz.id, start, stop, "WITHSCORES"))
+	return members, wrapError("ZRANGE", rz.id, err)
+}
+
+// Get the members and scores from rank start to rank stop, inclusive,
+// ordered from the highest to the lowest score
+func (rz *SortedSet) ReverseRangeWithScores(start, stop int64) ([]ScoredMember, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	members, err := scoredMembers(conn.Do("ZREVRANGE", rz.id, start, stop, "WITHSCORES"))
+	return members, wrapError("ZREVRANGE", rz.id, err)
+}
+
+// Arguments for ZRANGEBYSCORE and ZREVRANGEBYSCORE, with an optional limit
+func scoreRangeArgs(id string, from, to float64, offset, count int64, withScores bool) []interface{} {
+	args := []interface{}{id, formatScore(from), formatScore(to)}
+	if withScores {
+		args = append(args, "WITHSCORES")
+	}
+	if offset != 0 || count >= 0 {
+		args = append(args, "LIMIT", offset, count)
+	}
+	return args
+}
+
+// Get the members with a score between min and max, inclusive, ordered from
+// the lowest to the highest score. The first offset members are skipped, and
+// at most count members are returned. A negative count means no limit.
+// Use math.Inf(-1) and math.Inf(1) for ranges without a lower or upper limit.
+func (rz *SortedSet) RangeByScore(min, max float64, offset, count int64) ([]string, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	members, err := redis.Strings(conn.Do("ZRANGEBYSCORE", scoreRangeArgs(rz.id, min, max, offset, count, false)...))
+	return members, wrapError("ZRANGEBYSCORE", rz.id, err)
+}
+
+// Get the members with a score between max and min, inclusive, ordered from
+// the highest to the lowest score. The first offset members are skipped, and
+// at most count members are returned. A negative count means no limit.
+func (rz *SortedSet) ReverseRangeByScore(max, min float64, offset, count int64) ([]string, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	members, err := redis.Strings(conn.Do("ZREVRANGEBYSCORE", scoreRangeArgs(rz.id, max, min, offset, count, false)...))
+	return members, wrapError("ZREVRANGEBYSCORE", rz.id, err)
+}
+
+// Get the members and scores with a score between min and max, inclusive,
+// like RangeByScore
+func (rz *SortedSet) RangeByScoreWithScores(min, max float64, offset, count int64) ([]ScoredMember, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	members, err := scoredMembers(conn.Do("ZRANGEBYSCORE", scoreRangeArgs(rz.id, min, max, offset, count, true)...))
+	return members, wrapError("ZRANGEBYSCORE", rz.id, err)
+}
+
+// Get the members and scores with a score between max and min, inclusive,
+// like ReverseRangeByScore
+func (rz *SortedSet) ReverseRangeByScoreWithScores(max, min float64, offset, count int64) ([]ScoredMember, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	members, err := scoredMembers(conn.Do("ZREVRANGEBYSCORE", scoreRangeArgs(rz.id, max, min, offset, count, true)...))
+	return members, wrapError("ZREVRANGEBYSCORE", rz.id, err)
+}
+
+// Get all members of the sorted set, ordered from the lowest to the highest score
+func (rz *SortedSet) All() ([]string, error) {
+	return rz.Range(0, -1)
+}
+
+// Remove a member from the sorted set
+func (rz *SortedSet) Del(member string) error {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	_, err := conn.Do("ZREM", rz.id, member)
+	return wrapError("ZREM", rz.id, err)
+}
+
+// Remove all members with a score between min and max, inclusive.
+// Returns the number of removed members.
+func (rz *SortedSet) RemoveByScore(min, max float64) (int64, error) {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	removed, err := redis.Int64(conn.Do("ZREMRANGEBYSCORE", rz.id, formatScore(min), formatScore(max)))
+	return removed, wrapError("ZREMRANGEBYSCORE", rz.id, err)
+}
+
+// Remove this sorted set
+func (rz *SortedSet) Remove() error {
+	conn := rz.pool.get(rz.ctx, rz.dbindex)
+	defer conn.Close()
+	_, err := conn.Do("DEL", rz.id)
+	return wrapError("DEL", rz.id, err)
+}
+
+// Clear the contents
+func (rz *SortedSet) Clear() error {
+	return rz.Remove()
+}
@@ -0,0 +1,107 @@
+package simpleredis
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSortedSet(t *testing.T) {
+	const zsetname = "abc123_test_zset_123abc"
+	zsetPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer zsetPool.Close()
+
+	creator := NewCreator(zsetPool, 1)
+	zset, err := creator.NewSortedSet(zsetname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for member, score := range map[string]float64{"alice": 10, "bob": 20, "carol": 30, "dave": 40} {
+		if err := zset.Add(member, score); err != nil {
+			t.Fatalf("Error, could not add member! %s", err)
+		}
+	}
+	if size, err := zset.Size(); err != nil || size != 4 {
+		t.Errorf("Error, wrong size: %d %v", size, err)
+	}
+
+	// Scores
+	if score, err := zset.IncrementScore("alice", 25.5); err != nil || score != 35.5 {
+		t.Errorf("Error, wrong score after increment: %v %v", score, err)
+	}
+	if score, err := zset.Score("bob"); err != nil || score != 20 {
+		t.Errorf("Error, wrong score: %v %v", score, err)
+	}
+	if _, err := zset.Score("nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected a not found error, got: %v", err)
+	}
+	if has, err := zset.Has("carol"); err != nil || !has {
+		t.Errorf("Error, carol should be in the sorted set: %v", err)
+	}
+	if has, err := zset.Has("nobody"); err != nil || has {
+		t.Errorf("Error, nobody should not be in the sorted set: %v", err)
+	}
+
+	// Ranks, the order is now bob, carol, alice, dave
+	if rank, err := zset.Rank("alice"); err != nil || rank != 2 {
+		t.Errorf("Error, wrong rank: %d %v", rank, err)
+	}
+	if rank, err := zset.ReverseRank("dave"); err != nil || rank != 0 {
+		t.Errorf("Error, wrong reverse rank: %d %v", rank, err)
+	}
+	if _, err := zset.Rank("nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected a not found error, got: %v", err)
+	}
+
+	// Ranges by rank
+	if members, err := zset.All(); err != nil || !reflect.DeepEqual(members, []string{"bob", "carol", "alice", "dave"}) {
+		t.Errorf("Error, wrong members: %v %v", members, err)
+	}
+	if members, err := zset.ReverseRange(0, 1); err != nil || !reflect.DeepEqual(members, []string{"dave", "alice"}) {
+		t.Errorf("Error, wrong members in reverse: %v %v", members, err)
+	}
+	if members, err := zset.RangeWithScores(0, 0); err != nil || !reflect.DeepEqual(members, []ScoredMember{{"bob", 20}}) {
+		t.Errorf("Error, wrong members with scores: %v %v", members, err)
+	}
+	if members, err := zset.ReverseRangeWithScores(0, 0); err != nil || !reflect.DeepEqual(members, []ScoredMember{{"dave", 40}}) {
+		t.Errorf("Error, wrong members with scores in reverse: %v %v", members, err)
+	}
+
+	// Ranges by score, with limits
+	if members, err := zset.RangeByScore(20, 35.5, 0, -1); err != nil || !reflect.DeepEqual(members, []string{"bob", "carol", "alice"}) {
+		t.Errorf("Error, wrong members by score: %v %v", members, err)
+	}
+	if members, err := zset.RangeByScore(math.Inf(-1), math.Inf(1), 1, 2); err != nil || !reflect.DeepEqual(members, []string{"carol", "alice"}) {
+		t.Errorf("Error, wrong members by score with a limit: %v %v", members, err)
+	}
+	if members, err := zset.ReverseRangeByScore(math.Inf(1), 30, 0, 2); err != nil || !reflect.DeepEqual(members, []string{"dave", "alice"}) {
+		t.Errorf("Error, wrong members by score in reverse: %v %v", members, err)
+	}
+	if members, err := zset.RangeByScoreWithScores(30, 40, 0, 1); err != nil || !reflect.DeepEqual(members, []ScoredMember{{"carol", 30}}) {
+		t.Errorf("Error, wrong members by score with scores: %v %v", members, err)
+	}
+	if members, err := zset.ReverseRangeByScoreWithScores(40, 0, 1, -1); err != nil || len(members) != 3 || members[0] != (ScoredMember{"alice", 35.5}) {
+		t.Errorf("Error, wrong members by score with scores in reverse: %v %v", members, err)
+	}
+	if count, err := zset.Count(20, 30); err != nil || count != 2 {
+		t.Errorf("Error, wrong count: %d %v", count, err)
+	}
+
+	// Removing
+	if removed, err := zset.RemoveByScore(35, math.Inf(1)); err != nil || removed != 2 {
+		t.Errorf("Error, wrong number of removed members: %d %v", removed, err)
+	}
+	if err := zset.Del("bob"); err != nil {
+		t.Errorf("Error, could not remove member! %s", err)
+	}
+	if members, err := zset.All(); err != nil || !reflect.DeepEqual(members, []string{"carol"}) {
+		t.Errorf("Error, wrong members after removing: %v %v", members, err)
+	}
+	if err := zset.Remove(); err != nil {
+		t.Errorf("Error, could not remove sorted set! %s", err)
+	}
+	if size, err := zset.Size(); err != nil || size != 0 {
+		t.Errorf("Error, the sorted set should be empty: %d %v", size, err)
+	}
+}
@@ -0,0 +1,377 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Stream is an append-only log of entries, where each entry has an ID and
+// a number of fields and values. Consumer groups can be used for
+// distributing the entries among several consumers.
+type Stream redisDatastructure
+
+// StreamEntry is an entry in a stream
+type StreamEntry struct {
+	ID     string
+	Fields map[string]string
+}
+
+// PendingEntry is an entry that has been delivered to a consumer in a
+// consumer group, but that has not been acknowledged yet
+type PendingEntry struct {
+	ID       string
+	Consumer string
+	// The time since the entry was last delivered
+	Idle time.Duration
+	// The number of times the entry has been delivered
+	Deliveries int64
+}
+
+// Blocking reads are split into reads that block for at most this duration,
+// so that each read finishes before the read timeout of the connection
+const streamBlockInterval = time.Second
+
+/* --- Stream functions --- */
+
+// Create a new stream
+func NewStream(pool *ConnectionPool, id string) *Stream {
+	return &Stream{pool: pool, id: pool.structureID(id), dbindex: pool.state().dbindex}
+}
+
+// Select a different database
+func (rs *Stream) SelectDatabase(dbindex int) {
+	rs.dbindex = dbindex
+}
+
+// WithContext returns a copy of the stream where all operations use the
+// given context, so that they are aborted when the context is done
+func (rs *Stream) WithContext(ctx context.Context) *Stream {
+	c := *rs
+	c.ctx = ctx
+	return &c
+}
+
+// Convert a list of entries, as returned by XRANGE, to a slice of StreamEntry
+func streamEntries(reply interface{}, err error) ([]StreamEntry, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StreamEntry, 0, len(values))
+	for _, value := range values {
+		entry, err := redis.Values(value, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry) != 2 {
+			return nil, errors.New("unexpected stream entry in reply")
+		}
+		id, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		// The fields are nil for entries that have been deleted
+		fields, err := redis.StringMap(entry[1], nil)
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		entries = append(entries, StreamEntry{id, fields})
+	}
+	return entries, nil
+}
+
+// Convert the reply from XREAD or XREADGROUP for a single stream to a slice of StreamEntry
+func readEntries(reply interface{}, err error) ([]StreamEntry, error) {
+	streams, err := redis.Values(reply, err)
+	if err != nil {
+		if err == redis.ErrNil {
+			// No new entries
+			return []StreamEntry{}, nil
+		}
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return []StreamEntry{}, nil
+	}
+	stream, err := redis.Values(streams[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(stream) != 2 {
+		return nil, errors.New("unexpected stream in reply")
+	}
+	return streamEntries(stream[1], nil)
+}
+
+// Arguments for XADD, given the fields and values of the entry
+func fieldArgs(args []interface{}, fields map[string]string) []interface{} {
+	for field, value := range fields {
+		args = append(args, field, value)
+	}
+	return args
+}
+
+// Run a blocking XREAD or XREADGROUP. The read function is called with the
+// number of milliseconds to block, until there is a reply or the given time has
+// passed. A negative block duration means waiting until there are new entries.
+func readBlocking(block time.Duration, read func(blockMilliseconds int64) (interface{}, error)) (interface{}, error) {
+	deadline := time.Now().Add(block)
+	for {
+		wait := streamBlockInterval
+		if block > 0 {
+			if remaining := time.Until(deadline); remaining < wait {
+				wait = remaining
+			}
+		}
+		// BLOCK 0 would block forever
+		milliseconds := wait.Milliseconds()
+		if milliseconds < 1 {
+			milliseconds = 1
+		}
+		reply, err := read(milliseconds)
+		if err != nil || reply != nil {
+			return reply, err
+		}
+		if block > 0 && !time.Now().Before(deadline) {
+			return nil, nil
+		}
+	}
+}
+
+// Add an entry with the given fields and values to the end of the stream.
+// Returns the ID of the new entry.
+func (rs *Stream) Add(fields map[string]string) (string, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	id, err := redis.String(conn.Do("XADD", fieldArgs([]interface{}{rs.id, "*"}, fields)...))
+	return id, wrapError("XADD", rs.id, err)
+}
+
+// Add an entry to the end of the stream, and trim the stream to the given
+// maximum length by removing the oldest entries. If approximate is true,
+// the stream may be slightly longer, but trimming is more efficient.
+// Returns the ID of the new entry.
+func (rs *Stream) AddMaxLen(fields map[string]string, maxLen int64, approximate bool) (string, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{rs.id, "MAXLEN"}
+	if approximate {
+		args = append(args, "~")
+	}
+	args = append(args, maxLen, "*")
+	id, err := redis.String(conn.Do("XADD", fieldArgs(args, fields)...))
+	return id, wrapError("XADD", rs.id, err)
+}
+
+// Trim the stream to the given maximum length, by removing the oldest entries.
+// Returns the number of removed entries.
+func (rs *Stream) Trim(maxLen int64, approximate bool) (int64, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{rs.id, "MAXLEN"}
+	if approximate {
+		args = append(args, "~")
+	}
+	removed, err := redis.Int64(conn.Do("XTRIM", append(args, maxLen)...))
+	return removed, wrapError("XTRIM", rs.id, err)
+}
+
+// Get the number of entries in the stream
+func (rs *Stream) Size() (int64, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	size, err := redis.Int64(conn.Do("XLEN", rs.id))
+	return size, wrapError("XLEN", rs.id, err)
+}
+
+// Get the entries with an ID from start to end, inclusive. Use "-" and "+"
+// for the first and last possible ID. At most count entries are returned,
+// unless count is 0 or negative.
+func (rs *Stream) Range(start, end string, count int64) ([]StreamEntry, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{rs.id, start, end}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	entries, err := streamEntries(conn.Do("XRANGE", args...))
+	return entries, wrapError("XRANGE", rs.id, err)
+}
+
+// Get the entries with an ID from end to start, inclusive, in reverse order.
+// At most count entries are returned, unless count is 0 or negative.
+func (rs *Stream) ReverseRange(end, start string, count int64) ([]StreamEntry, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{rs.id, end, start}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	entries, err := streamEntries(conn.Do("XREVRANGE", args...))
+	return entries, wrapError("XREVRANGE", rs.id, err)
+}
+
+// Read entries with an ID greater than lastID. At most count entries are
+// returned, unless count is 0 or negative. If block is positive and there are
+// no entries, wait up to that duration for new entries to be added. A negative
+// block duration means waiting until there are new entries, or until the
+// context is done. Use "$" as the lastID for only reading new entries.
+func (rs *Stream) Read(lastID string, count int64, block time.Duration) ([]StreamEntry, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	if block == 0 {
+		entries, err := readEntries(conn.Do("XREAD", append(args, "STREAMS", rs.id, lastID)...))
+		return entries, wrapError("XREAD", rs.id, err)
+	}
+	if lastID == "$" {
+		// The blocking read may be repeated, so find the current last ID
+		last, err := streamEntries(conn.Do("XREVRANGE", rs.id, "+", "-", "COUNT", 1))
+		if err != nil {
+			return nil, wrapError("XREVRANGE", rs.id, err)
+		}
+		lastID = "0-0"
+		if len(last) > 0 {
+			lastID = last[0].ID
+		}
+	}
+	entries, err := readEntries(readBlocking(block, func(milliseconds int64) (interface{}, error) {
+		return conn.Do("XREAD", append(args, "BLOCK", milliseconds, "STREAMS", rs.id, lastID)...)
+	}))
+	return entries, wrapError("XREAD", rs.id, err)
+}
+
+// Create a consumer group that starts reading after the given ID. Use "$"
+// for only reading new entries, or "0" for reading all entries. The stream
+// is created if it does not exist. Creating a group that already exists is
+// not an error.
+func (rs *Stream) CreateGroup(group, startID string) error {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	_, err := conn.Do("XGROUP", "CREATE", rs.id, group, startID, "MKSTREAM")
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "BUSYGROUP") {
+		return nil
+	}
+	return wrapError("XGROUP", rs.id, err)
+}
+
+// Remove a consumer group
+func (rs *Stream) DestroyGroup(group string) error {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	_, err := conn.Do("XGROUP", "DESTROY", rs.id, group)
+	return wrapError("XGROUP", rs.id, err)
+}
+
+// Read new entries as the given consumer in a consumer group. The entries are
+// pending until they are acknowledged with Ack. At most count entries are
+// returned, unless count is 0 or negative. If block is positive and there are
+// no new entries, wait up to that duration for new entries to be added. A
+// negative block duration means waiting until there are new entries, or until
+// the context is done.
+func (rs *Stream) ReadGroup(group, consumer string, count int64, block time.Duration) ([]StreamEntry, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{"GROUP", group, consumer}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	if block == 0 {
+		entries, err := readEntries(conn.Do("XREADGROUP", append(args, "STREAMS", rs.id, ">")...))
+		return entries, wrapError("XREADGROUP", rs.id, err)
+	}
+	entries, err := readEntries(readBlocking(block, func(milliseconds int64) (interface{}, error) {
+		return conn.Do("XREADGROUP", append(args, "BLOCK", milliseconds, "STREAMS", rs.id, ">")...)
+	}))
+	return entries, wrapError("XREADGROUP", rs.id, err)
+}
+
+// Acknowledge entries in a consumer group, so that they are no longer pending.
+// Returns the number of acknowledged entries.
+func (rs *Stream) Ack(group string, ids ...string) (int64, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{rs.id, group}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	acked, err := redis.Int64(conn.Do("XACK", args...))
+	return acked, wrapError("XACK", rs.id, err)
+}
+
+// Get at most count pending entries in a consumer group. If consumer is not
+// empty, only the entries that are pending for that consumer are returned.
+func (rs *Stream) Pending(group, consumer string, count int64) ([]PendingEntry, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{rs.id, group, "-", "+", count}
+	if consumer != "" {
+		args = append(args, consumer)
+	}
+	values, err := redis.Values(conn.Do("XPENDING", args...))
+	if err != nil {
+		return nil, wrapError("XPENDING", rs.id, err)
+	}
+	pending := make([]PendingEntry, 0, len(values))
+	for _, value := range values {
+		var entry PendingEntry
+		var idle int64
+		fields, err := redis.Values(value, nil)
+		if err == nil {
+			_, err = redis.Scan(fields, &entry.ID, &entry.Consumer, &idle, &entry.Deliveries)
+		}
+		if err != nil {
+			return nil, wrapError("XPENDING", rs.id, err)
+		}
+		entry.Idle = time.Duration(idle) * time.Millisecond
+		pending = append(pending, entry)
+	}
+	return pending, nil
+}
+
+// Claim pending entries in a consumer group for the given consumer, if they
+// have been idle for at least minIdle. This is used for taking over entries
+// from consumers that have stopped. Returns the claimed entries.
+func (rs *Stream) Claim(group, consumer string, minIdle time.Duration, ids ...string) ([]StreamEntry, error) {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{rs.id, group, consumer, minIdle.Milliseconds()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	entries, err := streamEntries(conn.Do("XCLAIM", args...))
+	return entries, wrapError("XCLAIM", rs.id, err)
+}
+
+// Remove entries from the stream, given their IDs
+func (rs *Stream) Del(ids ...string) error {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	args := []interface{}{rs.id}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := conn.Do("XDEL", args...)
+	return wrapError("XDEL", rs.id, err)
+}
+
+// Remove this stream, including the consumer groups
+func (rs *Stream) Remove() error {
+	conn := rs.pool.get(rs.ctx, rs.dbindex)
+	defer conn.Close()
+	_, err := conn.Do("DEL", rs.id)
+	return wrapError("DEL", rs.id, err)
+}
+
+// Clear the contents
+func (rs *Stream) Clear() error {
+	return rs.Remove()
+}
@@ -0,0 +1,153 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	const streamname = "abc123_test_stream_123abc"
+	streamPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer streamPool.Close()
+
+	stream := NewStream(streamPool, streamname)
+	stream.SelectDatabase(1)
+	defer stream.Remove()
+
+	var ids []string
+	for _, event := range []string{"a", "b", "c", "d"} {
+		id, err := stream.AddMaxLen(map[string]string{"event": event}, 3, false)
+		if err != nil {
+			t.Fatalf("Error, could not add entry! %s", err)
+		}
+		ids = append(ids, id)
+	}
+	if size, err := stream.Size(); err != nil || size != 3 {
+		t.Errorf("Error, the stream should have been trimmed to 3 entries: %d %v", size, err)
+	}
+
+	// Range reads
+	entries, err := stream.Range("-", "+", 0)
+	if err != nil || len(entries) != 3 || entries[0].ID != ids[1] || entries[0].Fields["event"] != "b" {
+		t.Errorf("Error, wrong entries: %v %v", entries, err)
+	}
+	entries, err = stream.ReverseRange("+", "-", 1)
+	if err != nil || len(entries) != 1 || entries[0].Fields["event"] != "d" {
+		t.Errorf("Error, wrong entries in reverse: %v %v", entries, err)
+	}
+	entries, err = stream.Read(ids[2], 10, 0)
+	if err != nil || len(entries) != 1 || entries[0].ID != ids[3] {
+		t.Errorf("Error, wrong entries after %s: %v %v", ids[2], entries, err)
+	}
+
+	// A blocking read that times out
+	start := time.Now()
+	entries, err = stream.Read("$", 0, 50*time.Millisecond)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Error, expected no new entries: %v %v", entries, err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Error, the read did not block")
+	}
+
+	// A blocking read that gets a new entry
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		adder := NewStream(streamPool, streamname)
+		adder.SelectDatabase(1)
+		adder.Add(map[string]string{"event": "e"})
+	}()
+	entries, err = stream.Read("$", 0, -1)
+	if err != nil || len(entries) != 1 || entries[0].Fields["event"] != "e" {
+		t.Errorf("Error, expected the new entry: %v %v", entries, err)
+	}
+
+	// A blocking read that is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := stream.WithContext(ctx).Read("$", 0, -1); err == nil {
+		t.Error("Error, the blocking read should have been aborted")
+	}
+
+	if n, err := stream.Trim(2, false); err != nil || n != 2 {
+		t.Errorf("Error, wrong number of trimmed entries: %d %v", n, err)
+	}
+}
+
+func TestStreamGroups(t *testing.T) {
+	const (
+		streamname = "abc123_test_stream_groups_123abc"
+		group      = "workers"
+	)
+	streamPool := checkLeaks(NewConnectionPoolHost(testHost))
+	defer streamPool.Close()
+
+	creator := NewCreator(streamPool, 1)
+	stream, err := creator.NewStream(streamname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Remove()
+
+	if err := stream.CreateGroup(group, "$"); err != nil {
+		t.Fatalf("Error, could not create consumer group! %s", err)
+	}
+	if err := stream.CreateGroup(group, "$"); err != nil {
+		t.Errorf("Error, creating an existing group should not fail: %s", err)
+	}
+	for _, job := range []string{"1", "2", "3"} {
+		if _, err := stream.Add(map[string]string{"job": job}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two consumers share the entries
+	first, err := stream.ReadGroup(group, "alice", 2, 0)
+	if err != nil || len(first) != 2 || first[0].Fields["job"] != "1" {
+		t.Fatalf("Error, wrong entries for alice: %v %v", first, err)
+	}
+	second, err := stream.ReadGroup(group, "bob", 0, 100*time.Millisecond)
+	if err != nil || len(second) != 1 || second[0].Fields["job"] != "3" {
+		t.Fatalf("Error, wrong entries for bob: %v %v", second, err)
+	}
+	if entries, err := stream.ReadGroup(group, "bob", 0, 0); err != nil || len(entries) != 0 {
+		t.Errorf("Error, there should be no more entries: %v %v", entries, err)
+	}
+
+	// Pending entries
+	pending, err := stream.Pending(group, "", 10)
+	if err != nil || len(pending) != 3 {
+		t.Fatalf("Error, expected 3 pending entries: %v %v", pending, err)
+	}
+	if pending[0].ID != first[0].ID || pending[0].Consumer != "alice" || pending[0].Deliveries != 1 {
+		t.Errorf("Error, wrong pending entry: %+v", pending[0])
+	}
+	if n, err := stream.Ack(group, first[0].ID); err != nil || n != 1 {
+		t.Errorf("Error, could not acknowledge entry: %d %v", n, err)
+	}
+	if pending, err := stream.Pending(group, "alice", 10); err != nil || len(pending) != 1 || pending[0].ID != first[1].ID {
+		t.Errorf("Error, wrong pending entries for alice: %v %v", pending, err)
+	}
+
+	// Bob takes over the stale entry from alice
+	time.Sleep(20 * time.Millisecond)
+	claimed, err := stream.Claim(group, "bob", 10*time.Millisecond, first[1].ID)
+	if err != nil || len(claimed) != 1 || claimed[0].Fields["job"] != "2" {
+		t.Errorf("Error, could not claim entry: %v %v", claimed, err)
+	}
+	if pending, err := stream.Pending(group, "bob", 10); err != nil || len(pending) != 2 {
+		t.Errorf("Error, bob should have 2 pending entries: %v %v", pending, err)
+	}
+	if claimed, err := stream.Claim(group, "alice", time.Hour, first[1].ID); err != nil || len(claimed) != 0 {
+		t.Errorf("Error, entries that are not idle should not be claimed: %v %v", claimed, err)
+	}
+
+	if err := stream.DestroyGroup(group); err != nil {
+		t.Errorf("Error, could not remove consumer group! %s", err)
+	}
+	if _, err := stream.ReadGroup(group, "alice", 0, 0); err == nil || errors.Is(err, ErrConnection) {
+		t.Errorf("Error, expected an error reply for a missing group, got: %v", err)
+	}
+}
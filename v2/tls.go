@@ -0,0 +1,78 @@
+package simpleredis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions contains the settings for connecting to Redis over TLS
+type TLSOptions struct {
+	// ServerName is used for verifying the certificate of the server.
+	// The host name of the server address is used if this is empty.
+	ServerName string
+
+	// CAFile is a PEM encoded bundle of CA certificates for verifying the
+	// server certificate. The system certificates are used if this is empty.
+	CAFile string
+
+	// CertFile and KeyFile are a PEM encoded client certificate and key,
+	// for mutual TLS. They are optional, but must be given together.
+	CertFile string
+	KeyFile  string
+
+	// InsecureSkipVerify disables the verification of the server
+	// certificate. This should only be used for local testing.
+	InsecureSkipVerify bool
+}
+
+// Config returns a TLS configuration for the given TLS options.
+// The default configuration is returned if the options are nil.
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if o == nil {
+		return config, nil
+	}
+	config.ServerName = o.ServerName
+	config.InsecureSkipVerify = o.InsecureSkipVerify
+	if o.CAFile != "" {
+		data, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in %s", o.CAFile)
+		}
+		config.RootCAs = certPool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("both a client certificate and a client key are needed for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Create a new connection pool that connects with TLS, given a host:port
+// string and TLS options. A password may be supplied as well, on the form
+// "password@host:port". The TLS options may be nil, for connecting with
+// the default TLS settings.
+// Close must be called when the pool is no longer needed.
+func NewConnectionPoolTLS(hostColonPort string, tlsOptions *TLSOptions) (*ConnectionPool, error) {
+	cu := defaultSettings()
+	cu.password, hostColonPort, _ = passwordAndHost(hostColonPort)
+	cu.network, cu.address = networkAndAddress(hostColonPort)
+	cu.useTLS = true
+	cu.tlsOptions = tlsOptions
+	return newConnectionPoolSettings(cu)
+}
@@ -0,0 +1,218 @@
+package simpleredis
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Generate a certificate and key, signed by the given parent, or self-signed
+// if the parent is nil. The PEM encoded files are written to dir.
+func writeTestCertificate(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// Start a TLS proxy in front of the local Redis server, that requires
+// client certificates signed by the CA in dir/ca.crt.
+// Returns the host:port of the proxy.
+func startTLSProxy(t *testing.T, dir string) string {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey := writeTestCertificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "simpleredis test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	writeTestCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "redis.test"},
+		DNSNames:     []string{"redis.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeTestCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "simpleredis test client"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				backend, err := net.Dial("tcp", testHost)
+				if err != nil {
+					return
+				}
+				defer backend.Close()
+				go io.Copy(backend, conn)
+				io.Copy(conn, backend)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTLSOptionsConfig(t *testing.T) {
+	config, err := (*TLSOptions)(nil).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.InsecureSkipVerify || config.RootCAs != nil {
+		t.Error("Error, the default TLS configuration should verify with the system certificates")
+	}
+	if _, err := (&TLSOptions{CertFile: "client.crt"}).Config(); err == nil {
+		t.Error("Error, a client certificate without a key should not be accepted")
+	}
+	if _, err := (&TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.crt")}).Config(); err == nil {
+		t.Error("Error, a missing CA file should not be accepted")
+	}
+}
+
+func TestConnectionPoolTLS(t *testing.T) {
+	const (
+		listname = "abc123_test_tls_123abc"
+		testdata = "123abc"
+	)
+	dir := t.TempDir()
+	address := startTLSProxy(t, dir)
+
+	tlsOptions := &TLSOptions{
+		ServerName: "redis.test",
+		CAFile:     filepath.Join(dir, "ca.crt"),
+		CertFile:   filepath.Join(dir, "client.crt"),
+		KeyFile:    filepath.Join(dir, "client.key"),
+	}
+	tlsPool, err := NewConnectionPoolTLS(address, tlsOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsPool.Close()
+	checkLeaks(tlsPool)
+	if err := tlsPool.Ping(); err != nil {
+		t.Fatalf("Error, could not ping over TLS: %s", err)
+	}
+	list := NewList(tlsPool, listname)
+	list.SelectDatabase(1)
+	if err := list.Add(testdata); err != nil {
+		t.Errorf("Error, could not add item to list over TLS! %s", err)
+	}
+	if items, err := list.All(); err != nil {
+		t.Error(err)
+	} else if len(items) != 1 || items[0] != testdata {
+		t.Errorf("Error, wrong list contents! %v", items)
+	}
+	if err := list.Remove(); err != nil {
+		t.Errorf("Error, could not remove list! %s", err)
+	}
+
+	// The same settings, given as an URL
+	urlPool, err := NewConnectionPoolURL("rediss://" + address + "/1?tls_server_name=redis.test" +
+		"&tls_ca_file=" + filepath.Join(dir, "ca.crt") +
+		"&tls_cert_file=" + filepath.Join(dir, "client.crt") +
+		"&tls_key_file=" + filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer urlPool.Close()
+	if err := urlPool.Ping(); err != nil {
+		t.Errorf("Error, could not ping over TLS with an URL: %s", err)
+	}
+
+	// Without a client certificate, the server should reject the connection
+	noCertPool, err := NewConnectionPoolTLS(address, &TLSOptions{ServerName: "redis.test", CAFile: tlsOptions.CAFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noCertPool.Close()
+	if err := noCertPool.Ping(); err == nil {
+		t.Error("Error, the connection should fail without a client certificate")
+	}
+
+	// Without the CA, the server certificate can not be verified
+	noCAPool, err := NewConnectionPoolTLS(address, &TLSOptions{CertFile: tlsOptions.CertFile, KeyFile: tlsOptions.KeyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noCAPool.Close()
+	if err := noCAPool.Ping(); err == nil {
+		t.Error("Error, the server certificate should not be trusted without the CA")
+	}
+
+	// Unless the verification is skipped
+	insecurePool, err := NewConnectionPoolTLS(address, &TLSOptions{CertFile: tlsOptions.CertFile, KeyFile: tlsOptions.KeyFile, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer insecurePool.Close()
+	if err := insecurePool.Ping(); err != nil {
+		t.Errorf("Error, could not ping when skipping the verification: %s", err)
+	}
+}
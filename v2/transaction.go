@@ -0,0 +1,211 @@
+package simpleredis
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrWatchedKeyChanged is returned by Exec when a watched key was changed
+// before the transaction was executed, so that no operations were executed
+var ErrWatchedKeyChanged = errors.New("a watched key was changed")
+
+// Transaction is a list of operations on lists, sets, hash maps and
+// key/values that are executed atomically, with MULTI and EXEC. Keys can be
+// watched with WATCH first, so that the transaction is only executed if no
+// watched keys were changed in the meantime. A transaction uses the same
+// connection until it is closed, and Close must be called when done.
+type Transaction struct {
+	pool    *ConnectionPool
+	dbindex int
+	ctx     context.Context
+	// The connection is retrieved from the pool when it is first needed
+	conn  redis.Conn
+	batch *Batch
+}
+
+// Create a new transaction, for the database index of the pool
+func (pool *ConnectionPool) NewTransaction() *Transaction {
+	return &Transaction{pool: pool, dbindex: pool.state().dbindex, batch: pool.NewBatch()}
+}
+
+// Select a different database. Must be called before the transaction is used.
+func (tx *Transaction) SelectDatabase(dbindex int) {
+	tx.dbindex = dbindex
+}
+
+// WithContext returns a copy of the transaction where all commands use the
+// given context. Must be called before the transaction is used.
+func (tx *Transaction) WithContext(ctx context.Context) *Transaction {
+	c := *tx
+	c.ctx = ctx
+	c.batch = tx.batch.WithContext(ctx)
+	return &c
+}
+
+// The connection that is used for this transaction
+func (tx *Transaction) connection() redis.Conn {
+	if tx.conn == nil {
+		tx.conn = tx.pool.get(tx.ctx, tx.dbindex)
+	}
+	return tx.conn
+}
+
+// Watch the given keys, so that Exec fails with ErrWatchedKeyChanged if any
+// of them are changed before the transaction is executed
+func (tx *Transaction) Watch(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	_, err := tx.connection().Do("WATCH", args...)
+	return wrapError("WATCH", keys[0], err)
+}
+
+// Watch the list with the given id
+func (tx *Transaction) WatchList(id string) error {
+	return tx.Watch(tx.pool.structureID(id))
+}
+
+// Watch the set with the given id
+func (tx *Transaction) WatchSet(id string) error {
+	return tx.Watch(tx.pool.structureID(id))
+}
+
+// Watch an element of the hash map with the given id
+func (tx *Transaction) WatchHashMap(id, elementid string) error {
+	return tx.Watch(tx.pool.structureID(id) + ":" + elementid)
+}
+
+// Watch a key of the key/value with the given id
+func (tx *Transaction) WatchKeyValue(id, key string) error {
+	return tx.Watch(tx.pool.structureID(id) + ":" + key)
+}
+
+// List returns a value for adding operations on the list with the given id to the transaction
+func (tx *Transaction) List(id string) *BatchList {
+	return tx.batch.List(id)
+}
+
+// Set returns a value for adding operations on the set with the given id to the transaction
+func (tx *Transaction) Set(id string) *BatchSet {
+	return tx.batch.Set(id)
+}
+
+// HashMap returns a value for adding operations on the hash map with the given id to the transaction
+func (tx *Transaction) HashMap(id string) *BatchHashMap {
+	return tx.batch.HashMap(id)
+}
+
+// KeyValue returns a value for adding operations on the key/value with the given id to the transaction
+func (tx *Transaction) KeyValue(id string) *BatchKeyValue {
+	return tx.batch.KeyValue(id)
+}
+
+// Len returns the number of operations that are waiting to be executed
+func (tx *Transaction) Len() int {
+	return tx.batch.Len()
+}
+
+// Execute all the operations atomically, and set the results. Returns
+// ErrWatchedKeyChanged if a watched key was changed, or else the first error
+// of the operations, if any. The keys are no longer watched afterwards, and
+// the transaction can be used again.
+func (tx *Transaction) Exec() error {
+	ops := tx.batch.ops
+	tx.batch.ops = nil
+	conn := tx.connection()
+	if err := conn.Send("MULTI"); err != nil {
+		return failOps(ops, err)
+	}
+	for _, op := range ops {
+		if err := conn.Send(op.command, op.args...); err != nil {
+			return failOps(ops, err)
+		}
+	}
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		// The transaction was aborted, for instance because of a wrong
+		// number of arguments, or the connection failed
+		return failOps(ops, err)
+	}
+	if reply == nil {
+		for _, op := range ops {
+			if op.result != nil {
+				op.result.err = ErrWatchedKeyChanged
+			}
+		}
+		return ErrWatchedKeyChanged
+	}
+	replies, err := redis.Values(reply, nil)
+	if err != nil || len(replies) != len(ops) {
+		if err == nil {
+			err = errors.New("wrong number of replies from EXEC")
+		}
+		return failOps(ops, err)
+	}
+	var firstErr error
+	for i, op := range ops {
+		if op.result == nil {
+			continue
+		}
+		op.result.reply, op.result.err = replies[i], nil
+		if replyErr, ok := replies[i].(redis.Error); ok {
+			op.result.reply, op.result.err = nil, wrapError(op.command, op.key, replyErr)
+		}
+		if firstErr == nil {
+			firstErr = op.result.err
+		}
+	}
+	return firstErr
+}
+
+// Discard the operations that are waiting to be executed, and stop watching all keys
+func (tx *Transaction) Discard() error {
+	tx.batch.ops = nil
+	if tx.conn == nil {
+		return nil
+	}
+	_, err := tx.conn.Do("UNWATCH")
+	return wrapError("UNWATCH", "", err)
+}
+
+// Close the transaction, and return the connection to the pool.
+// Operations that have not been executed are discarded.
+func (tx *Transaction) Close() error {
+	tx.batch.ops = nil
+	if tx.conn == nil {
+		return nil
+	}
+	// Closing the connection also stops watching all keys
+	err := tx.conn.Close()
+	tx.conn = nil
+	return err
+}
+
+// RetryTransaction creates a transaction and calls the given function with
+// it, which may watch keys, read values and add operations. The operations
+// are then executed. If a watched key was changed, this is tried again, up
+// to the given number of attempts. If the function returns an error, the
+// transaction is discarded and the error is returned.
+func (pool *ConnectionPool) RetryTransaction(attempts int, f func(tx *Transaction) error) error {
+	err := ErrWatchedKeyChanged
+	for i := 0; i < attempts && errors.Is(err, ErrWatchedKeyChanged); i++ {
+		err = pool.tryTransaction(f)
+	}
+	return err
+}
+
+// Create a transaction, call the given function with it and execute it
+func (pool *ConnectionPool) tryTransaction(f func(tx *Transaction) error) error {
+	tx := pool.NewTransaction()
+	defer tx.Close()
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Exec()
+}
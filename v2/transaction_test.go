@@ -0,0 +1,137 @@
+package simpleredis
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	txPool := NewConnectionPoolHost(testHost)
+	defer txPool.Close()
+	checkLeaks(txPool)
+
+	const (
+		fromname    = "abc123_test_tx_from"
+		toname      = "abc123_test_tx_to"
+		hashmapname = "abc123_test_tx_users"
+	)
+	from := NewSet(txPool, fromname)
+	to := NewSet(txPool, toname)
+	users := NewHashMap(txPool, hashmapname)
+	defer from.Remove()
+	defer to.Remove()
+	defer users.Remove()
+
+	if err := from.Add("bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move bob from one set to another, and update the hash map
+	tx := txPool.NewTransaction()
+	defer tx.Close()
+	tx.Set(fromname).Del("bob")
+	added := tx.Set(toname).Add("bob")
+	tx.HashMap(hashmapname).Set("bob", "group", toname)
+	if err := tx.Exec(); err != nil {
+		t.Fatalf("Error, could not execute the transaction! %s", err)
+	}
+	if n, err := added.Int64(); err != nil || n != 1 {
+		t.Errorf("Error, wrong result: %d %v", n, err)
+	}
+	if has, err := to.Has("bob"); err != nil || !has {
+		t.Errorf("Error, bob should have been moved! %v", err)
+	}
+	if has, err := from.Has("bob"); err != nil || has {
+		t.Errorf("Error, bob should have been moved! %v", err)
+	}
+	if group, err := users.Get("bob", "group"); err != nil || group != toname {
+		t.Errorf("Error, wrong group: %s %v", group, err)
+	}
+
+	// A watched key that is changed by someone else aborts the transaction
+	if err := tx.WatchHashMap(hashmapname, "bob"); err != nil {
+		t.Fatalf("Error, could not watch! %s", err)
+	}
+	if err := users.Set("bob", "group", "other"); err != nil {
+		t.Fatal(err)
+	}
+	result := tx.Set(fromname).Add("bob")
+	if err := tx.Exec(); !errors.Is(err, ErrWatchedKeyChanged) {
+		t.Errorf("Error, expected ErrWatchedKeyChanged! %v", err)
+	}
+	if !errors.Is(result.Err(), ErrWatchedKeyChanged) {
+		t.Errorf("Error, expected ErrWatchedKeyChanged! %v", result.Err())
+	}
+	if has, err := from.Has("bob"); err != nil || has {
+		t.Errorf("Error, the operations should not have been executed! %v", err)
+	}
+
+	// Errors for single operations do not abort the transaction
+	wrongType := tx.batch.add("INCR", toname)
+	incremented := tx.KeyValue(hashmapname).Inc("counter")
+	if err := tx.Exec(); err == nil || wrongType.Err() == nil {
+		t.Errorf("Error, expected an error for INCR on a set!")
+	}
+	if s, err := incremented.String(); err != nil || s != "1" {
+		t.Errorf("Error, wrong value: %s %v", s, err)
+	}
+	NewKeyValue(txPool, hashmapname).Del("counter")
+}
+
+func TestRetryTransaction(t *testing.T) {
+	txPool := NewConnectionPoolHost(testHost)
+	defer txPool.Close()
+	checkLeaks(txPool)
+
+	kv := NewKeyValue(txPool, "abc123_test_tx_kv")
+	defer kv.Remove()
+	if err := kv.Set("balance", "100"); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	err := txPool.RetryTransaction(5, func(tx *Transaction) error {
+		attempts++
+		if err := tx.WatchKeyValue("abc123_test_tx_kv", "balance"); err != nil {
+			return err
+		}
+		value, err := kv.Get("balance")
+		if err != nil {
+			return err
+		}
+		balance, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// Someone else changes the balance after it was read
+			if err := kv.Set("balance", "50"); err != nil {
+				return err
+			}
+		}
+		tx.KeyValue("abc123_test_tx_kv").Set("balance", strconv.Itoa(balance+10))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error, the transaction failed! %s", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Error, wrong number of attempts: %d", attempts)
+	}
+	if value, err := kv.Get("balance"); err != nil || value != "60" {
+		t.Errorf("Error, wrong balance: %s %v", value, err)
+	}
+
+	// Give up after the given number of attempts
+	err = txPool.RetryTransaction(3, func(tx *Transaction) error {
+		if err := tx.WatchKeyValue("abc123_test_tx_kv", "balance"); err != nil {
+			return err
+		}
+		tx.KeyValue("abc123_test_tx_kv").Set("balance", "0")
+		return kv.Set("balance", "1")
+	})
+	if !errors.Is(err, ErrWatchedKeyChanged) {
+		t.Errorf("Error, expected ErrWatchedKeyChanged! %v", err)
+	}
+}
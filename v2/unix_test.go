@@ -0,0 +1,101 @@
+package simpleredis
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// Start a proxy that listens on a unix domain socket in front of the local
+// Redis server. Returns the path to the socket.
+func startUnixProxy(t *testing.T) string {
+	socketPath := filepath.Join(t.TempDir(), "redis.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix domain sockets are not supported: %s", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				backend, err := net.Dial("tcp", testHost)
+				if err != nil {
+					return
+				}
+				defer backend.Close()
+				go io.Copy(backend, conn)
+				io.Copy(conn, backend)
+			}()
+		}
+	}()
+	return socketPath
+}
+
+func TestNetworkAndAddress(t *testing.T) {
+	for hostColonPort, expected := range map[string][2]string{
+		"localhost:6379":                   {"tcp", "localhost:6379"},
+		" :6379 ":                          {"tcp", ":6379"},
+		"/var/run/redis/redis.sock":        {"unix", "/var/run/redis/redis.sock"},
+		"redis.sock":                       {"unix", "redis.sock"},
+		"unix:/tmp/redis":                  {"unix", "/tmp/redis"},
+		"unix:///var/run/redis/redis.sock": {"unix", "/var/run/redis/redis.sock"},
+	} {
+		network, address := networkAndAddress(hostColonPort)
+		if network != expected[0] || address != expected[1] {
+			t.Errorf("Error, wrong network and address for %q: %s %s", hostColonPort, network, address)
+		}
+	}
+}
+
+func TestConnectionPoolUnix(t *testing.T) {
+	const (
+		listname = "abc123_test_unix_123abc"
+		testdata = "123abc"
+	)
+	socketPath := startUnixProxy(t)
+
+	if err := TestConnectionHost(socketPath); err != nil {
+		t.Errorf("Error, could not connect to the unix domain socket: %s", err)
+	}
+	if err := TestConnectionHost(filepath.Join(filepath.Dir(socketPath), "missing.sock")); err == nil {
+		t.Error("Error, connecting to a missing unix domain socket should fail")
+	}
+
+	unixPool := checkLeaks(NewConnectionPoolHost(socketPath))
+	defer unixPool.Close()
+	if err := unixPool.Ping(); err != nil {
+		t.Fatalf("Error, could not ping over a unix domain socket: %s", err)
+	}
+	list := NewList(unixPool, listname)
+	list.SelectDatabase(1)
+	if err := list.Add(testdata); err != nil {
+		t.Errorf("Error, could not add item to list! %s", err)
+	}
+	if items, err := list.All(); err != nil {
+		t.Error(err)
+	} else if len(items) != 1 || items[0] != testdata {
+		t.Errorf("Error, wrong list contents! %v", items)
+	}
+
+	// The same list, through an URL with a database index
+	urlPool, err := NewConnectionPoolURL("unix://" + socketPath + "?db=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer urlPool.Close()
+	if items, err := NewList(urlPool, listname).All(); err != nil {
+		t.Error(err)
+	} else if len(items) != 1 || items[0] != testdata {
+		t.Errorf("Error, wrong list contents through the unix:// URL! %v", items)
+	}
+
+	if err := list.Remove(); err != nil {
+		t.Errorf("Error, could not remove list! %s", err)
+	}
+}
@@ -0,0 +1,319 @@
+package simpleredis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Settings for connecting to a Redis server, for instance parsed from an URL
+type connectionSettings struct {
+	network        string
+	address        string
+	username       string
+	password       string
+	dbindex        int
+	useHello       bool
+	useTLS         bool
+	tlsOptions     *TLSOptions
+	tlsConfig      *tls.Config
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+
+	// Pool settings
+	maxIdle         int
+	maxActive       int
+	wait            bool
+	maxConnLifetime time.Duration
+	testOnBorrow    func(c redis.Conn, t time.Time) error
+	scanCount       int
+
+	// Replica settings
+	replicas       []string
+	readPolicy     ReadPolicy
+	readYourWrites time.Duration
+}
+
+// Connection settings with the current default timeouts and pool settings
+func defaultSettings() *connectionSettings {
+	defaultsMutex.RLock()
+	defer defaultsMutex.RUnlock()
+	return &connectionSettings{
+		network:        "tcp",
+		connectTimeout: connectTimeout,
+		readTimeout:    readTimeout,
+		writeTimeout:   writeTimeout,
+		idleTimeout:    idleTimeout,
+		maxIdle:        maxIdleConnections,
+	}
+}
+
+// Parse a timeout given as an URL query parameter.
+// Both durations ("500ms", "2s") and plain numbers of seconds ("2") are accepted.
+func parseTimeout(name, value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s in URL: %q", name, value)
+	}
+	return d, nil
+}
+
+// Parse a database index, as given in the URL path or as a query parameter
+func parseDatabaseIndex(value string) (int, error) {
+	dbindex, err := strconv.Atoi(value)
+	if err != nil || dbindex < 0 {
+		return 0, fmt.Errorf("invalid database index in URL: %q", value)
+	}
+	return dbindex, nil
+}
+
+// Parse an URL on the form redis://[[username]:password@]host[:port][/database][?option=value]
+// The rediss:// scheme is the same, but with TLS.
+// For unix:// URLs, the path is the unix domain socket.
+func parseURL(rawurl string) (*connectionSettings, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	cu := defaultSettings()
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		cu.useTLS = true
+	case "unix":
+		// The path is the unix domain socket, the database index can be given as an option
+		if u.Path == "" {
+			return nil, errors.New("no unix domain socket path in URL")
+		}
+		cu.network = "unix"
+		cu.address = u.Path
+	default:
+		return nil, fmt.Errorf("invalid URL scheme: %q", u.Scheme)
+	}
+
+	// Host and port, where both are optional
+	if cu.network == "tcp" {
+		host, port := u.Hostname(), u.Port()
+		if host == "" {
+			host = "localhost"
+		}
+		if port == "" {
+			port = "6379"
+		}
+		cu.address = net.JoinHostPort(host, port)
+	}
+
+	// Username and password
+	if u.User != nil {
+		cu.username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			cu.password = password
+		} else if cu.username != "" {
+			// Like redis-cli, treat a single value in the user info as the password
+			cu.password = cu.username
+			cu.username = ""
+		}
+	}
+
+	// Database index
+	if path := strings.Trim(u.Path, "/"); path != "" && cu.network == "tcp" {
+		if cu.dbindex, err = parseDatabaseIndex(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Options
+	for name, values := range u.Query() {
+		if len(values) == 0 {
+			continue
+		}
+		value := values[len(values)-1]
+		switch name {
+		case "db":
+			cu.dbindex, err = parseDatabaseIndex(value)
+		case "dial_timeout", "connect_timeout":
+			cu.connectTimeout, err = parseTimeout(name, value)
+		case "read_timeout":
+			cu.readTimeout, err = parseTimeout(name, value)
+		case "write_timeout":
+			cu.writeTimeout, err = parseTimeout(name, value)
+		case "idle_timeout":
+			cu.idleTimeout, err = parseTimeout(name, value)
+		case "hello":
+			if cu.useHello, err = strconv.ParseBool(value); err != nil {
+				err = fmt.Errorf("invalid %s in URL: %q", name, value)
+			}
+		case "tls_server_name", "tls_ca_file", "tls_cert_file", "tls_key_file", "tls_insecure_skip_verify":
+			err = cu.setTLSOption(name, value)
+		default:
+			err = fmt.Errorf("unsupported option in URL: %q", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cu.tlsOptions != nil && !cu.useTLS {
+		return nil, errors.New("the TLS options in the URL require the rediss:// scheme")
+	}
+	return cu, nil
+}
+
+// Set one of the tls_* options that can be given in an URL
+func (cu *connectionSettings) setTLSOption(name, value string) error {
+	if cu.tlsOptions == nil {
+		cu.tlsOptions = &TLSOptions{}
+	}
+	switch name {
+	case "tls_server_name":
+		cu.tlsOptions.ServerName = value
+	case "tls_ca_file":
+		cu.tlsOptions.CAFile = value
+	case "tls_cert_file":
+		cu.tlsOptions.CertFile = value
+	case "tls_key_file":
+		cu.tlsOptions.KeyFile = value
+	case "tls_insecure_skip_verify":
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s in URL: %q", name, value)
+		}
+		cu.tlsOptions.InsecureSkipVerify = skip
+	}
+	return nil
+}
+
+// Options for redis.Dial, given the connection settings.
+// Authentication and selecting the database is done by dial.
+func (cu *connectionSettings) dialOptions() []redis.DialOption {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(cu.connectTimeout),
+		redis.DialReadTimeout(cu.readTimeout),
+		redis.DialWriteTimeout(cu.writeTimeout),
+		redis.DialUseTLS(cu.useTLS),
+	}
+	if cu.tlsConfig != nil {
+		options = append(options, redis.DialTLSConfig(cu.tlsConfig))
+	}
+	return options
+}
+
+// Connect, authenticate and select the database index, given the connection
+// settings. The context is used while connecting and authenticating.
+func (cu *connectionSettings) dialContext(ctx context.Context) (redis.Conn, error) {
+	conn, err := redis.DialContext(ctx, cu.network, cu.address, cu.dialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	c := &contextConn{conn, ctx}
+	if err := authenticate(c, cu.username, cu.password, cu.useHello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if cu.dbindex != 0 {
+		if _, err := c.Do("SELECT", cu.dbindex); err != nil {
+			conn.Close()
+			if authErrorKind(err) != nil {
+				// The user may not be allowed to select a database
+				return nil, &AuthError{cu.username, err}
+			}
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Connect, authenticate and select the database index, given the connection settings
+func (cu *connectionSettings) dial() (redis.Conn, error) {
+	return cu.dialContext(context.Background())
+}
+
+// Create the TLS configuration from the TLS options, if TLS is used
+func (cu *connectionSettings) loadTLSConfig() error {
+	if !cu.useTLS || cu.tlsConfig != nil {
+		return nil
+	}
+	tlsConfig, err := cu.tlsOptions.Config()
+	if err != nil {
+		return err
+	}
+	cu.tlsConfig = tlsConfig
+	return nil
+}
+
+// Create a new connection pool with the pool settings, the database index
+// and the SCAN count of the given connection settings, where new connections
+// are made with the given dial function
+func newPool(cu *connectionSettings, dialContext func(ctx context.Context) (redis.Conn, error)) *ConnectionPool {
+	redisPool := &redis.Pool{
+		// Maximum number of idle connections to the redis database
+		MaxIdle:         cu.maxIdle,
+		MaxActive:       cu.maxActive,
+		Wait:            cu.wait,
+		IdleTimeout:     cu.idleTimeout,
+		MaxConnLifetime: cu.maxConnLifetime,
+		TestOnBorrow:    cu.testOnBorrow,
+		Dial: func() (redis.Conn, error) {
+			return dialContext(context.Background())
+		},
+		DialContext: dialContext,
+	}
+	pool := copyPoolValues(redisPool)
+	state := pool.mutableState()
+	state.dbindex = cu.dbindex
+	state.scanCount = cu.scanCount
+	return pool
+}
+
+// Create a new connection pool given connection settings
+func newConnectionPoolSettings(cu *connectionSettings) (*ConnectionPool, error) {
+	if err := cu.loadTLSConfig(); err != nil {
+		return nil, err
+	}
+	if len(cu.replicas) == 0 {
+		// Connect and authenticate with the given settings
+		return newPool(cu, cu.dialContext), nil
+	}
+	replicas := newReplicaSet(cu)
+	pool := newPool(cu, replicas.dialContext)
+	pool.mutableState().replicas = replicas
+	return pool, nil
+}
+
+// Create a new connection pool given an URL on the form:
+//
+//	redis://[[username]:password@]host[:port][/database][?option=value]
+//
+// The rediss:// scheme can be used for connecting with TLS.
+// A unix domain socket can be given as unix://[[username]:password@]/path/to/redis.sock,
+// where the database index can only be given with the db option.
+// The supported options are db, dial_timeout, read_timeout, write_timeout and
+// idle_timeout. If hello=true is given, the credentials are sent with HELLO
+// instead of AUTH. Timeouts can be given as durations, like "500ms", or as seconds.
+// For rediss:// URLs, the TLSOptions can be given as tls_server_name, tls_ca_file,
+// tls_cert_file, tls_key_file and tls_insecure_skip_verify.
+// The database index is used as the default for all data structures that are
+// created with the returned connection pool.
+// Close must be called when the pool is no longer needed.
+func NewConnectionPoolURL(rawurl string) (*ConnectionPool, error) {
+	if rawurl == "" {
+		return nil, errors.New("no URL given")
+	}
+	cu, err := parseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return newConnectionPoolSettings(cu)
+}
@@ -0,0 +1,121 @@
+package simpleredis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseURL(t *testing.T) {
+	cu, err := parseURL("redis://user:p@ss@example.com:6380/3?dial_timeout=2s&read_timeout=500ms&write_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cu.address != "example.com:6380" {
+		t.Errorf("Error, wrong address: %s", cu.address)
+	}
+	if cu.username != "user" || cu.password != "p@ss" {
+		t.Errorf("Error, wrong username or password: %s %s", cu.username, cu.password)
+	}
+	if cu.dbindex != 3 {
+		t.Errorf("Error, wrong database index: %d", cu.dbindex)
+	}
+	if cu.useTLS {
+		t.Error("Error, redis:// should not use TLS")
+	}
+	if cu.connectTimeout != 2*time.Second || cu.readTimeout != 500*time.Millisecond || cu.writeTimeout != time.Second {
+		t.Errorf("Error, wrong timeouts: %v %v %v", cu.connectTimeout, cu.readTimeout, cu.writeTimeout)
+	}
+
+	cu, err = parseURL("rediss://:secret@[::1]?db=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cu.address != "[::1]:6379" || !cu.useTLS || cu.username != "" || cu.password != "secret" || cu.dbindex != 5 {
+		t.Errorf("Error, wrong values: %+v", cu)
+	}
+
+	// A single value in the user info is the password, like for redis-cli
+	cu, err = parseURL("redis://foobared@localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cu.username != "" || cu.password != "foobared" {
+		t.Errorf("Error, wrong username or password: %s %s", cu.username, cu.password)
+	}
+
+	cu, err = parseURL("unix://:secret@/var/run/redis/redis.sock?db=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cu.network != "unix" || cu.address != "/var/run/redis/redis.sock" || cu.password != "secret" || cu.dbindex != 2 {
+		t.Errorf("Error, wrong values: %+v", cu)
+	}
+
+	for _, invalid := range []string{
+		"http://localhost:6379",
+		"unix://",
+		"redis://localhost/abc",
+		"redis://localhost/-1",
+		"redis://localhost?dial_timeout=soon",
+		"redis://localhost?unknown_option=1",
+		"redis://localhost?tls_ca_file=ca.crt",
+		"rediss://localhost?tls_insecure_skip_verify=maybe",
+	} {
+		if _, err := parseURL(invalid); err == nil {
+			t.Errorf("Error, %s should not be a valid URL", invalid)
+		}
+	}
+}
+
+func TestPasswordAndHost(t *testing.T) {
+	password, host, ok := passwordAndHost("p@ss@localhost:6379")
+	if !ok || password != "p@ss" || host != "localhost:6379" {
+		t.Errorf("Error in passwordAndHost: %s %s %v", password, host, ok)
+	}
+	if _, host, ok := passwordAndHost("localhost:6379"); ok || host != "localhost:6379" {
+		t.Errorf("Error in passwordAndHost: %s %v", host, ok)
+	}
+}
+
+func TestConnectionPoolURL(t *testing.T) {
+	const (
+		listname = "abc123_test_url_123abc"
+		testdata = "123abc"
+	)
+	urlPool, err := NewConnectionPoolURL("redis://" + testHost + "/2?dial_timeout=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLeaks(urlPool)
+	defer urlPool.Close()
+	if urlPool.DatabaseIndex() != 2 {
+		t.Errorf("Error, wrong database index: %d", urlPool.DatabaseIndex())
+	}
+
+	// The database index from the URL is the default for new data structures
+	list := NewList(urlPool, listname)
+	if list.dbindex != 2 {
+		t.Errorf("Error, the list did not get the database index from the URL: %d", list.dbindex)
+	}
+	if err := list.Add(testdata); err != nil {
+		t.Errorf("Error, could not add item to list! %s", err.Error())
+	}
+
+	// The list should not be visible in database 0
+	other := NewList(urlPool, listname)
+	other.SelectDatabase(0)
+	if items, err := other.All(); err != nil {
+		t.Error(err)
+	} else if len(items) != 0 {
+		t.Errorf("Error, the list should only be in database 2: %v", items)
+	}
+
+	if items, err := list.All(); err != nil {
+		t.Error(err)
+	} else if len(items) != 1 || items[0] != testdata {
+		t.Errorf("Error, wrong list contents! %v", items)
+	}
+	if err := list.Remove(); err != nil {
+		t.Errorf("Error, could not remove list! %s", err.Error())
+	}
+}
@@ -0,0 +1,136 @@
+package simpleredis
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Event is a change of a list, set, hash map element or key/value key,
+// from a keyspace notification
+type Event struct {
+	// The element id for hash maps, or the key for key/values.
+	// Empty for lists and sets.
+	ID string
+	// The operation, which is the name of the command in lowercase, like
+	// "hset", "del" or "rpush", or "expired" or "evicted"
+	Operation string
+	// The key in Redis
+	Key string
+}
+
+// Watcher calls a function for each change of a data structure,
+// until it is closed
+type Watcher struct {
+	subscriber *Subscriber
+	done       chan struct{}
+}
+
+// Expired checks if the event is for a key that expired, or for a hash map
+// field that expired, with HPEXPIRE
+func (e Event) Expired() bool {
+	return e.Operation == "expired" || e.Operation == "hexpired"
+}
+
+// Evicted checks if the event is for a key that was evicted because of the memory limit
+func (e Event) Evicted() bool {
+	return e.Operation == "evicted"
+}
+
+// Make sure that keyspace notifications are enabled for all types of events.
+// Returns an error if the server does not allow CONFIG, since no events would
+// arrive unless the notifications are enabled in the server configuration.
+func enableKeyspaceEvents(conn redis.Conn) error {
+	values, err := redis.StringMap(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil {
+		return wrapError("CONFIG", "", err)
+	}
+	flags := values["notify-keyspace-events"]
+	missing := ""
+	// K is for keyspace notifications, and A is for all types of events
+	for _, flag := range []string{"K", "A"} {
+		if !strings.Contains(flags, flag) {
+			missing += flag
+		}
+	}
+	if missing == "" {
+		return nil
+	}
+	_, err = conn.Do("CONFIG", "SET", "notify-keyspace-events", flags+missing)
+	return wrapError("CONFIG", "", err)
+}
+
+// Watch a key, or the keys that start with a prefix, in the given database,
+// and call the given function for each keyspace notification
+func (pool *ConnectionPool) watch(dbindex int, key, prefix string, f func(Event)) (*Watcher, error) {
+	conn := pool.get(nil, dbindex)
+	err := enableKeyspaceEvents(conn)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	s, err := pool.NewSubscriber()
+	if err != nil {
+		return nil, err
+	}
+	channelPrefix := "__keyspace@" + strconv.Itoa(dbindex) + "__:"
+	if prefix != "" {
+		err = s.PSubscribe(channelPrefix + escapePattern(prefix) + "*")
+	} else {
+		err = s.Subscribe(channelPrefix + key)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	w := &Watcher{subscriber: s, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		for m := range s.Messages() {
+			e := Event{Operation: m.Data, Key: strings.TrimPrefix(m.Channel, channelPrefix)}
+			if prefix != "" {
+				e.ID = strings.TrimPrefix(e.Key, prefix)
+			}
+			f(e)
+		}
+	}()
+	return w, nil
+}
+
+// Close the watcher. Waits until the function is done with the current event.
+func (w *Watcher) Close() error {
+	err := w.subscriber.Close()
+	<-w.done
+	return err
+}
+
+// Watch calls the given function for each change of the list, from
+// keyspace notifications, until the returned Watcher is closed.
+// Keyspace notifications are enabled on the server with CONFIG if needed.
+func (rl *List) Watch(f func(Event)) (*Watcher, error) {
+	return rl.pool.watch(rl.dbindex, rl.id, "", f)
+}
+
+// Watch calls the given function for each change of the set, from
+// keyspace notifications, until the returned Watcher is closed.
+// Keyspace notifications are enabled on the server with CONFIG if needed.
+func (rs *Set) Watch(f func(Event)) (*Watcher, error) {
+	return rs.pool.watch(rs.dbindex, rs.id, "", f)
+}
+
+// Watch calls the given function for each change of an element in the
+// hash map, from keyspace notifications, until the returned Watcher is
+// closed. The element id is given in the event. Keyspace notifications
+// are enabled on the server with CONFIG if needed.
+func (rh *HashMap) Watch(f func(Event)) (*Watcher, error) {
+	return rh.pool.watch(rh.dbindex, "", rh.id+":", f)
+}
+
+// Watch calls the given function for each change of a key, from keyspace
+// notifications, until the returned Watcher is closed. The key is given as
+// the ID of the event. Keyspace notifications are enabled on the server if
+// needed, with CONFIG.
+func (rkv *KeyValue) Watch(f func(Event)) (*Watcher, error) {
+	return rkv.pool.watch(rkv.dbindex, "", rkv.id+":", f)
+}
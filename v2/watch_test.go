@@ -0,0 +1,130 @@
+package simpleredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/simpleredis/v2/redistest"
+)
+
+// Receive an event, or fail if no event arrives in time
+func receiveEvent(t *testing.T, events chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("Error, no event was received!")
+	}
+	return Event{}
+}
+
+func TestWatch(t *testing.T) {
+	watchPool := NewConnectionPoolHost(testHost)
+	defer watchPool.Close()
+	checkLeaks(watchPool)
+
+	// Restore the keyspace notification setting afterwards
+	conn := watchPool.Get(watchPool.DatabaseIndex())
+	config, err := redis.StringMap(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		conn := watchPool.Get(watchPool.DatabaseIndex())
+		conn.Do("CONFIG", "SET", "notify-keyspace-events", config["notify-keyspace-events"])
+		conn.Close()
+	}()
+
+	list := NewList(watchPool, "abc123_test_watch_list")
+	users := NewHashMap(watchPool, "abc123_test_watch_users")
+	kv := NewKeyValue(watchPool, "abc123_test_watch_kv")
+	defer list.Remove()
+	defer users.Remove()
+	defer kv.Remove()
+
+	events := make(chan Event, 10)
+	var watchers []*Watcher
+	for _, watch := range []func(func(Event)) (*Watcher, error){list.Watch, users.Watch, kv.Watch} {
+		w, err := watch(func(e Event) { events <- e })
+		if err != nil {
+			t.Fatalf("Error, could not watch! %s", err)
+		}
+		watchers = append(watchers, w)
+	}
+	defer func() {
+		for _, w := range watchers {
+			w.Close()
+		}
+	}()
+
+	list.Add("a")
+	if e := receiveEvent(t, events); e.Operation != "rpush" || e.ID != "" || e.Key != list.id {
+		t.Errorf("Error, wrong event: %v", e)
+	}
+
+	users.Set("b:ob", "password", "hunter1")
+	if e := receiveEvent(t, events); e.Operation != "hset" || e.ID != "b:ob" {
+		t.Errorf("Error, wrong event: %v", e)
+	}
+	users.Del("b:ob")
+	if e := receiveEvent(t, events); e.Operation != "del" || e.ID != "b:ob" {
+		t.Errorf("Error, wrong event: %v", e)
+	}
+
+	kv.SetExpire("token", "123abc", 50*time.Millisecond)
+	if e := receiveEvent(t, events); e.Operation != "set" || e.ID != "token" || e.Expired() {
+		t.Errorf("Error, wrong event: %v", e)
+	}
+	// Setting an expiration time gives an "expire" event in some Redis versions
+	e := receiveEvent(t, events)
+	if e.Operation == "expire" {
+		e = receiveEvent(t, events)
+	}
+	if !e.Expired() || e.ID != "token" {
+		t.Errorf("Error, wrong event: %v", e)
+	}
+
+	// No more events after closing
+	for _, w := range watchers {
+		w.Close()
+	}
+	list.Add("b")
+	select {
+	case e := <-events:
+		t.Errorf("Error, unexpected event: %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatchConfigDenied(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	pool := checkLeaks(NewConnectionPoolHost(s.Addr))
+	defer pool.Close()
+	list := NewList(pool, "abc123_test_watch_denied")
+
+	// Without CONFIG, the notifications can not be enabled
+	s.Handle("CONFIG", func(args []string) interface{} {
+		return errors.New("NOPERM this user has no permissions to run the 'config|get' command")
+	})
+	if w, err := list.Watch(func(Event) {}); err == nil {
+		w.Close()
+		t.Error("Error, watching should fail when CONFIG GET is not allowed!")
+	}
+
+	// Neither if the setting can be read, but not changed
+	s.Handle("CONFIG", func(args []string) interface{} {
+		if args[1] == "GET" {
+			return []interface{}{"notify-keyspace-events", ""}
+		}
+		return errors.New("NOPERM this user has no permissions to run the 'config|set' command")
+	})
+	if w, err := list.Watch(func(Event) {}); err == nil {
+		w.Close()
+		t.Error("Error, watching should fail when CONFIG SET is not allowed!")
+	}
+}